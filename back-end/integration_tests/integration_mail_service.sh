@@ -17,8 +17,7 @@ then
   sudo apt-get install -y jq
 fi
 
-# Define user details
-USERNAME="testuser"
+# Define the test recipient
 MAILADDRESS="murat.tunc8558@gmail.com"
 
 
@@ -28,6 +27,10 @@ BASE_URL="http://localhost:$MAIL_SERVICE_PORT"
 HEALTH_CHECK_URL="$BASE_URL/health"
 SEND_AUTH_CODE_MAIL_URL="$BASE_URL/send-auth-code-mail"
 DELETE_MAIL_URL="$BASE_URL/delete-mail"
+SEND_URL="$BASE_URL/v1/send"
+
+# Client credentials for /v1/send and /delete-mail, the first pair of MAIL_SERVICE_SEND_CLIENTS
+SEND_CLIENT="${MAIL_SERVICE_SEND_CLIENTS%%,*}"
 
 
 
@@ -72,8 +75,8 @@ send_auth_code_mail() {
 
   # Prepare the request body
   REQUEST_BODY='{
-    "username": "'$USERNAME'",
-    "mailAddress": "'$MAILADDRESS'"
+    "mailAddress": "'$MAILADDRESS'",
+    "purpose": "signup"
   }'
 
   # Define the HTTP request type
@@ -105,15 +108,20 @@ send_auth_code_mail() {
 }
 
 
-# Function to delete user by username and mail address
+# Function to delete the verification challenges of the mail address
 delete_mail() {
-  echo "===>TEST END POINT-->DELETE USER"
+  echo "===>TEST END POINT-->DELETE VERIFICATION CHALLENGES"
   echo
   echo "REQUEST URL: $DELETE_MAIL_URL"  # Using DELETE_MAIL_URL parameter
 
-  # Prepare the request body with both username and mailAddress
+  if [ -z "$SEND_CLIENT" ]; then
+    echo "⚠️ MAIL_SERVICE_SEND_CLIENTS not set, skipping."
+    echo
+    return
+  fi
+
+  # Prepare the request body with the mailAddress
   REQUEST_BODY='{
-    "username": "'$USERNAME'",
     "mailAddress": "'$MAILADDRESS'"
   }'
 
@@ -122,10 +130,10 @@ delete_mail() {
 
   # Print the full curl command and request type
   echo "REQUEST TYPE: $REQUEST_TYPE"
-  echo "COMMAND: curl -X $REQUEST_TYPE \"$DELETE_MAIL_URL\" -H \"Content-Type: application/json\" -d '$REQUEST_BODY'"
+  echo "COMMAND: curl -X $REQUEST_TYPE \"$DELETE_MAIL_URL\" -u \"$SEND_CLIENT\" -H \"Content-Type: application/json\" -d '$REQUEST_BODY'"
 
   # Send the request and capture the response
-  DELETE_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$DELETE_MAIL_URL" -H "Content-Type: application/json" -d "$REQUEST_BODY")
+  DELETE_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$DELETE_MAIL_URL" -u "$SEND_CLIENT" -H "Content-Type: application/json" -d "$REQUEST_BODY")
 
   # Extract response body and HTTP status code
   HTTP_BODY=$(echo "$DELETE_RESPONSE" | sed '$ d')
@@ -135,13 +143,77 @@ delete_mail() {
   echo "HTTP Status Code: $HTTP_STATUS"
 
   if [ "$HTTP_STATUS" -eq 200 ]; then
-    echo "User deleted successfully!"
+    echo "Verification challenges deleted successfully!"
   else
-    echo "❌ User deletion failed with status code $HTTP_STATUS. Response: $HTTP_BODY"
+    echo "❌ Verification challenge deletion failed with status code $HTTP_STATUS. Response: $HTTP_BODY"
+    exit 1
+  fi
+
+  echo "✅ Verification challenges deleted successfully."
+  echo
+}
+
+
+# Function to test the internal send API, sending the same idempotency key twice
+send_mail() {
+  echo "===>TEST END POINT-->SEND MAIL"
+  echo
+  echo "REQUEST URL: $SEND_URL"
+
+  if [ -z "$SEND_CLIENT" ]; then
+    echo "⚠️ MAIL_SERVICE_SEND_CLIENTS not set, skipping."
+    echo
+    return
+  fi
+
+  # Prepare the request body
+  IDEMPOTENCY_KEY="integration-test-$(date +%s)"
+  REQUEST_BODY='{
+    "template": "invitation",
+    "to": ["'$MAILADDRESS'"],
+    "data": {
+      "InvitedBy": "integration test",
+      "Role": "SalesRepresentative",
+      "AcceptURL": "https://example.com/accept-invitation?token=test",
+      "ExpiresAt": "'$(date -u -d "+1 day" +%Y-%m-%dT%H:%M:%SZ)'"
+    },
+    "idempotencyKey": "'$IDEMPOTENCY_KEY'"
+  }'
+
+  # Define the HTTP request type
+  REQUEST_TYPE="POST"
+
+  # Print the full curl command and request type
+  echo "REQUEST TYPE: $REQUEST_TYPE"
+  echo "COMMAND: curl -X $REQUEST_TYPE \"$SEND_URL\" -u \"$SEND_CLIENT\" -H \"Content-Type: application/json\" -d '$REQUEST_BODY'"
+
+  for ATTEMPT in 1 2; do
+    # Send the request and capture the response
+    SEND_RESPONSE=$(curl -s -w "\n%{http_code}" -X $REQUEST_TYPE "$SEND_URL" -u "$SEND_CLIENT" -H "Content-Type: application/json" -d "$REQUEST_BODY")
+
+    # Extract response body and HTTP status code
+    HTTP_BODY=$(echo "$SEND_RESPONSE" | sed '$ d')
+    HTTP_STATUS=$(echo "$SEND_RESPONSE" | tail -n1)
+
+    echo "Send Mail response ($ATTEMPT): $HTTP_BODY"
+    echo "HTTP Status Code: $HTTP_STATUS"
+
+    # Check if the HTTP status code is 202
+    if [ "$HTTP_STATUS" -ne 202 ]; then
+      echo "❌ Error: Failed to send mail."
+      exit 1
+    fi
+
+    MESSAGE_IDS[$ATTEMPT]=$(echo "$HTTP_BODY" | jq -c '[.messages[].id]')
+  done
+
+  # The retry must return the messages of the first request
+  if [ "${MESSAGE_IDS[1]}" != "${MESSAGE_IDS[2]}" ]; then
+    echo "❌ Error: Retry with the same idempotency key queued new messages."
     exit 1
   fi
 
-  echo "✅ User deleted successfully."
+  echo "✅ Mail queued once for both requests!"
   echo
 }
 
@@ -157,8 +229,8 @@ show_database_table(){
       exit 1
   fi
 
-  # Run the query to list all rows in the 'users' table
-  docker exec -i "$CONTAINER_ID" psql -U "$MAIL_POSTGRES_DB_USER" -d "$MAIL_POSTGRES_DB_NAME" -c "SELECT * FROM users;"
+  # Run the query to list all rows in the 'verification_challenges' table
+  docker exec -i "$CONTAINER_ID" psql -U "$MAIL_POSTGRES_DB_USER" -d "$MAIL_POSTGRES_DB_NAME" -c "SELECT * FROM verification_challenges;"
 
 }
 
@@ -170,6 +242,8 @@ health_check
 send_auth_code_mail
 show_database_table
 
+send_mail
+
 
 delete_mail
 show_database_table
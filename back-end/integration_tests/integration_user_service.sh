@@ -27,7 +27,7 @@ echo -e "********************************************************************"
 USERNAME="testuser"
 MAILADDRESS="testuser@example.com"
 PASSWORD="TestPassword123"
-ROLE="Admin"
+ROLE="SalesRepresentative" # Admin and Manager accounts can only be created through invitations
 
 # Define new parameters
 NEW_PASSWORD="NewTestPassword123"
@@ -54,6 +54,15 @@ UPDATE_ROLE_URL="$BASE_URL/update-role"
 DELETE_USER_URL="$BASE_URL/delete-user"
 
 
+# Proof that a mail address was verified for a signup, as POST /verify-auth-code
+# of mail-service returns it: "<expiry>.<hex HMAC-SHA256 of "signup:<address>:<expiry>">"
+verification_token() {
+  EXPIRY=$(( $(date +%s) + 1800 ))
+  SIGNATURE=$(printf 'signup:%s:%s' "${1,,}" "$EXPIRY" | openssl dgst -sha256 -hmac "$USER_SERVICE_VERIFICATION_TOKEN_SECRET" | sed 's/^.* //')
+  echo "$EXPIRY.$SIGNATURE"
+}
+
+
 health_check() {
   echo "===>TEST END POINT--->HEALTH CHECK"
   echo
@@ -98,7 +107,8 @@ register_user() {
     "username": "'$USERNAME'",
     "mailAddress": "'$MAILADDRESS'",
     "password": "'$PASSWORD'",
-    "role": "'$ROLE'"
+    "role": "'$ROLE'",
+    "verificationToken": "'$(verification_token "$MAILADDRESS")'"
   }'
 
   # Define the HTTP request type
@@ -242,12 +252,13 @@ deactivate_user() {
   echo "Deactivate response: $HTTP_BODY"
   echo "HTTP Status Code: $HTTP_STATUS"
 
-  if [ "$HTTP_STATUS" -ne 200 ]; then
-    echo "❌ Error: User deactivation failed."
+  # Only admins and the managers of a user may (de)activate it
+  if [ "$HTTP_STATUS" -ne 403 ]; then
+    echo "❌ Error: Self-deactivation by a $ROLE was not refused."
     exit 1
   fi
 
-  echo "✅ User deactivated successfully."
+  echo "✅ Self-deactivation refused."
   echo
 }
 
@@ -284,12 +295,13 @@ activate_user() {
   echo "Activate response: $HTTP_BODY"
   echo "HTTP Status Code: $HTTP_STATUS"
 
-  if [ "$HTTP_STATUS" -ne 200 ]; then
-    echo "❌ Error: User activation failed."
+  # Only admins and the managers of a user may (de)activate it
+  if [ "$HTTP_STATUS" -ne 403 ]; then
+    echo "❌ Error: Self-activation by a $ROLE was not refused."
     exit 1
   fi
 
-  echo "✅ User activated successfully."
+  echo "✅ Self-activation refused."
   echo
 }
 
@@ -303,12 +315,10 @@ update_user() {
   # Construct JSON payload dynamically
   JSON_PAYLOAD=$(jq -n \
     --arg username "$USERNAME" \
-    --arg email "$EMAIL" \
-    --arg role "$ROLE" \
+    --arg password "$PASSWORD" \
     '{
       username: $username,
-      email: ($email // empty),
-      role: ($role // empty)
+      password: $password
     }')
 
   # Print the JSON payload
@@ -482,12 +492,13 @@ update_role() {
   echo "Update role response: $HTTP_BODY"
   echo "HTTP Status Code: $HTTP_STATUS"
 
-  if [ "$HTTP_STATUS" -ne 200 ]; then
-    echo "❌ Error: Role update failed."
+  # Only admins may change roles, a $ROLE cannot promote themselves
+  if [ "$HTTP_STATUS" -ne 403 ]; then
+    echo "❌ Error: Self-promotion by a $ROLE was not refused."
     exit 1
   fi
 
-  echo "✅ Role updated successfully."
+  echo "✅ Self-promotion refused."
   echo
 }
 
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Attachment is a file an internal service uploaded to attach to mail sent
+// through /v1/send. One upload can be attached to any number of messages, a
+// logo is uploaded once and a quote once per buyer.
+type Attachment struct {
+	ID          uint      `gorm:"primaryKey"`
+	ClientID    string    `gorm:"not null;index"` // Only the client that uploaded it can attach it
+	Filename    string    `gorm:"not null"`
+	ContentType string    `gorm:"not null"`
+	Size        int       `gorm:"not null"`
+	SHA256      string    `gorm:"not null"`
+	Content     []byte    `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+// MessageAttachment attaches an Attachment to an outgoing message, inline when
+// ContentID is set
+type MessageAttachment struct {
+	ID           uint       `gorm:"primaryKey"`
+	MessageID    uint       `gorm:"not null;index"`
+	AttachmentID uint       `gorm:"not null;index"`
+	ContentID    string     // The HTML refers to the image as cid:<ContentID>
+	Attachment   Attachment `gorm:"constraint:OnDelete:RESTRICT"`
+}
+
+// Attachment size limits, see MAIL_SERVICE_ATTACHMENT_MAX_SIZE and MAIL_SERVICE_MESSAGE_ATTACHMENTS_MAX_SIZE
+var attachmentMaxSize, messageAttachmentsMaxSize, attachmentLimitsErr = parseAttachmentLimits()
+
+// parseAttachmentLimits reads the size limit of one attachment (default 10MB)
+// and of all attachments of a message (default 15MB, about 20MB once base64
+// encoded, below the 25MB most providers accept)
+func parseAttachmentLimits() (int, int, error) {
+	one, err := parseSize(AttachmentMaxSize, 10<<20)
+	if err != nil {
+		return 0, 0, err
+	}
+	total, err := parseSize(MessageAttachmentsMaxSize, 15<<20)
+	if err != nil {
+		return 0, 0, err
+	}
+	return one, total, nil
+}
+
+// parseSize reads a size in bytes, optionally with a KB or MB suffix (powers of 1024)
+func parseSize(s string, fallback int) (int, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	number, unit := strings.TrimSpace(s), 1
+	for suffix, multiplier := range map[string]int{"KB": 1 << 10, "MB": 1 << 20} {
+		if strings.HasSuffix(strings.ToUpper(number), suffix) {
+			number, unit = strings.TrimSpace(number[:len(number)-len(suffix)]), multiplier
+		}
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid size %q, expected bytes or a number with KB or MB", s)
+	}
+	return n * unit, nil
+}
+
+// Executables and scripts are refused, most providers reject mail carrying them
+var blockedAttachmentExtensions = map[string]bool{
+	".bat": true, ".cmd": true, ".com": true, ".cpl": true, ".exe": true, ".jar": true, ".js": true,
+	".msi": true, ".ps1": true, ".scr": true, ".vbe": true, ".vbs": true, ".wsf": true,
+}
+
+// contentIDPattern limits inline Content-IDs to characters safe in a header and a cid: URL
+var contentIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// detectContentType picks the content type of an upload: the one the client
+// declared, else the one of the file extension, else one sniffed from the content
+func detectContentType(declared, filename string, content []byte) (string, error) {
+	if declared != "" {
+		mediaType, params, err := mime.ParseMediaType(declared)
+		if err != nil {
+			return "", fmt.Errorf("invalid content type %q", declared)
+		}
+		return mime.FormatMediaType(mediaType, params), nil
+	}
+	if byExtension := mime.TypeByExtension(strings.ToLower(path.Ext(filename))); byExtension != "" {
+		return byExtension, nil
+	}
+	return http.DetectContentType(content), nil
+}
+
+// AttachmentRef attaches an uploaded attachment to mail sent through /v1/send
+type AttachmentRef struct {
+	ID        uint   `json:"id"`
+	ContentID string `json:"contentId"` // Shows an image inline where the HTML refers to cid:<contentId>
+}
+
+// ErrInvalidAttachment is returned for attachment references that cannot be sent
+var ErrInvalidAttachment = errors.New("invalid attachment")
+
+// resolveAttachments checks the references of a send request against the
+// attachments of the client and the size limit of a message. The attachment
+// rows stay share-locked until tx ends, so DeleteAttachmentHandler waits for
+// the messages carrying them to be queued and then sees them as pending.
+func resolveAttachments(tx *gorm.DB, clientID string, refs []AttachmentRef) ([]MessageAttachment, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint, len(refs))
+	for i, ref := range refs {
+		ids[i] = ref.ID
+	}
+	var found []Attachment
+	err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+		Select("id", "content_type", "size").
+		Where("id IN ? AND client_id = ?", ids, clientID).
+		Order("id").
+		Find(&found).Error
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]Attachment, len(found))
+	for _, a := range found {
+		byID[a.ID] = a
+	}
+
+	attachments := make([]MessageAttachment, 0, len(refs))
+	contentIDs := map[string]bool{}
+	total := 0
+	for _, ref := range refs {
+		attachment, ok := byID[ref.ID]
+		if !ok {
+			return nil, fmt.Errorf("%w: attachment %d not found", ErrInvalidAttachment, ref.ID)
+		}
+		if ref.ContentID != "" {
+			if !contentIDPattern.MatchString(ref.ContentID) || contentIDs[ref.ContentID] {
+				return nil, fmt.Errorf("%w: content id %q is invalid or used twice", ErrInvalidAttachment, ref.ContentID)
+			}
+			if !strings.HasPrefix(attachment.ContentType, "image/") {
+				return nil, fmt.Errorf("%w: attachment %d is %s, only images are shown inline", ErrInvalidAttachment, ref.ID, attachment.ContentType)
+			}
+			contentIDs[ref.ContentID] = true
+		}
+		total += attachment.Size
+		attachments = append(attachments, MessageAttachment{AttachmentID: attachment.ID, ContentID: ref.ContentID})
+	}
+	if total > messageAttachmentsMaxSize {
+		return nil, fmt.Errorf("%w: attachments of a message are limited to %d bytes", ErrInvalidAttachment, messageAttachmentsMaxSize)
+	}
+	return attachments, nil
+}
+
+// messageAttachments loads the attachments an outgoing message carries
+func (app *Config) messageAttachments(messageID uint) ([]MailAttachment, error) {
+	var carried []MessageAttachment
+	if err := app.DB.Preload("Attachment").Where("message_id = ?", messageID).Order("id").Find(&carried).Error; err != nil {
+		return nil, err
+	}
+	attachments := make([]MailAttachment, 0, len(carried))
+	for _, c := range carried {
+		attachments = append(attachments, MailAttachment{
+			Filename:    c.Attachment.Filename,
+			ContentType: c.Attachment.ContentType,
+			ContentID:   c.ContentID,
+			Content:     c.Attachment.Content,
+		})
+	}
+	return attachments, nil
+}
+
+// AttachmentResponse is an uploaded attachment, without its content
+type AttachmentResponse struct {
+	ID          uint      `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int       `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// UploadAttachmentHandler stores the request body as an attachment named by
+// ?filename, for internal services authenticated by SendClientMiddleware.
+// ?contentType overrides the detected content type.
+func (app *Config) UploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	filename := headerValue(path.Base(r.URL.Query().Get("filename")))
+	if filename == "" || filename == "." || filename == "/" {
+		writeError(w, http.StatusBadRequest, `query parameter "filename" is required`)
+		return
+	}
+	if blockedAttachmentExtensions[strings.ToLower(path.Ext(filename))] {
+		writeError(w, http.StatusUnprocessableEntity, "Executable attachments are not allowed")
+		return
+	}
+
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(attachmentMaxSize)))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Attachments are limited to %d bytes", attachmentMaxSize))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return
+	}
+	if len(content) == 0 {
+		writeError(w, http.StatusBadRequest, "Attachment is empty")
+		return
+	}
+	contentType, err := detectContentType(r.URL.Query().Get("contentType"), filename, content)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	clientID, _, _ := r.BasicAuth()
+	sum := sha256.Sum256(content)
+	attachment := Attachment{
+		ClientID:    clientID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        len(content),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Content:     content,
+	}
+	if err := app.DB.Create(&attachment).Error; err != nil {
+		log.Printf("❌ Failed to store attachment %s for client %s: %v", filename, clientID, err)
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/attachments/%d", attachment.ID))
+	writeJSON(w, http.StatusCreated, AttachmentResponse{
+		ID:          attachment.ID,
+		Filename:    attachment.Filename,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+		SHA256:      attachment.SHA256,
+		CreatedAt:   attachment.CreatedAt,
+	})
+}
+
+// DeleteAttachmentHandler deletes an attachment of the client, unless a
+// message that still has to be sent carries it
+func (app *Config) DeleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	clientID, _, _ := r.BasicAuth()
+
+	// The row lock makes a concurrent send that references the attachment either
+	// finish queueing before the pending check or fail to find it afterwards
+	var conflict bool
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		var attachment Attachment
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ? AND client_id = ?", id, clientID).
+			First(&attachment).Error
+		if err != nil {
+			return err
+		}
+
+		var pending int64
+		err = tx.Model(&MessageAttachment{}).
+			Joins("JOIN outgoing_messages ON outgoing_messages.id = message_attachments.message_id").
+			Where("message_attachments.attachment_id = ? AND outgoing_messages.status IN ?", attachment.ID, []string{messageQueued, messageDeferred}).
+			Count(&pending).Error
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			conflict = true
+			return nil
+		}
+
+		// The message log keeps the messages, only what they carried is gone
+		if err := tx.Where("attachment_id = ?", attachment.ID).Delete(&MessageAttachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&attachment).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to delete attachment %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+	if conflict {
+		writeError(w, http.StatusConflict, "Attachment is carried by mail that was not sent yet")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shared/clientauth"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int{
+		"":        1234,
+		"2048":    2048,
+		"10KB":    10 << 10,
+		" 10 kb ": 10 << 10,
+		"15MB":    15 << 20,
+		"1mb":     1 << 20,
+	}
+	for s, want := range tests {
+		if got, err := parseSize(s, 1234); err != nil || got != want {
+			t.Errorf("%q: expected %d, got %d, %v", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"0", "-1KB", "MB", "ten", "1.5MB", "10GB"} {
+		if _, err := parseSize(s, 1234); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+// An attachment carried by mail that was not sent yet is kept, afterwards it is deleted
+func TestDeleteAttachment(t *testing.T) {
+	saved := sendClients
+	sendClients = clientauth.Credentials{"billing": "secret", "crm": "secret"}
+	t.Cleanup(func() { sendClients = saved })
+
+	app := newTestApp(t)
+	attachment := Attachment{ClientID: "billing", Filename: "invoice.pdf", ContentType: "application/pdf", Size: 3, SHA256: "-", Content: []byte("pdf")}
+	if err := app.DB.Create(&attachment).Error; err != nil {
+		t.Fatal(err)
+	}
+	msg := queueTestMessage(t, app, "invitation", "ayse@example.com")
+	if err := app.DB.Create(&MessageAttachment{MessageID: msg.ID, AttachmentID: attachment.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	remove := func(client string) int {
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/v1/attachments/%d", attachment.ID), nil)
+		req.SetBasicAuth(client, "secret")
+		return serve(app, req).Code
+	}
+	if code := remove("crm"); code != http.StatusNotFound {
+		t.Errorf("other client: expected 404, got %d", code)
+	}
+	if code := remove("billing"); code != http.StatusConflict {
+		t.Errorf("queued: expected 409, got %d", code)
+	}
+	if err := app.DB.Model(&msg).Update("status", messageSent).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code := remove("billing"); code != http.StatusNoContent {
+		t.Errorf("sent: expected 204, got %d", code)
+	}
+	if err := app.DB.First(&Attachment{}, attachment.ID).Error; err == nil {
+		t.Error("expected the attachment to be deleted")
+	}
+	if code := remove("billing"); code != http.StatusNotFound {
+		t.Errorf("deleted: expected 404, got %d", code)
+	}
+}
@@ -7,24 +7,21 @@ import (
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-)
 
-// User model for GORM
-type User struct {
-	ID          uint      `gorm:"primaryKey"`
-	Username    string    `gorm:"unique;not null"`
-	MailAddress string    `gorm:"unique;not null"`
-	AuthCode    string    `gorm:"not null"`
-	Password    string    `gorm:"not null"` // Added Password field
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
-}
+	"shared/ratelimit"
+)
 
 // Config struct to hold database connection
 type Config struct {
-	DB *gorm.DB
+	DB                *gorm.DB
+	Mailer            Mailer             // Delivers outgoing mail
+	Limiter           *ratelimit.Limiter // Rate limits, none when nil
+	ValidateResponses bool               // Check responses against the OpenAPI document (tests only)
 }
 
+// models are the tables AutoMigrate creates
+var models = []interface{}{&VerificationChallenge{}, &OutgoingMessage{}, &MessageEvent{}, &Suppression{}, &SendRequest{}, &Attachment{}, &MessageAttachment{}}
+
 // connectToDB retries connecting to PostgreSQL until it succeeds or fails after retries
 func connectToDB() (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
@@ -35,7 +32,7 @@ func connectToDB() (*gorm.DB, error) {
 
 	// Retry logic: Try connecting 10 times with a 5-second delay
 	for i := 1; i <= 10; i++ {
-		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 		if err == nil {
 			fmt.Println("✅ DATABASE connection success!")
 			break
@@ -48,11 +45,22 @@ func connectToDB() (*gorm.DB, error) {
 		log.Fatalf("❌ Failed to connect to database after retries: %v", err)
 	}
 
+	// Move off the tables of the old user model before creating the new ones
+	err = dropLegacyTables(db)
+	if err != nil {
+		log.Fatalf("❌ Failed to drop legacy tables : %v", err)
+	}
+
 	// AutoMigrate to create tables
-	err = db.AutoMigrate(&User{})
+	err = db.AutoMigrate(models...)
 	if err != nil {
 		log.Fatalf("❌ Failed to migrate database : %v", err)
 	}
 
+	err = migrateNormalizedRecipients(db)
+	if err != nil {
+		log.Fatalf("❌ Failed to migrate normalized recipients : %v", err)
+	}
+
 	return db, nil
 }
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+// DKIM algorithms, picked by the type of the private key
+const (
+	dkimRSASHA256     = "rsa-sha256"     // RFC 6376
+	dkimEd25519SHA256 = "ed25519-sha256" // RFC 8463
+)
+
+// DKIM canonicalizations (RFC 6376 section 3.4), "relaxed" survives servers
+// that rewrap headers or change whitespace
+const (
+	dkimSimple  = "simple"
+	dkimRelaxed = "relaxed"
+)
+
+// Headers signed by default, when the message has them
+var dkimDefaultHeaders = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "List-Unsubscribe", "List-Unsubscribe-Post"}
+
+// dkimSigner adds a DKIM-Signature to outgoing messages, see MAIL_SERVICE_DKIM_*
+type dkimSigner struct {
+	Domain      string
+	Selector    string
+	Algorithm   string
+	HeaderCanon string
+	BodyCanon   string
+	Headers     []string
+	key         crypto.Signer
+}
+
+// The DKIM signer, nil when MAIL_SERVICE_DKIM_DOMAIN is unset
+var dkimConfig, dkimConfigErr = parseDKIMSettings()
+
+// parseDKIMSettings reads the MAIL_SERVICE_DKIM_* variables. Signing is off
+// without a domain; with one, a selector and a private key are required.
+func parseDKIMSettings() (*dkimSigner, error) {
+	if DKIMDomain == "" {
+		return nil, nil
+	}
+	if DKIMSelector == "" {
+		return nil, errors.New("MAIL_SERVICE_DKIM_SELECTOR is required with MAIL_SERVICE_DKIM_DOMAIN")
+	}
+
+	// A PEM key in a single-line variable has its line breaks escaped
+	keyPEM := []byte(strings.ReplaceAll(DKIMPrivateKey, `\n`, "\n"))
+	if DKIMPrivateKeyFile != "" {
+		var err error
+		if keyPEM, err = os.ReadFile(DKIMPrivateKeyFile); err != nil {
+			return nil, fmt.Errorf("cannot read private key: %w", err)
+		}
+	}
+	if len(keyPEM) == 0 {
+		return nil, errors.New("MAIL_SERVICE_DKIM_PRIVATE_KEY or MAIL_SERVICE_DKIM_PRIVATE_KEY_FILE is required with MAIL_SERVICE_DKIM_DOMAIN")
+	}
+	key, algorithm, err := parseDKIMKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	headerCanon, bodyCanon, err := parseDKIMCanonicalization(valueOr(DKIMCanonicalization, dkimRelaxed+"/"+dkimRelaxed))
+	if err != nil {
+		return nil, err
+	}
+
+	headers := dkimDefaultHeaders
+	if DKIMHeaders != "" {
+		headers = nil
+		signsFrom := false
+		for _, name := range strings.Split(DKIMHeaders, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || strings.ContainsAny(name, ": \t") {
+				return nil, fmt.Errorf("invalid header name %q", name)
+			}
+			signsFrom = signsFrom || strings.EqualFold(name, "From")
+			headers = append(headers, name)
+		}
+		if !signsFrom {
+			return nil, errors.New("MAIL_SERVICE_DKIM_HEADERS must include From")
+		}
+	}
+
+	return &dkimSigner{
+		Domain:      strings.ToLower(DKIMDomain),
+		Selector:    DKIMSelector,
+		Algorithm:   algorithm,
+		HeaderCanon: headerCanon,
+		BodyCanon:   bodyCanon,
+		Headers:     headers,
+		key:         key,
+	}, nil
+}
+
+// parseDKIMKey reads a PKCS #1 RSA or a PKCS #8 RSA or Ed25519 private key
+func parseDKIMKey(keyPEM []byte) (crypto.Signer, string, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, "", errors.New("private key is not PEM encoded")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, "", fmt.Errorf("unsupported private key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid private key: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		// Verifiers ignore signatures of shorter keys (RFC 8301)
+		if k.N.BitLen() < 1024 {
+			return nil, "", fmt.Errorf("RSA key has %d bits, at least 1024 (better 2048) are required", k.N.BitLen())
+		}
+		return k, dkimRSASHA256, nil
+	case ed25519.PrivateKey:
+		return k, dkimEd25519SHA256, nil
+	}
+	return nil, "", fmt.Errorf("unsupported private key %T, expected RSA or Ed25519", key)
+}
+
+// parseDKIMCanonicalization reads "header/body" canonicalizations. A single
+// one applies to the header, the body is then simple, as with the c= tag.
+func parseDKIMCanonicalization(s string) (string, string, error) {
+	header, body, ok := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "/")
+	if !ok {
+		body = dkimSimple
+	}
+	for _, canon := range []string{header, body} {
+		if canon != dkimSimple && canon != dkimRelaxed {
+			return "", "", fmt.Errorf("invalid canonicalization %q, expected simple or relaxed for header and body, e.g. relaxed/relaxed", s)
+		}
+	}
+	return header, body, nil
+}
+
+// Sign returns the message with a DKIM-Signature header prepended
+func (s *dkimSigner) Sign(raw []byte, now time.Time) ([]byte, error) {
+	headers, body, err := splitMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	bodyHash := sha256.Sum256(canonicalBody(body, s.BodyCanon))
+
+	var signedNames []string
+	var hashed bytes.Buffer
+	used := map[int]bool{}
+	for _, name := range s.Headers {
+		if i := lastHeader(headers, name, used); i >= 0 {
+			signedNames = append(signedNames, name)
+			hashed.WriteString(canonicalHeader(headers[i], s.HeaderCanon))
+		}
+	}
+
+	field := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=%s/%s; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		s.Algorithm, s.HeaderCanon, s.BodyCanon, s.Domain, s.Selector,
+		now.Unix(), strings.Join(signedNames, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	hashed.WriteString(strings.TrimSuffix(canonicalHeader(field+"\r\n", s.HeaderCanon), "\r\n"))
+
+	digest := sha256.Sum256(hashed.Bytes())
+	var signature []byte
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case ed25519.PrivateKey:
+		// RFC 8463 signs the SHA-256 digest with PureEdDSA
+		signature = ed25519.Sign(key, digest[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var signed bytes.Buffer
+	signed.WriteString(field)
+	encoded := base64.StdEncoding.EncodeToString(signature)
+	for len(encoded) > 72 {
+		signed.WriteString(encoded[:72] + "\r\n\t")
+		encoded = encoded[72:]
+	}
+	signed.WriteString(encoded + "\r\n")
+	signed.Write(raw)
+	return signed.Bytes(), nil
+}
+
+// DNSRecord is the TXT record to publish at <selector>._domainkey.<domain>
+func (s *dkimSigner) DNSRecord() (string, error) {
+	switch key := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(key), nil
+	}
+	return "", errors.New("unsupported public key")
+}
+
+// DNSName is the name of the TXT record with the public key
+func (s *dkimSigner) DNSName() string {
+	return s.Selector + "._domainkey." + s.Domain
+}
+
+// verifyDKIM checks the first DKIM-Signature of a message with publicKey. It
+// shares only the canonicalization with Sign, so it catches signing bugs.
+func verifyDKIM(raw []byte, publicKey crypto.PublicKey) error {
+	headers, body, err := splitMessage(raw)
+	if err != nil {
+		return err
+	}
+	used := map[int]bool{}
+	sigIndex := -1
+	for i, field := range headers {
+		if strings.EqualFold(headerName(field), "DKIM-Signature") {
+			sigIndex = i
+			break
+		}
+	}
+	if sigIndex < 0 {
+		return errors.New("message has no DKIM-Signature")
+	}
+	used[sigIndex] = true
+	tags := dkimTags(headers[sigIndex])
+
+	if tags["v"] != "1" {
+		return fmt.Errorf("unsupported version v=%s", tags["v"])
+	}
+	headerCanon, bodyCanon, err := parseDKIMCanonicalization(valueOr(tags["c"], dkimSimple))
+	if err != nil {
+		return err
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody(body, bodyCanon))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return errors.New("body hash does not match bh=, the body was changed after signing")
+	}
+
+	var hashed bytes.Buffer
+	for _, name := range strings.Split(tags["h"], ":") {
+		if i := lastHeader(headers, strings.TrimSpace(name), used); i >= 0 {
+			hashed.WriteString(canonicalHeader(headers[i], headerCanon))
+		}
+	}
+	hashed.WriteString(strings.TrimSuffix(canonicalHeader(withoutSignature(headers[sigIndex]), headerCanon), "\r\n"))
+	digest := sha256.Sum256(hashed.Bytes())
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("invalid b=: %w", err)
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != dkimRSASHA256 {
+			return fmt.Errorf("signed with a=%s, the key is RSA", tags["a"])
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("signature does not match the headers")
+		}
+	case ed25519.PublicKey:
+		if tags["a"] != dkimEd25519SHA256 {
+			return fmt.Errorf("signed with a=%s, the key is Ed25519", tags["a"])
+		}
+		if !ed25519.Verify(key, digest[:], signature) {
+			return errors.New("signature does not match the headers")
+		}
+	default:
+		return fmt.Errorf("unsupported public key %T", publicKey)
+	}
+	return nil
+}
+
+// splitMessage splits a message with CRLF line endings into its header fields,
+// each with its folded lines and final CRLF, and its body
+func splitMessage(raw []byte) ([]string, []byte, error) {
+	end := bytes.Index(raw, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, nil, errors.New("message has no header/body separator")
+	}
+	var fields []string
+	for _, line := range strings.SplitAfter(string(raw[:end+2]), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields, raw[end+4:], nil
+}
+
+// headerName returns the name of a header field
+func headerName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimSpace(name)
+}
+
+// lastHeader returns the index of the last field called name not used yet and
+// marks it used, -1 if there is none. Repeated names sign instances bottom-up.
+func lastHeader(fields []string, name string, used map[int]bool) int {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if !used[i] && strings.EqualFold(headerName(fields[i]), name) {
+			used[i] = true
+			return i
+		}
+	}
+	return -1
+}
+
+// canonicalHeader canonicalizes a header field ending in CRLF
+func canonicalHeader(field, canon string) string {
+	if canon == dkimSimple {
+		return field
+	}
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.Join(strings.Fields(value), " ") + "\r\n"
+}
+
+// canonicalBody canonicalizes a body: trailing empty lines are removed and the
+// body ends with CRLF; relaxed also collapses whitespace within lines
+func canonicalBody(body []byte, canon string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if canon == dkimRelaxed {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(collapseWhitespace(line), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if canon == dkimSimple {
+			return []byte("\r\n")
+		}
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWhitespace replaces runs of spaces and tabs with one space
+func collapseWhitespace(line string) string {
+	var b strings.Builder
+	space := false
+	for _, c := range line {
+		if c == ' ' || c == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(c)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// dkimTags parses the tag=value list of a DKIM-Signature field or DNS record.
+// Whitespace is removed from values, base64 values may be folded.
+func dkimTags(field string) map[string]string {
+	if name, value, ok := strings.Cut(field, ":"); ok && strings.EqualFold(strings.TrimSpace(name), "DKIM-Signature") {
+		field = value
+	}
+	tags := map[string]string{}
+	for _, tag := range strings.Split(field, ";") {
+		name, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(value), "")
+	}
+	return tags
+}
+
+// withoutSignature empties the b= tag of a DKIM-Signature field, as it was
+// when the signature was computed
+func withoutSignature(field string) string {
+	tags := strings.Split(strings.TrimSuffix(field, "\r\n"), ";")
+	for i, tag := range tags {
+		if name, _, ok := strings.Cut(tag, "="); ok && strings.TrimSpace(name) == "b" {
+			tags[i] = name + "="
+		}
+	}
+	return strings.Join(tags, ";") + "\r\n"
+}
+
+// dkimRecordKey returns the public key of a published DKIM TXT record
+func dkimRecordKey(record string) (crypto.PublicKey, error) {
+	tags := dkimTags(record)
+	if tags["p"] == "" {
+		return nil, errors.New("record has no public key, it was revoked")
+	}
+	der, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid p=: %w", err)
+	}
+	switch valueOr(tags["k"], "rsa") {
+	case "rsa":
+		if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+			return key, nil
+		}
+		return x509.ParsePKCS1PublicKey(der)
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(der), nil
+	}
+	return nil, fmt.Errorf("unsupported key type k=%s", tags["k"])
+}
+
+// DKIMSelfTestResponse reports whether a signed message verifies
+type DKIMSelfTestResponse struct {
+	Domain           string   `json:"domain"`
+	Selector         string   `json:"selector"`
+	Algorithm        string   `json:"algorithm"`
+	Canonicalization string   `json:"canonicalization"`
+	SignedHeaders    []string `json:"signedHeaders"`
+	Template         string   `json:"template"`
+	Verified         bool     `json:"verified"` // With the configured key
+	Error            string   `json:"error,omitempty"`
+	DNSName          string   `json:"dnsName"`
+	DNSRecord        string   `json:"dnsRecord"`          // To publish at dnsName
+	PublishedRecord  string   `json:"publishedRecord"`    // Found at dnsName, empty if none
+	DNSVerified      bool     `json:"dnsVerified"`        // With the published key, as receivers verify
+	DNSError         string   `json:"dnsError,omitempty"` // Why the published key does not verify
+	Message          string   `json:"message"`            // The signed message
+}
+
+// DKIMSelfTestHandler renders a template (?template, default auth-code), signs
+// it like outgoing mail and verifies the signature locally with the configured
+// key and with the key published in DNS
+func (app *Config) DKIMSelfTestHandler(w http.ResponseWriter, r *http.Request) {
+	if dkimConfig == nil {
+		writeError(w, http.StatusServiceUnavailable, "DKIM signing is not configured")
+		return
+	}
+	name := valueOr(r.URL.Query().Get("template"), "auth-code")
+	spec, ok := mailTemplates[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Template not found")
+		return
+	}
+
+	locale := resolveLocale(r.URL.Query().Get("locale"), r.Header.Get("Accept-Language"))
+	rendered, err := RenderTemplate(name, locale, spec.Sample, nil)
+	if err != nil {
+		log.Printf("❌ Failed to render mail template %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "Failed to render template")
+		return
+	}
+	recipient := "dkim-self-test@" + dkimConfig.Domain
+	raw, err := (&Message{
+		From:    mailerConfig.From,
+		To:      []string{recipient},
+		Subject: rendered.Subject,
+		Text:    rendered.Text,
+		HTML:    rendered.HTML,
+		Headers: listUnsubscribeHeaders(recipient, spec.Category),
+	}).Bytes(time.Now())
+	if err != nil {
+		log.Printf("❌ Failed to sign DKIM self-test: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to sign message")
+		return
+	}
+
+	record, err := dkimConfig.DNSRecord()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	headers, _, _ := splitMessage(raw)
+	response := DKIMSelfTestResponse{
+		Domain:           dkimConfig.Domain,
+		Selector:         dkimConfig.Selector,
+		Algorithm:        dkimConfig.Algorithm,
+		Canonicalization: dkimConfig.HeaderCanon + "/" + dkimConfig.BodyCanon,
+		SignedHeaders:    strings.Split(dkimTags(headers[0])["h"], ":"),
+		Template:         name,
+		DNSName:          dkimConfig.DNSName(),
+		DNSRecord:        record,
+		Message:          string(raw),
+	}
+	if err := verifyDKIM(raw, dkimConfig.key.Public()); err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Verified = true
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	txt, err := net.DefaultResolver.LookupTXT(ctx, dkimConfig.DNSName())
+	for _, record := range txt {
+		if strings.Contains(record, "p=") {
+			response.PublishedRecord = record
+			break
+		}
+	}
+	switch {
+	case err != nil:
+		response.DNSError = "lookup failed: " + err.Error()
+	case response.PublishedRecord == "":
+		response.DNSError = "no DKIM TXT record"
+	default:
+		key, err := dkimRecordKey(response.PublishedRecord)
+		if err == nil {
+			err = verifyDKIM(raw, key)
+		}
+		if err != nil {
+			response.DNSError = err.Error()
+		} else {
+			response.DNSVerified = true
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// dkimAligned reports whether the DKIM domain aligns with the sender address
+// for DMARC (relaxed alignment: the same domain or a parent of it)
+func dkimAligned(from mail.Address, domain string) bool {
+	_, fromDomain, _ := strings.Cut(strings.ToLower(from.Address), "@")
+	return fromDomain == domain || strings.HasSuffix(fromDomain, "."+domain)
+}
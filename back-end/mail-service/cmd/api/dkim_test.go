@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// crlf converts a message written with LF line endings to the CRLF of the wire
+func crlf(s string) []byte {
+	return []byte(strings.ReplaceAll(s, "\n", "\r\n"))
+}
+
+// The signed example message of RFC 8463 appendix A.3 and the public key of
+// its brisbane._domainkey.football.example.com record (appendix A.2)
+const (
+	rfc8463Message = `DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;
+ d=football.example.com; i=@football.example.com;
+ q=dns/txt; s=brisbane; t=1528637909; h=from : to :
+ subject : date : message-id : from : subject : date;
+ bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;
+ b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus
+ Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==
+From: Joe SixPack <joe@football.example.com>
+To: Suzie Q <suzie@shopping.example.net>
+Subject: Is dinner ready?
+Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)
+Message-ID: <20030712040037.46341.5F8J@football.example.com>
+
+Hi.
+
+We lost the game.  Are you hungry yet?
+
+Joe.
+`
+	rfc8463Record = "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+)
+
+// The verifier accepts the signature of another implementation
+func TestVerifyDKIMKnownVector(t *testing.T) {
+	key, err := dkimRecordKey(rfc8463Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDKIM(crlf(rfc8463Message), key); err != nil {
+		t.Fatalf("expected the RFC 8463 example to verify, got %v", err)
+	}
+
+	tampered := strings.Replace(rfc8463Message, "Is dinner ready?", "Is lunch ready?", 1)
+	if err := verifyDKIM(crlf(tampered), key); err == nil {
+		t.Fatal("expected a changed header to fail")
+	}
+	tampered = strings.Replace(rfc8463Message, "We lost the game.", "We won the game.", 1)
+	if err := verifyDKIM(crlf(tampered), key); err == nil {
+		t.Fatal("expected a changed body to fail")
+	}
+}
+
+// The canonicalization examples of RFC 6376 section 3.4.5
+func TestCanonicalizationRFC6376Example(t *testing.T) {
+	message := "A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"
+	headers, body, err := splitMessage([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("expected 2 header fields, got %q", headers)
+	}
+
+	var relaxed, simple string
+	for _, field := range headers {
+		relaxed += canonicalHeader(field, dkimRelaxed)
+		simple += canonicalHeader(field, dkimSimple)
+	}
+	if want := "a:X\r\nb:Y Z\r\n"; relaxed != want {
+		t.Errorf("relaxed header: expected %q, got %q", want, relaxed)
+	}
+	if want := "A: X\r\nB : Y\t\r\n\tZ  \r\n"; simple != want {
+		t.Errorf("simple header: expected %q, got %q", want, simple)
+	}
+
+	if got, want := string(canonicalBody(body, dkimRelaxed)), " C\r\nD E\r\n"; got != want {
+		t.Errorf("relaxed body: expected %q, got %q", want, got)
+	}
+	if got, want := string(canonicalBody(body, dkimSimple)), " C \r\nD \t E\r\n"; got != want {
+		t.Errorf("simple body: expected %q, got %q", want, got)
+	}
+}
+
+// Empty bodies canonicalize as RFC 6376 section 3.4.3 and 3.4.4 define
+func TestCanonicalBodyEmpty(t *testing.T) {
+	for _, body := range []string{"", "\r\n", "\r\n\r\n"} {
+		if got := string(canonicalBody([]byte(body), dkimSimple)); got != "\r\n" {
+			t.Errorf("simple %q: expected CRLF, got %q", body, got)
+		}
+		if got := canonicalBody([]byte(body), dkimRelaxed); len(got) != 0 {
+			t.Errorf("relaxed %q: expected nothing, got %q", body, got)
+		}
+	}
+}
+
+const testMessage = `From: Zeheb <noreply@zeheb.example>
+To: buyer@example.net
+Subject: Your   quote
+Date: Mon, 19 Oct 2026 10:00:00 +0000
+Message-ID: <42@zeheb.example>
+MIME-Version: 1.0
+Content-Type: text/plain; charset=utf-8
+
+Hello,
+
+your quote is attached.
+
+
+`
+
+// Messages signed with every key type and canonicalization verify, and stop
+// verifying when a signed header or the body changes
+func TestSignVerifyRoundTrip(t *testing.T) {
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]crypto.Signer{dkimEd25519SHA256: ed25519Key, dkimRSASHA256: rsaKey}
+
+	for algorithm, key := range keys {
+		for _, canon := range [][2]string{{dkimSimple, dkimSimple}, {dkimRelaxed, dkimRelaxed}, {dkimRelaxed, dkimSimple}} {
+			signer := &dkimSigner{Domain: "zeheb.example", Selector: "mail", Algorithm: algorithm,
+				HeaderCanon: canon[0], BodyCanon: canon[1], Headers: dkimDefaultHeaders, key: key}
+			name := algorithm + " " + canon[0] + "/" + canon[1]
+
+			signed, err := signer.Sign(crlf(testMessage), time.Unix(1792417991, 0))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			record, err := signer.DNSRecord()
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			public, err := dkimRecordKey(record)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if err := verifyDKIM(signed, public); err != nil {
+				t.Fatalf("%s: expected the signature to verify, got %v", name, err)
+			}
+
+			if err := verifyDKIM([]byte(strings.Replace(string(signed), "Your   quote", "Your invoice", 1)), public); err == nil {
+				t.Errorf("%s: expected a changed subject to fail", name)
+			}
+			if err := verifyDKIM([]byte(strings.Replace(string(signed), "attached", "missing", 1)), public); err == nil {
+				t.Errorf("%s: expected a changed body to fail", name)
+			}
+			if err := verifyDKIM([]byte(strings.Replace(string(signed), "From: Zeheb", "From: Evil", 1)), public); err == nil {
+				t.Errorf("%s: expected a changed sender to fail", name)
+			}
+		}
+	}
+}
+
+// Relaxed canonicalization survives servers that rewrap headers and change whitespace
+func TestVerifyRelaxedRewrapped(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &dkimSigner{Domain: "zeheb.example", Selector: "mail", Algorithm: dkimEd25519SHA256,
+		HeaderCanon: dkimRelaxed, BodyCanon: dkimRelaxed, Headers: dkimDefaultHeaders, key: key}
+	signed, err := signer.Sign(crlf(testMessage), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped := strings.NewReplacer("Subject: Your   quote", "subject:Your\r\n\tquote", "your quote is attached.", "your  quote\tis attached.  ").Replace(string(signed))
+	if err := verifyDKIM([]byte(rewrapped), key.Public()); err != nil {
+		t.Fatalf("expected the rewrapped message to verify, got %v", err)
+	}
+}
+
+// A key of one type does not verify signatures claiming another algorithm
+func TestVerifyDKIMWrongKey(t *testing.T) {
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDKIM(crlf(rfc8463Message), other); err == nil {
+		t.Fatal("expected another key to fail")
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDKIM(crlf(rfc8463Message), rsaKey.Public()); err == nil {
+		t.Fatal("expected an RSA key to fail for an ed25519-sha256 signature")
+	}
+	if _, err := dkimRecordKey("v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Fatal("expected a truncated Ed25519 key to be rejected")
+	}
+	if _, err := dkimRecordKey("v=DKIM1; p="); err == nil {
+		t.Fatal("expected a revoked key to be rejected")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ErrNotDSN is returned for a message that is not a delivery status notification
+var ErrNotDSN = errors.New("not a delivery status notification")
+
+// parseDSN extracts one delivery event per recipient from a delivery status
+// notification (RFC 3464): a multipart/report with a message/delivery-status
+// part and usually the original message or its headers, which carry the
+// Message-ID the events refer to.
+func parseDSN(raw []byte) ([]deliveryEvent, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotDSN, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") {
+		return nil, ErrNotDSN
+	}
+
+	timestamp, err := msg.Header.Date()
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	var recipients []textproto.MIMEHeader
+	var messageID string
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotDSN, err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			if recipients, err = readDeliveryStatus(part); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrNotDSN, err)
+			}
+		case "message/rfc822", "message/global", "text/rfc822-headers", "message/global-headers":
+			// Only the headers of the original message are needed
+			header, err := textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader()
+			if err != nil && len(header) == 0 {
+				continue
+			}
+			messageID = header.Get("Message-Id")
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("%w: no recipient fields", ErrNotDSN)
+	}
+
+	events := make([]deliveryEvent, 0, len(recipients))
+	for _, fields := range recipients {
+		eventType, ok := dsnEventType(fields.Get("Action"))
+		if !ok {
+			continue
+		}
+		events = append(events, deliveryEvent{
+			Type:      eventType,
+			MessageID: messageID,
+			Recipient: dsnAddress(fields.Get("Final-Recipient")),
+			Timestamp: timestamp,
+			Reason:    dsnReason(fields),
+		})
+	}
+	return events, nil
+}
+
+// readDeliveryStatus reads the blocks of a delivery-status part: per-message
+// fields first, then one block per recipient, separated by blank lines
+func readDeliveryStatus(r io.Reader) ([]textproto.MIMEHeader, error) {
+	tp := textproto.NewReader(bufio.NewReader(r))
+	var recipients []textproto.MIMEHeader
+	for {
+		fields, err := tp.ReadMIMEHeader()
+		if fields.Get("Final-Recipient") != "" {
+			recipients = append(recipients, fields)
+		}
+		if err == io.EOF {
+			return recipients, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// dsnEventType maps the Action of a recipient to a delivery event type.
+// Relayed and expanded messages left our reach, like delivered ones.
+func dsnEventType(action string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(action)) {
+	case "failed":
+		return messageBounced, true
+	case "delayed":
+		return messageDeferred, true
+	case "delivered", "relayed", "expanded":
+		return messageDelivered, true
+	}
+	return "", false
+}
+
+// dsnAddress returns the address of a recipient field ("rfc822; a@b.c")
+func dsnAddress(field string) string {
+	return strings.Trim(dsnValue(field), "<>")
+}
+
+// dsnValue strips the type from a typed field, such as "smtp; 550 ..."
+func dsnValue(field string) string {
+	if _, value, ok := strings.Cut(field, ";"); ok {
+		field = value
+	}
+	return strings.TrimSpace(field)
+}
+
+// dsnReason combines the status code and diagnostic of a recipient
+func dsnReason(fields textproto.MIMEHeader) string {
+	reason := strings.TrimSpace(fields.Get("Status"))
+	if diagnostic := dsnValue(fields.Get("Diagnostic-Code")); diagnostic != "" {
+		if reason != "" {
+			reason += " "
+		}
+		reason += diagnostic
+	}
+	return headerValue(reason)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A bounce for two recipients, one failed and one delayed, modeled on RFC 3464 appendix B
+const testDSN = `From: MAILER-DAEMON@mx.example.net
+To: noreply@zeheb.example
+Date: Tue, 20 Oct 2026 10:15:00 +0000
+Subject: Delivery Status Notification (Failure)
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="RAA14128.773615765/mx.example.net"
+
+--RAA14128.773615765/mx.example.net
+Content-Type: text/plain
+
+Your message could not be delivered to some recipients.
+
+--RAA14128.773615765/mx.example.net
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.net
+Arrival-Date: Tue, 20 Oct 2026 10:14:58 +0000
+
+Final-Recipient: rfc822; <buyer@example.net>
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 User unknown
+
+Final-Recipient: rfc822; seller@example.net
+Action: delayed
+Status: 4.2.2
+
+--RAA14128.773615765/mx.example.net
+Content-Type: text/rfc822-headers
+
+From: noreply@zeheb.example
+To: buyer@example.net, seller@example.net
+Subject: Your quote
+Message-ID: <42.1760955298@zeheb.example>
+
+--RAA14128.773615765/mx.example.net--
+`
+
+func TestParseDSN(t *testing.T) {
+	events, err := parseDSN([]byte(strings.ReplaceAll(testDSN, "\n", "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Date(2026, 10, 20, 10, 15, 0, 0, time.UTC)
+	want := []deliveryEvent{
+		{Type: messageBounced, MessageID: "<42.1760955298@zeheb.example>", Recipient: "buyer@example.net", Timestamp: timestamp, Reason: "5.1.1 550 5.1.1 User unknown"},
+		{Type: messageDeferred, MessageID: "<42.1760955298@zeheb.example>", Recipient: "seller@example.net", Timestamp: timestamp, Reason: "4.2.2"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	for i := range want {
+		if !events[i].Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("event %d: expected timestamp %v, got %v", i, want[i].Timestamp, events[i].Timestamp)
+		}
+		events[i].Timestamp = want[i].Timestamp
+		if events[i] != want[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, want[i], events[i])
+		}
+	}
+}
+
+// Ordinary mail and malformed reports are not DSNs
+func TestParseDSNRejects(t *testing.T) {
+	messages := map[string]string{
+		"plain mail":    "From: a@example.net\nContent-Type: text/plain\n\nHello\n",
+		"other report":  strings.Replace(testDSN, "report-type=delivery-status", "report-type=disposition-notification", 1),
+		"no recipients": strings.NewReplacer("Final-Recipient: rfc822; <buyer@example.net>\n", "", "Final-Recipient: rfc822; seller@example.net\n", "").Replace(testDSN),
+		"not a message": "",
+		"broken parts":  strings.Replace(testDSN, "--RAA14128.773615765/mx.example.net--", "", 1)[:400],
+	}
+	for name, raw := range messages {
+		if _, err := parseDSN([]byte(raw)); !errors.Is(err, ErrNotDSN) {
+			t.Errorf("%s: expected ErrNotDSN, got %v", name, err)
+		}
+	}
+}
+
+// Recipients with an unknown action are skipped, the others are kept
+func TestParseDSNUnknownAction(t *testing.T) {
+	raw := strings.Replace(testDSN, "Action: delayed", "Action: postponed", 1)
+	events, err := parseDSN([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Recipient != "buyer@example.net" {
+		t.Fatalf("expected only the failed recipient, got %+v", events)
+	}
+}
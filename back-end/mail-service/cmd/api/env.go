@@ -14,11 +14,61 @@ var (
 	DBName      = os.Getenv("MAIL_POSTGRES_DB_NAME")
 	ServicePort = os.Getenv("MAIL_SERVICE_PORT")
 	ServiceName = os.Getenv("MAIL_SERVICE_NAME")
+
+	MailTransport = os.Getenv("MAIL_SERVICE_MAIL_TRANSPORT") // "smtp" (default), "file", "maildir" or "log"
+	MailDir       = os.Getenv("MAIL_SERVICE_MAIL_DIR")       // Output directory of the file and maildir transports, default ./mail
+	MailFrom      = os.Getenv("MAIL_SERVICE_MAIL_FROM")      // Sender address, default MAIL_SERVICE_SMTP_EMAIL
+	MailFromName  = os.Getenv("MAIL_SERVICE_MAIL_FROM_NAME") // Optional sender display name
+	DefaultLocale = os.Getenv("MAIL_SERVICE_DEFAULT_LOCALE") // Language of mails without a locale, "en" (default) or "tr"
+	JWTSecret     = os.Getenv("MAIL_SERVICE_JWT_SECRET")     // Same secret as USER_SERVICE_JWT_SECRET, for admin endpoints
+
+	AuthCodeSecret          = os.Getenv("MAIL_SERVICE_AUTH_CODE_SECRET")          // Key of the auth code HMACs, shared by all instances
+	VerificationTokenSecret = os.Getenv("MAIL_SERVICE_VERIFICATION_TOKEN_SECRET") // Same secret as USER_SERVICE_VERIFICATION_TOKEN_SECRET, signs proof of verified codes
+	WebhookSecret           = os.Getenv("MAIL_SERVICE_WEBHOOK_SECRET")            // Signs inbound delivery event and DSN webhooks, they are disabled when unset
+
+	SendClients               = os.Getenv("MAIL_SERVICE_SEND_CLIENTS")                 // Comma-separated "clientID:secret" pairs of internal services allowed to call /v1/send
+	AttachmentMaxSize         = os.Getenv("MAIL_SERVICE_ATTACHMENT_MAX_SIZE")          // Bytes, or with a KB or MB suffix, default 10MB
+	MessageAttachmentsMaxSize = os.Getenv("MAIL_SERVICE_MESSAGE_ATTACHMENTS_MAX_SIZE") // All attachments of one message, default 15MB
+
+	PublicURL         = os.Getenv("MAIL_SERVICE_PUBLIC_URL")         // Base URL of this service as recipients reach it, for unsubscribe links
+	UnsubscribeSecret = os.Getenv("MAIL_SERVICE_UNSUBSCRIBE_SECRET") // Signs unsubscribe tokens, List-Unsubscribe is omitted when unset
+	SuppressionExempt = os.Getenv("MAIL_SERVICE_SUPPRESSION_EXEMPT") // Categories sent despite suppressions, default "transactional", "none" for no exemptions
+
+	DKIMDomain           = os.Getenv("MAIL_SERVICE_DKIM_DOMAIN")           // d= of DKIM signatures, signing is off when unset
+	DKIMSelector         = os.Getenv("MAIL_SERVICE_DKIM_SELECTOR")         // s=, the public key is published at <selector>._domainkey.<domain>
+	DKIMPrivateKey       = os.Getenv("MAIL_SERVICE_DKIM_PRIVATE_KEY")      // PEM RSA or Ed25519 key, line breaks may be written as \n
+	DKIMPrivateKeyFile   = os.Getenv("MAIL_SERVICE_DKIM_PRIVATE_KEY_FILE") // Or a file with the PEM key
+	DKIMCanonicalization = os.Getenv("MAIL_SERVICE_DKIM_CANONICALIZATION") // "header/body", each simple or relaxed, default relaxed/relaxed
+	DKIMHeaders          = os.Getenv("MAIL_SERVICE_DKIM_HEADERS")          // Comma-separated headers to sign, must include From
+
+	QueueWorkers     = os.Getenv("MAIL_SERVICE_QUEUE_WORKERS")      // Concurrent senders, default 4
+	QueueMaxAttempts = os.Getenv("MAIL_SERVICE_QUEUE_MAX_ATTEMPTS") // Attempts before a message is dead-lettered, default 8
+
+	SMTPHost     = os.Getenv("MAIL_SERVICE_SMTP_HOST")     // Default smtp.gmail.com
+	SMTPPort     = os.Getenv("MAIL_SERVICE_SMTP_PORT")     // Default 587, or 465 with implicit TLS
+	SMTPSecurity = os.Getenv("MAIL_SERVICE_SMTP_SECURITY") // "starttls" (default), "tls" (implicit) or "none"
+	SMTPAuth     = os.Getenv("MAIL_SERVICE_SMTP_AUTH")     // "plain" (default), "login", "cram-md5" or "none"
+	SMTPUsername = os.Getenv("MAIL_SERVICE_SMTP_USERNAME") // Default MAIL_SERVICE_SMTP_EMAIL
+	SMTPEmail    = os.Getenv("MAIL_SERVICE_SMTP_EMAIL")    // Account used to send, e.g. the Gmail address
+	SMTPPassword = os.Getenv("MAIL_SERVICE_SMTP_PASSWORD") // Account password, e.g. a Gmail app password
+	SMTPTimeout  = os.Getenv("MAIL_SERVICE_SMTP_TIMEOUT")  // Whole SMTP transaction, default 30s
+
+	RateLimits     = os.Getenv("MAIL_SERVICE_RATE_LIMITS")      // Comma-separated "<name>=<requests>/<period>" or "<name>=off" overrides
+	RateLimitStore = os.Getenv("MAIL_SERVICE_RATE_LIMIT_STORE") // "memory" (default) or a redis:// URL shared by all instances
+	BehindProxy    = os.Getenv("MAIL_SERVICE_BEHIND_PROXY")     // "true" to take the client IP from X-Real-IP / X-Forwarded-For
 )
 
 // Set DBPort explicitly to 5432 inside the container
 const DBPort = "5432"
 
+// setOrUnset hides the value of a secret in the printed environment
+func setOrUnset(secret string) string {
+	if secret == "" {
+		return "unset"
+	}
+	return "set"
+}
+
 // PrintEnvVariables prints all environment variables for debugging
 func PrintEnvVariables() {
 	fmt.Println("🔧 Loaded Environment Variables - MAIL_SERVICE")
@@ -29,6 +79,26 @@ func PrintEnvVariables() {
 	fmt.Printf("DBPort: %s\n", DBPort)
 	fmt.Printf("ServicePort: %s\n", ServicePort)
 	fmt.Printf("ServiceName: %s\n", ServiceName)
+	fmt.Printf("MailTransport: %s\n", MailTransport)
+	fmt.Printf("MailDir: %s\n", MailDir)
+	fmt.Printf("MailFrom: %s <%s>\n", MailFromName, MailFrom)
+	fmt.Printf("DefaultLocale: %s\n", DefaultLocale)
+	fmt.Printf("JWTSecret: %s\n", setOrUnset(JWTSecret))
+	fmt.Printf("AuthCodeSecret: %s\n", setOrUnset(AuthCodeSecret))
+	fmt.Printf("VerificationTokenSecret: %s\n", setOrUnset(VerificationTokenSecret))
+	fmt.Printf("WebhookSecret: %s\n", setOrUnset(WebhookSecret))
+	fmt.Printf("SendClients: %s\n", setOrUnset(SendClients))
+	fmt.Printf("Attachments: maxSize=%s messageMaxSize=%s\n", AttachmentMaxSize, MessageAttachmentsMaxSize)
+	fmt.Printf("PublicURL: %s\n", PublicURL)
+	fmt.Printf("UnsubscribeSecret: %s\n", setOrUnset(UnsubscribeSecret))
+	fmt.Printf("SuppressionExempt: %s\n", SuppressionExempt)
+	fmt.Printf("DKIM: domain=%s selector=%s canonicalization=%s headers=%s keyFile=%s\n", DKIMDomain, DKIMSelector, DKIMCanonicalization, DKIMHeaders, DKIMPrivateKeyFile)
+	fmt.Printf("SMTP: host=%s port=%s security=%s auth=%s username=%s timeout=%s\n", SMTPHost, SMTPPort, SMTPSecurity, SMTPAuth, SMTPUsername, SMTPTimeout)
+	fmt.Printf("SMTPEmail: %s\n", SMTPEmail)
+	fmt.Printf("Queue: workers=%s maxAttempts=%s\n", QueueWorkers, QueueMaxAttempts)
+	fmt.Printf("RateLimits: %s\n", RateLimits)
+	fmt.Printf("RateLimitStore: %s\n", RateLimitStore)
+	fmt.Printf("BehindProxy: %s\n", BehindProxy)
 
 	// Ensure all required environment variables are set
 	missingEnvVars := false
@@ -41,6 +111,72 @@ func PrintEnvVariables() {
 		missingEnvVars = true
 	}
 
+	if mailerConfigErr != nil {
+		fmt.Printf("❌ Error: MAIL_SERVICE_MAIL_* / MAIL_SERVICE_SMTP_*: %v\n", mailerConfigErr)
+		missingEnvVars = true
+	}
+	if mailerConfig.Transport == transportSMTP && mailerConfig.SMTPAuth != smtpAuthNone && (mailerConfig.SMTPUsername == "" || SMTPPassword == "") {
+		fmt.Println("⚠️ Warning: MAIL_SERVICE_SMTP_EMAIL/USERNAME or MAIL_SERVICE_SMTP_PASSWORD not set, SMTP servers requiring auth will reject mail")
+	}
+
+	if dkimConfigErr != nil {
+		fmt.Printf("❌ Error: MAIL_SERVICE_DKIM_*: %v\n", dkimConfigErr)
+		missingEnvVars = true
+	} else if dkimConfig == nil {
+		fmt.Println("⚠️ Warning: MAIL_SERVICE_DKIM_DOMAIN not set, outgoing mail is not DKIM-signed")
+	} else if !dkimAligned(mailerConfig.From, dkimConfig.Domain) {
+		fmt.Printf("⚠️ Warning: MAIL_SERVICE_DKIM_DOMAIN %s does not align with the sender %s, DMARC will not pass\n", dkimConfig.Domain, mailerConfig.From.Address)
+	}
+
+	if mailQueueSettingsErr != nil {
+		fmt.Printf("❌ Error: MAIL_SERVICE_QUEUE_*: %v\n", mailQueueSettingsErr)
+		missingEnvVars = true
+	}
+
+	if DefaultLocale != "" && !isSupportedLocale(DefaultLocale) {
+		fmt.Printf("❌ Error: MAIL_SERVICE_DEFAULT_LOCALE %q has no templates\n", DefaultLocale)
+		missingEnvVars = true
+	}
+	if JWTSecret == "" {
+		fmt.Println("⚠️ Warning: MAIL_SERVICE_JWT_SECRET not set, admin endpoints are disabled")
+	}
+
+	if AuthCodeSecret == "" {
+		fmt.Println("⚠️ Warning: MAIL_SERVICE_AUTH_CODE_SECRET not set, auth codes only verify on this instance until it restarts")
+	}
+	if VerificationTokenSecret == "" {
+		fmt.Println("⚠️ Warning: MAIL_SERVICE_VERIFICATION_TOKEN_SECRET not set, verified codes carry no token and user-service refuses signups")
+	}
+
+	if WebhookSecret == "" {
+		fmt.Println("⚠️ Warning: MAIL_SERVICE_WEBHOOK_SECRET not set, delivery event and DSN webhooks are disabled")
+	}
+
+	if sendClientsErr != nil {
+		fmt.Printf("❌ Error: MAIL_SERVICE_SEND_CLIENTS: %v\n", sendClientsErr)
+		missingEnvVars = true
+	} else if len(sendClients) == 0 {
+		fmt.Println("⚠️ Warning: MAIL_SERVICE_SEND_CLIENTS not set, the /v1/send API is disabled")
+	}
+
+	if attachmentLimitsErr != nil {
+		fmt.Printf("❌ Error: MAIL_SERVICE_*ATTACHMENT*_MAX_SIZE: %v\n", attachmentLimitsErr)
+		missingEnvVars = true
+	}
+
+	if suppressionExemptErr != nil {
+		fmt.Printf("❌ Error: MAIL_SERVICE_SUPPRESSION_EXEMPT: %v\n", suppressionExemptErr)
+		missingEnvVars = true
+	}
+	if PublicURL == "" || UnsubscribeSecret == "" {
+		fmt.Println("⚠️ Warning: MAIL_SERVICE_PUBLIC_URL or MAIL_SERVICE_UNSUBSCRIBE_SECRET not set, mail is sent without List-Unsubscribe headers")
+	}
+
+	if rateLimitsErr != nil {
+		fmt.Printf("❌ Error: MAIL_SERVICE_RATE_LIMITS: %v\n", rateLimitsErr)
+		missingEnvVars = true
+	}
+
 	if missingEnvVars {
 		log.Fatal("❌ Exiting due to missing environment variables.")
 	}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mailer delivers messages. The transport is chosen with MAIL_SERVICE_MAIL_TRANSPORT.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Mail transports
+const (
+	transportSMTP    = "smtp"    // Deliver through an SMTP server (default)
+	transportFile    = "file"    // Write .eml files into MAIL_SERVICE_MAIL_DIR
+	transportMaildir = "maildir" // Deliver into a Maildir at MAIL_SERVICE_MAIL_DIR
+	transportLog     = "log"     // Only log messages
+)
+
+// Message is an email with a plain-text body, an optional HTML alternative and attachments
+type Message struct {
+	MessageID   string // Message-ID header, generated when empty
+	From        mail.Address
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Headers     map[string]string // Additional headers, such as List-Unsubscribe
+	Attachments []MailAttachment
+}
+
+// MailAttachment is a file attached to a Message. With a ContentID it is shown
+// inline where the HTML refers to cid:<ContentID>, without HTML it is attached.
+type MailAttachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Content     []byte
+}
+
+// Recipients returns the envelope recipients, validated as RFC 5322 addresses
+func (m *Message) Recipients() ([]string, error) {
+	if len(m.To) == 0 {
+		return nil, errors.New("message has no recipients")
+	}
+	recipients := make([]string, 0, len(m.To))
+	for _, to := range m.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+		recipients = append(recipients, addr.Address)
+	}
+	return recipients, nil
+}
+
+// Bytes builds the RFC 5322 message: headers with RFC 2047 encoded words,
+// quoted-printable UTF-8 bodies and base64 attachments, all with CRLF line
+// endings. With HTML the body is multipart/alternative, inline images wrap it
+// in multipart/related and other attachments in multipart/mixed. The message
+// is DKIM-signed when MAIL_SERVICE_DKIM_DOMAIN is set.
+func (m *Message) Bytes(now time.Time) ([]byte, error) {
+	recipients, err := m.Recipients()
+	if err != nil {
+		return nil, err
+	}
+	to := make([]string, len(recipients))
+	for i, addr := range recipients {
+		to[i] = (&mail.Address{Address: addr}).String()
+	}
+	messageID := m.MessageID
+	if messageID == "" {
+		if messageID, err = newMessageID(m.From.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "Date", now.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", messageID)
+	writeHeader(&buf, "From", m.From.String())
+	writeHeader(&buf, "To", strings.Join(to, ", "))
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", headerValue(m.Subject)))
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeHeader(&buf, name, headerValue(m.Headers[name]))
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	body := m.body()
+	for _, name := range mimePartHeaders {
+		if value := body.header.Get(name); value != "" {
+			writeHeader(&buf, name, value)
+		}
+	}
+	buf.WriteString("\r\n")
+	if err := body.write(&buf); err != nil {
+		return nil, err
+	}
+	if dkimConfig != nil {
+		return dkimConfig.Sign(buf.Bytes(), now)
+	}
+	return buf.Bytes(), nil
+}
+
+// mimePart is a MIME entity: its content headers and a function writing its content
+type mimePart struct {
+	header textproto.MIMEHeader
+	write  func(w io.Writer) error
+}
+
+// Content headers of a mimePart, in the order they are written at the top level
+var mimePartHeaders = []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-ID"}
+
+// body nests the parts of the message: the text, its HTML alternative, inline
+// images related to the HTML and attachments, each level only when needed
+func (m *Message) body() mimePart {
+	body := textPart("text/plain; charset=UTF-8", m.Text)
+	if m.HTML != "" {
+		body = multipartPart("alternative", body, textPart("text/html; charset=UTF-8", m.HTML))
+	}
+
+	var inline, attached []mimePart
+	for _, a := range m.Attachments {
+		if a.ContentID != "" && m.HTML != "" {
+			inline = append(inline, attachmentPart(a, "inline"))
+		} else {
+			attached = append(attached, attachmentPart(a, "attachment"))
+		}
+	}
+	if len(inline) > 0 {
+		body = multipartPart("related", append([]mimePart{body}, inline...)...)
+	}
+	if len(attached) > 0 {
+		body = multipartPart("mixed", append([]mimePart{body}, attached...)...)
+	}
+	return body
+}
+
+// textPart is a quoted-printable text body
+func textPart(contentType, text string) mimePart {
+	return mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		write: func(w io.Writer) error { return writeQuotedPrintable(w, text) },
+	}
+}
+
+// multipartPart is a multipart/<subtype> of parts. A multipart/related names
+// the type of its first part, the root the others belong to (RFC 2387).
+func multipartPart(subtype string, parts ...mimePart) mimePart {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	params := map[string]string{"boundary": boundary}
+	if subtype == "related" {
+		params["type"], _, _ = mime.ParseMediaType(parts[0].header.Get("Content-Type"))
+	}
+	return mimePart{
+		header: textproto.MIMEHeader{"Content-Type": {mime.FormatMediaType("multipart/"+subtype, params)}},
+		write: func(w io.Writer) error {
+			mw := multipart.NewWriter(w)
+			if err := mw.SetBoundary(boundary); err != nil {
+				return err
+			}
+			for _, part := range parts {
+				pw, err := mw.CreatePart(part.header)
+				if err != nil {
+					return err
+				}
+				if err := part.write(pw); err != nil {
+					return err
+				}
+			}
+			return mw.Close()
+		},
+	}
+}
+
+// attachmentPart is a base64 attachment, disposition "inline" or "attachment".
+// Non-ASCII filenames are encoded as RFC 2231 parameters.
+func attachmentPart(a MailAttachment, disposition string) mimePart {
+	mediaType, params, err := mime.ParseMediaType(a.ContentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", map[string]string{}
+	}
+	filename := headerValue(a.Filename)
+	params["name"] = filename
+	header := textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(mediaType, params)},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType(disposition, map[string]string{"filename": filename})},
+	}
+	if disposition == "inline" {
+		header.Set("Content-ID", "<"+a.ContentID+">")
+	}
+	return mimePart{
+		header: header,
+		write:  func(w io.Writer) error { return writeBase64(w, a.Content) },
+	}
+}
+
+// writeBase64 writes content base64 encoded in lines of 76 characters (RFC 2045)
+func writeBase64(w io.Writer, content []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := io.WriteString(w, encoded+"\r\n")
+	return err
+}
+
+// writeQuotedPrintable writes s quoted-printable encoded, with CRLF line endings
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name + ": " + value + "\r\n")
+}
+
+// headerValue removes line breaks so a value cannot start a new header
+func headerValue(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// newMessageID returns a unique Message-ID in the sender's domain
+func newMessageID(from string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain), nil
+}
+
+// mailerSettings configure the Mailer, see env.go
+type mailerSettings struct {
+	Transport    string
+	From         mail.Address
+	Dir          string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPSecurity string
+	SMTPAuth     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPTimeout  time.Duration
+}
+
+// parseMailerSettings reads the MAIL_SERVICE_MAIL_* and MAIL_SERVICE_SMTP_* variables
+func parseMailerSettings() (mailerSettings, error) {
+	s := mailerSettings{
+		Transport:    valueOr(MailTransport, transportSMTP),
+		Dir:          valueOr(MailDir, "mail"),
+		SMTPHost:     valueOr(SMTPHost, "smtp.gmail.com"),
+		SMTPSecurity: valueOr(SMTPSecurity, smtpStartTLS),
+		SMTPAuth:     valueOr(SMTPAuth, smtpAuthPlain),
+		SMTPUsername: valueOr(SMTPUsername, SMTPEmail),
+		SMTPPassword: SMTPPassword,
+		SMTPTimeout:  30 * time.Second,
+	}
+
+	switch s.Transport {
+	case transportSMTP, transportFile, transportMaildir, transportLog:
+	default:
+		return s, fmt.Errorf("unknown transport %q, expected %s, %s, %s or %s", s.Transport, transportSMTP, transportFile, transportMaildir, transportLog)
+	}
+
+	from := valueOr(MailFrom, SMTPEmail)
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return s, fmt.Errorf("invalid sender address %q: %w", from, err)
+	}
+	s.From = mail.Address{Name: MailFromName, Address: addr.Address}
+
+	switch s.SMTPSecurity {
+	case smtpStartTLS, smtpImplicitTLS, smtpNoTLS:
+	default:
+		return s, fmt.Errorf("unknown SMTP security %q, expected %s, %s or %s", s.SMTPSecurity, smtpStartTLS, smtpImplicitTLS, smtpNoTLS)
+	}
+	switch s.SMTPAuth {
+	case smtpAuthPlain, smtpAuthLogin, smtpAuthCRAMMD5, smtpAuthNone:
+	default:
+		return s, fmt.Errorf("unknown SMTP auth mechanism %q, expected %s, %s, %s or %s", s.SMTPAuth, smtpAuthPlain, smtpAuthLogin, smtpAuthCRAMMD5, smtpAuthNone)
+	}
+
+	defaultPort := 587
+	if s.SMTPSecurity == smtpImplicitTLS {
+		defaultPort = 465
+	}
+	s.SMTPPort = defaultPort
+	if SMTPPort != "" {
+		if s.SMTPPort, err = strconv.Atoi(SMTPPort); err != nil || s.SMTPPort < 1 || s.SMTPPort > 65535 {
+			return s, fmt.Errorf("invalid SMTP port %q", SMTPPort)
+		}
+	}
+	if SMTPTimeout != "" {
+		if s.SMTPTimeout, err = time.ParseDuration(SMTPTimeout); err != nil || s.SMTPTimeout <= 0 {
+			return s, fmt.Errorf("invalid SMTP timeout %q, expected a duration like 30s", SMTPTimeout)
+		}
+	}
+	return s, nil
+}
+
+// newMailer creates the Mailer for the configured transport
+func newMailer(s mailerSettings) Mailer {
+	switch s.Transport {
+	case transportFile:
+		return &fileMailer{dir: s.Dir}
+	case transportMaildir:
+		return &maildirMailer{dir: s.Dir}
+	case transportLog:
+		return logMailer{}
+	default:
+		return &smtpMailer{
+			host:     s.SMTPHost,
+			port:     s.SMTPPort,
+			security: s.SMTPSecurity,
+			auth:     s.SMTPAuth,
+			username: s.SMTPUsername,
+			password: s.SMTPPassword,
+			timeout:  s.SMTPTimeout,
+		}
+	}
+}
+
+// Settings of the configured Mailer
+var mailerConfig, mailerConfigErr = parseMailerSettings()
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
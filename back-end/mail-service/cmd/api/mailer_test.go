@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+
+// mustBytes builds msg and parses it back
+func mustBytes(t *testing.T, msg *Message) ([]byte, *mail.Message) {
+	t.Helper()
+	raw, err := msg.Bytes(testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bare := strings.Count(string(raw), "\n") - strings.Count(string(raw), "\r\n"); bare != 0 {
+		t.Fatalf("expected CRLF line endings only, got %d bare LFs", bare)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unparsable message: %v\n%s", err, raw)
+	}
+	return raw, parsed
+}
+
+// mimeStructure describes the MIME tree of an entity, such as
+// "multipart/alternative(text/plain,text/html)", and collects its decoded leaves
+func mimeStructure(t *testing.T, contentType string, transferEncoding string, body io.Reader, leaves map[string]string) string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("invalid Content-Type %q: %v", contentType, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		var content []byte
+		switch transferEncoding {
+		case "quoted-printable":
+			content, err = io.ReadAll(quotedprintable.NewReader(body))
+		default:
+			content, err = io.ReadAll(body)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves[mediaType] = string(content)
+		return mediaType
+	}
+
+	var children []string
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		children = append(children, mimeStructure(t, part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, leaves))
+	}
+	return mediaType + "(" + strings.Join(children, ",") + ")"
+}
+
+// Header values cannot inject headers, subjects are RFC 2047 encoded words
+func TestMessageBytesHeaders(t *testing.T) {
+	msg := &Message{
+		From:    mail.Address{Name: "Zeheb", Address: "noreply@zeheb.example"},
+		To:      []string{"Ayşe <ayse@example.com>"},
+		Subject: "Grüße\r\nBcc: victim@example.com",
+		Text:    "Line one\nLine two", // Bodies get CRLF line endings too
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.com/u>\r\nBcc: victim@example.com"},
+	}
+	raw, parsed := mustBytes(t, msg)
+
+	if bcc := parsed.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("expected no injected Bcc header, got %q", bcc)
+	}
+	if unsubscribe := parsed.Header.Get("List-Unsubscribe"); unsubscribe != "<https://example.com/u> Bcc: victim@example.com" {
+		t.Errorf("expected the line break to be folded into a space, got %q", unsubscribe)
+	}
+	if !strings.Contains(string(raw), "\r\nSubject: =?utf-8?q?") {
+		t.Errorf("expected an encoded-word subject in\n%s", raw)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "Grüße Bcc: victim@example.com" {
+		t.Errorf("unexpected subject %q, %v", subject, err)
+	}
+	// The envelope address only, display names of recipients are not trusted
+	if to := parsed.Header.Get("To"); to != "<ayse@example.com>" {
+		t.Errorf("unexpected To %q", to)
+	}
+	if date, err := parsed.Header.Date(); err != nil || !date.Equal(testNow) {
+		t.Errorf("unexpected Date %v, %v", date, err)
+	}
+	if id := parsed.Header.Get("Message-ID"); !strings.HasSuffix(id, "@zeheb.example>") {
+		t.Errorf("expected a Message-ID in the sender's domain, got %q", id)
+	}
+	if parsed.Header.Get("MIME-Version") != "1.0" {
+		t.Errorf("expected MIME-Version 1.0")
+	}
+}
+
+// Text-only messages are a single quoted-printable part, with HTML they are multipart/alternative
+func TestMessageBytesBodies(t *testing.T) {
+	tests := []struct {
+		name      string
+		html      string
+		structure string
+	}{
+		{"text only", "", "text/plain"},
+		{"with HTML", "<p>Merhaba Ayşe</p>", "multipart/alternative(text/plain,text/html)"},
+	}
+	for _, tt := range tests {
+		msg := &Message{From: mail.Address{Address: "noreply@zeheb.example"}, To: []string{"ayse@example.com"}, Subject: "Hi", Text: "Merhaba Ayşe", HTML: tt.html}
+		_, parsed := mustBytes(t, msg)
+
+		leaves := map[string]string{}
+		structure := mimeStructure(t, parsed.Header.Get("Content-Type"), parsed.Header.Get("Content-Transfer-Encoding"), parsed.Body, leaves)
+		if structure != tt.structure {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.structure, structure)
+		}
+		if leaves["text/plain"] != "Merhaba Ayşe" {
+			t.Errorf("%s: unexpected text %q", tt.name, leaves["text/plain"])
+		}
+		if leaves["text/html"] != tt.html {
+			t.Errorf("%s: unexpected HTML %q", tt.name, leaves["text/html"])
+		}
+	}
+}
+
+func TestMessageBytesInvalidRecipient(t *testing.T) {
+	for _, to := range [][]string{nil, {"not an address"}} {
+		msg := &Message{From: mail.Address{Address: "noreply@zeheb.example"}, To: to, Text: "Hello"}
+		if _, err := msg.Bytes(testNow); err == nil {
+			t.Errorf("%v: expected an error", to)
+		}
+	}
+}
+
+// Inline images wrap the alternative in multipart/related, other attachments
+// wrap everything in multipart/mixed
+func TestMessageBytesAttachments(t *testing.T) {
+	logo := MailAttachment{Filename: "logo.png", ContentType: "image/png", ContentID: "logo", Content: []byte("\x89PNG logo")}
+	report := MailAttachment{Filename: "rapor-ağustos.pdf", ContentType: "application/pdf", Content: bytes.Repeat([]byte("%PDF"), 40)}
+
+	tests := []struct {
+		name        string
+		html        string
+		attachments []MailAttachment
+		structure   string
+	}{
+		{"inline image", "<img src=\"cid:logo\">", []MailAttachment{logo},
+			"multipart/related(multipart/alternative(text/plain,text/html),image/png)"},
+		{"attachment", "<p>Hi</p>", []MailAttachment{report},
+			"multipart/mixed(multipart/alternative(text/plain,text/html),application/pdf)"},
+		{"both", "<img src=\"cid:logo\">", []MailAttachment{logo, report},
+			"multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/png),application/pdf)"},
+		{"inline image without HTML", "", []MailAttachment{logo},
+			"multipart/mixed(text/plain,image/png)"},
+	}
+	for _, tt := range tests {
+		msg := &Message{From: mail.Address{Address: "noreply@zeheb.example"}, To: []string{"ayse@example.com"}, Text: "Hi", HTML: tt.html, Attachments: tt.attachments}
+		raw, parsed := mustBytes(t, msg)
+
+		leaves := map[string]string{}
+		structure := mimeStructure(t, parsed.Header.Get("Content-Type"), parsed.Header.Get("Content-Transfer-Encoding"), parsed.Body, leaves)
+		if structure != tt.structure {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.structure, structure)
+		}
+		// The root of multipart/related is named by its type parameter (RFC 2387)
+		if strings.Contains(tt.structure, "related") && !strings.Contains(string(raw), `multipart/related; boundary=`) {
+			t.Errorf("%s: expected a multipart/related header in\n%s", tt.name, raw)
+		}
+		if strings.Contains(tt.structure, "related") && !strings.Contains(string(raw), `type="multipart/alternative"`) {
+			t.Errorf("%s: expected the related root type in\n%s", tt.name, raw)
+		}
+	}
+}
+
+// Attachment parts carry their disposition, Content-ID and RFC 2231 filename
+func TestAttachmentPart(t *testing.T) {
+	tests := []struct {
+		name        string
+		attachment  MailAttachment
+		disposition string
+		contentType string
+		contentID   string
+	}{
+		{"inline", MailAttachment{Filename: "logo.png", ContentType: "image/png", ContentID: "logo", Content: []byte("png")}, "inline", "image/png", "<logo>"},
+		{"non-ASCII filename", MailAttachment{Filename: "rapor-ağustos.pdf", ContentType: "application/pdf", Content: []byte("pdf")}, "attachment", "application/pdf", ""},
+		{"filename with line break", MailAttachment{Filename: "a\r\nBcc: x.txt", ContentType: "text/plain", Content: []byte("txt")}, "attachment", "text/plain", ""},
+		{"invalid content type", MailAttachment{Filename: "data.bin", ContentType: "not a type", Content: []byte("bin")}, "attachment", "application/octet-stream", ""},
+	}
+	for _, tt := range tests {
+		part := attachmentPart(tt.attachment, tt.disposition)
+
+		disposition, params, err := mime.ParseMediaType(part.header.Get("Content-Disposition"))
+		if err != nil || disposition != tt.disposition {
+			t.Errorf("%s: unexpected disposition %q, %v", tt.name, disposition, err)
+		}
+		if want := headerValue(tt.attachment.Filename); params["filename"] != want {
+			t.Errorf("%s: expected filename %q, got %q", tt.name, want, params["filename"])
+		}
+		contentType, params, err := mime.ParseMediaType(part.header.Get("Content-Type"))
+		if err != nil || contentType != tt.contentType || params["name"] != headerValue(tt.attachment.Filename) {
+			t.Errorf("%s: unexpected Content-Type %q", tt.name, part.header.Get("Content-Type"))
+		}
+		if got := part.header.Get("Content-ID"); got != tt.contentID {
+			t.Errorf("%s: expected Content-ID %q, got %q", tt.name, tt.contentID, got)
+		}
+		for name, values := range part.header {
+			if strings.ContainsAny(strings.Join(values, ""), "\r\n") {
+				t.Errorf("%s: line break in %s header", tt.name, name)
+			}
+		}
+
+		var content bytes.Buffer
+		if err := part.write(&content); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, strings.NewReader(strings.ReplaceAll(content.String(), "\r\n", ""))))
+		if err != nil || !bytes.Equal(decoded, tt.attachment.Content) {
+			t.Errorf("%s: content did not round-trip, got %q, %v", tt.name, decoded, err)
+		}
+	}
+
+	// Non-ASCII filenames use RFC 2231 extended parameters, not raw UTF-8
+	part := attachmentPart(tests[1].attachment, "attachment")
+	if header := part.header.Get("Content-Disposition"); !strings.Contains(header, "filename*=utf-8''rapor-a%C4%9Fustos.pdf") {
+		t.Errorf("expected an RFC 2231 filename, got %q", header)
+	}
+}
+
+// Base64 bodies are wrapped at 76 characters
+func TestWriteBase64(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeBase64(&buf, bytes.Repeat([]byte("x"), 100)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(lines) != 2 || len(lines[0]) != 76 {
+		t.Fatalf("expected a 76 character line and the rest, got %q", lines)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"shared/identifier"
+)
+
+// Sources of message events
+const (
+	eventSourceQueue   = "queue"   // The mail queue, on enqueue and after each send attempt
+	eventSourceWebhook = "webhook" // A delivery event from the mail provider
+	eventSourceDSN     = "dsn"     // A delivery status notification (bounce message)
+)
+
+const (
+	webhookMaxBodyBytes    = 1 << 20
+	webhookSignatureMaxAge = 5 * time.Minute
+	messageLogDefaultLimit = 100
+	messageLogMaxLimit     = 500
+	dsnRecipientWindow     = 30 * 24 * time.Hour // How far back a DSN without Message-ID is matched by recipient
+)
+
+// MessageEvent is one entry in the history of an outgoing message. The message
+// keeps its latest status, the events keep how it got there.
+type MessageEvent struct {
+	ID         uint      `gorm:"primaryKey"`
+	MessageID  uint      `gorm:"not null;index"`
+	Type       string    `gorm:"not null"` // The status the event reports, e.g. deferred or bounced
+	Source     string    `gorm:"not null"`
+	Detail     string    // Error or reason, if any
+	OccurredAt time.Time `gorm:"not null"`
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+// recordEvent appends an event to the history of message id within tx
+func recordEvent(tx *gorm.DB, id uint, eventType, source, detail string, occurredAt time.Time) error {
+	return tx.Create(&MessageEvent{
+		MessageID:  id,
+		Type:       eventType,
+		Source:     source,
+		Detail:     detail,
+		OccurredAt: occurredAt,
+	}).Error
+}
+
+// deliveryTransitions lists the states a delivery event may move a message
+// out of. Events that do not apply, such as a provider deferral or a late
+// delivery report for a bounced message, are only recorded.
+var deliveryTransitions = map[string][]string{
+	messageDelivered:  {messageSent},
+	messageBounced:    {messageSent, messageDelivered},
+	messageComplained: {messageSent, messageDelivered},
+}
+
+// deliveryEvent is a delivery outcome reported after a message was sent
+type deliveryEvent struct {
+	Type      string    `json:"type"`      // delivered, deferred, bounced or complained
+	MessageID string    `json:"messageId"` // Message-ID header of the sent message
+	Recipient string    `json:"recipient"`
+	Timestamp time.Time `json:"timestamp"`
+	Reason    string    `json:"reason"`
+}
+
+// normalizeMessageID returns a Message-ID in angle brackets, as it is stored
+func normalizeMessageID(id string) string {
+	id = strings.TrimSpace(id)
+	if id == "" || strings.HasPrefix(id, "<") {
+		return id
+	}
+	return "<" + id + ">"
+}
+
+// applyDeliveryEvent records ev for the message it refers to and moves the
+// message on if deliveryTransitions allow it. Without a Message-ID, and only
+// when byRecipient is set, the latest message sent to the recipient is used.
+// It reports whether a message was found.
+func applyDeliveryEvent(tx *gorm.DB, ev deliveryEvent, source string, byRecipient bool) (bool, error) {
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	switch messageID := normalizeMessageID(ev.MessageID); {
+	case messageID != "":
+		query = query.Where("message_id_header = ?", messageID)
+	case byRecipient && ev.Recipient != "":
+		query = query.Where("recipient_normalized = ? AND sent_at > ?", identifier.Normalize(ev.Recipient), time.Now().Add(-dsnRecipientWindow)).Order("sent_at DESC")
+	default:
+		return false, nil
+	}
+
+	var msg OutgoingMessage
+	if err := query.First(&msg).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	occurredAt := ev.Timestamp
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+	if err := recordEvent(tx, msg.ID, ev.Type, source, ev.Reason, occurredAt); err != nil {
+		return false, err
+	}
+	// Hard bounces and complaints stop further mail to the recipient
+	if ev.Type == messageBounced || ev.Type == messageComplained {
+		if err := suppress(tx, msg.Recipient, "", ev.Type, ev.Reason, &msg.ID); err != nil {
+			return false, err
+		}
+	}
+
+	for _, from := range deliveryTransitions[ev.Type] {
+		if msg.Status != from {
+			continue
+		}
+		updates := map[string]interface{}{"status": ev.Type}
+		if ev.Reason != "" {
+			updates["last_error"] = ev.Reason
+		}
+		log.Printf("📧 Mail %d (%s) %s: %s", msg.ID, msg.Template, ev.Type, ev.Reason)
+		return true, tx.Model(&msg).Updates(updates).Error
+	}
+	return true, nil
+}
+
+// applyDeliveryEvents applies events in one transaction and counts the events
+// that referred to a known message
+func (app *Config) applyDeliveryEvents(events []deliveryEvent, source string, byRecipient bool) (int, error) {
+	accepted := 0
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		for _, ev := range events {
+			found, err := applyDeliveryEvent(tx, ev, source, byRecipient)
+			if err != nil {
+				return err
+			}
+			if found {
+				accepted++
+			}
+		}
+		return nil
+	})
+	return accepted, err
+}
+
+// verifyWebhookSignature checks a "t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<body>">"
+// signature, the format user-service signs its webhooks with. Timestamps older
+// than webhookSignatureMaxAge are rejected to prevent replays.
+func verifyWebhookSignature(secret, header string, body []byte, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errors.New("malformed signature")
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > webhookSignatureMaxAge || age < -webhookSignatureMaxAge {
+		return errors.New("signature timestamp out of range")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+// readSignedWebhook reads the body of an inbound webhook and checks its
+// X-Webhook-Signature against MAIL_SERVICE_WEBHOOK_SECRET. On failure it has
+// already answered the request.
+func readSignedWebhook(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if WebhookSecret == "" {
+		writeError(w, http.StatusServiceUnavailable, "Inbound webhooks are not configured")
+		return nil, false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "Webhook body too large")
+		return nil, false
+	}
+	if err := verifyWebhookSignature(WebhookSecret, r.Header.Get("X-Webhook-Signature"), body, time.Now()); err != nil {
+		log.Printf("⚠️ Rejected inbound webhook %s: %v", r.URL.Path, err)
+		writeError(w, http.StatusUnauthorized, "Invalid signature")
+		return nil, false
+	}
+	return body, true
+}
+
+// DeliveryEventsWebhookHandler takes delivery events from the mail provider:
+// {"events": [{"type": "bounced", "messageId": "<...>", ...}]}. Events for
+// unknown messages are ignored, so the provider does not retry them.
+func (app *Config) DeliveryEventsWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readSignedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Events []deliveryEvent `json:"events"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return
+	}
+	for _, ev := range req.Events {
+		switch ev.Type {
+		case messageDelivered, messageDeferred, messageBounced, messageComplained:
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Unknown event type %q", ev.Type))
+			return
+		}
+	}
+
+	accepted, err := app.applyDeliveryEvents(req.Events, eventSourceWebhook, false)
+	if err != nil {
+		log.Printf("❌ Failed to apply delivery events: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"accepted": accepted, "ignored": len(req.Events) - accepted})
+}
+
+// DSNWebhookHandler takes a delivery status notification (RFC 3464 bounce
+// message) as message/rfc822, e.g. piped in by the MTA receiving bounces
+func (app *Config) DSNWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readSignedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	events, err := parseDSN(body)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	accepted, err := app.applyDeliveryEvents(events, eventSourceDSN, true)
+	if err != nil {
+		log.Printf("❌ Failed to apply DSN: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"accepted": accepted, "ignored": len(events) - accepted})
+}
+
+// MessageEventResponse is one event of a message in the message log
+type MessageEventResponse struct {
+	Type       string    `json:"type"`
+	Source     string    `json:"source"`
+	Detail     string    `json:"detail,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// MessageLogEntry is a message in the message log
+type MessageLogEntry struct {
+	ID              uint                   `json:"id"`
+	MessageIDHeader string                 `json:"messageIdHeader,omitempty"`
+	Template        string                 `json:"template"`
+	Locale          string                 `json:"locale"`
+	Recipient       string                 `json:"recipient"`
+	Subject         string                 `json:"subject"`
+	Status          string                 `json:"status"`
+	Attempts        int                    `json:"attempts"`
+	LastError       string                 `json:"lastError,omitempty"`
+	SentAt          *time.Time             `json:"sentAt,omitempty"`
+	CreatedAt       time.Time              `json:"createdAt"`
+	UpdatedAt       time.Time              `json:"updatedAt"`
+	Events          []MessageEventResponse `json:"events,omitempty"`
+}
+
+func newMessageLogEntry(msg OutgoingMessage) MessageLogEntry {
+	return MessageLogEntry{
+		ID:              msg.ID,
+		MessageIDHeader: msg.MessageIDHeader,
+		Template:        msg.Template,
+		Locale:          msg.Locale,
+		Recipient:       msg.Recipient,
+		Subject:         msg.Subject,
+		Status:          msg.Status,
+		Attempts:        msg.Attempts,
+		LastError:       msg.LastError,
+		SentAt:          msg.SentAt,
+		CreatedAt:       msg.CreatedAt,
+		UpdatedAt:       msg.UpdatedAt,
+	}
+}
+
+// timeQuery reads an optional RFC 3339 time or date query parameter. A date
+// means the start of the day, or its end when endOfDay is set.
+func timeQuery(r *http.Request, name string, endOfDay bool) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("query parameter %q must be a date or an RFC 3339 time", name)
+	}
+	if endOfDay {
+		t = t.Add(24 * time.Hour)
+	}
+	return t, nil
+}
+
+// ListMessagesHandler queries the message log, newest first, by ?recipient,
+// ?status and creation time (?from, ?to). ?before=<id> continues after the
+// last message of the previous page, ?limit sets the page size.
+func (app *Config) ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	query := app.DB.Model(&OutgoingMessage{})
+	if recipient := r.URL.Query().Get("recipient"); recipient != "" {
+		query = query.Where("recipient_normalized = ?", identifier.Normalize(recipient))
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	from, err := timeQuery(r, "from", false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := timeQuery(r, "to", true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !from.IsZero() {
+		query = query.Where("created_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("created_at < ?", to)
+	}
+
+	if before := r.URL.Query().Get("before"); before != "" {
+		id, err := strconv.ParseUint(before, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, `query parameter "before" must be a message id`)
+			return
+		}
+		query = query.Where("id < ?", id)
+	}
+	limit := messageLogDefaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > messageLogMaxLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf(`query parameter "limit" must be between 1 and %d`, messageLogMaxLimit))
+			return
+		}
+	}
+
+	var messages []OutgoingMessage
+	if err := query.Order("id DESC").Limit(limit).Find(&messages).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+
+	entries := make([]MessageLogEntry, 0, len(messages))
+	for _, msg := range messages {
+		entries = append(entries, newMessageLogEntry(msg))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"messages": entries})
+}
+
+// GetMessageLogHandler returns a message of the message log with its events
+func (app *Config) GetMessageLogHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+
+	var msg OutgoingMessage
+	if err := app.DB.First(&msg, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "Message not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+	var events []MessageEvent
+	if err := app.DB.Where("message_id = ?", msg.ID).Order("occurred_at, id").Find(&events).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+
+	entry := newMessageLogEntry(msg)
+	entry.Events = make([]MessageEventResponse, 0, len(events))
+	for _, ev := range events {
+		entry.Events = append(entry.Events, MessageEventResponse{Type: ev.Type, Source: ev.Source, Detail: ev.Detail, OccurredAt: ev.OccurredAt})
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
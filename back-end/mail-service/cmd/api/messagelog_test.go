@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signWebhook signs body the way user-service does
+func signWebhook(secret string, body []byte, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1760955298, 0)
+	body := []byte(`{"events":[]}`)
+	valid := signWebhook("secret", body, now)
+
+	if err := verifyWebhookSignature("secret", valid, body, now); err != nil {
+		t.Fatalf("expected a valid signature, got %v", err)
+	}
+	// During a secret rotation the sender may include several signatures
+	rotating := valid + ",v1=" + hex.EncodeToString(make([]byte, sha256.Size))
+	if err := verifyWebhookSignature("secret", rotating, body, now); err != nil {
+		t.Fatalf("expected one matching signature to be enough, got %v", err)
+	}
+
+	rejected := map[string]struct {
+		secret, header string
+		body           []byte
+		now            time.Time
+	}{
+		"other secret":  {"other", valid, body, now},
+		"other body":    {"secret", valid, []byte(`{"events":[{}]}`), now},
+		"replayed":      {"secret", valid, body, now.Add(webhookSignatureMaxAge + time.Second)},
+		"future":        {"secret", valid, body, now.Add(-webhookSignatureMaxAge - time.Second)},
+		"no signature":  {"secret", "t=" + strconv.FormatInt(now.Unix(), 10), body, now},
+		"no timestamp":  {"secret", valid[len("t=1760955298,"):], body, now},
+		"empty":         {"secret", "", body, now},
+		"bad timestamp": {"secret", "t=soon,v1=00", body, now},
+	}
+	for name, c := range rejected {
+		if err := verifyWebhookSignature(c.secret, c.header, c.body, c.now); err == nil {
+			t.Errorf("%s: expected the signature to be rejected", name)
+		}
+	}
+}
+
+// The message log is filtered by the normalized recipient, Turkish I included
+func TestListMessagesByRecipient(t *testing.T) {
+	app := newTestApp(t)
+	want := queueTestMessage(t, app, "invitation", "IŞIL@Example.com")
+	queueTestMessage(t, app, "invitation", "isil.kaya@example.com")
+
+	for _, recipient := range []string{"ışıl@example.com", " Işıl@EXAMPLE.com", "İŞİL@example.com"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/v1/messages?recipient="+url.QueryEscape(recipient), nil)
+		app.ListMessagesHandler(rec, req)
+		var body struct {
+			Messages []MessageLogEntry `json:"messages"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Messages) != 1 || body.Messages[0].ID != want.ID {
+			t.Errorf("%q: expected message %d, got %+v", recipient, want.ID, body.Messages)
+		}
+	}
+}
+
+// A delivery event without Message-ID finds the sent message by its normalized recipient
+func TestApplyDeliveryEventByRecipient(t *testing.T) {
+	app := newTestApp(t)
+	msg := queueTestMessage(t, app, "invitation", "Ilker@Example.com")
+	if err := app.DB.Model(&msg).Updates(map[string]interface{}{"status": messageSent, "sent_at": time.Now()}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	ev := deliveryEvent{Type: messageBounced, Recipient: "ılker@example.com", Timestamp: time.Now()}
+	if found, err := applyDeliveryEvent(app.DB, ev, eventSourceDSN, false); err != nil || found {
+		t.Errorf("without byRecipient: expected no match, got %v, %v", found, err)
+	}
+	if found, err := applyDeliveryEvent(app.DB, ev, eventSourceDSN, true); err != nil || !found {
+		t.Fatalf("expected a match, got %v, %v", found, err)
+	}
+	if err := app.DB.First(&msg, msg.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != messageBounced {
+		t.Errorf("expected status %s, got %s", messageBounced, msg.Status)
+	}
+}
+
+// Messages queued before recipient_normalized existed are backfilled
+func TestMigrateNormalizedRecipients(t *testing.T) {
+	app := newTestApp(t)
+	old := queueTestMessage(t, app, "invitation", "İnci@Example.com")
+	if err := app.DB.Model(&old).UpdateColumn("recipient_normalized", "").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateNormalizedRecipients(app.DB); err != nil {
+		t.Fatal(err)
+	}
+	var msg OutgoingMessage
+	if err := app.DB.First(&msg, old.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if msg.RecipientNormalized != "inci@example.com" {
+		t.Errorf("expected inci@example.com, got %q", msg.RecipientNormalized)
+	}
+}
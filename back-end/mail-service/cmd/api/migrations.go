@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+
+	"shared/identifier"
+)
+
+// legacyTables were written by earlier versions. users duplicated the accounts
+// of user-service (with password hashes) just to hold signup codes, and
+// auth_codes held the code hashes until verification challenges replaced them.
+// Each is recognised by a column only the mail-service schema had, so a table
+// of the same name written by another service is never dropped.
+var legacyTables = []struct {
+	Table  string
+	Column string
+}{
+	{Table: "users", Column: "auth_code"},
+	{Table: "auth_codes", Column: "code_hash"},
+}
+
+// dropLegacyTables drops the legacyTables that still exist. Codes pending in
+// them are lost, their recipients request a new one.
+func dropLegacyTables(db *gorm.DB) error {
+	for _, legacy := range legacyTables {
+		if !db.Migrator().HasTable(legacy.Table) {
+			continue
+		}
+		if !db.Migrator().HasColumn(legacy.Table, legacy.Column) {
+			log.Printf("⚠️ Keeping table %s, it has no %s column and was not written by mail-service", legacy.Table, legacy.Column)
+			continue
+		}
+		var rows int64
+		if err := db.Table(legacy.Table).Count(&rows).Error; err != nil {
+			return err
+		}
+		log.Printf("⚠️ Dropping legacy table %s (%d rows), verification challenges replace it", legacy.Table, rows)
+		if err := db.Migrator().DropTable(legacy.Table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateNormalizedRecipients backfills recipient_normalized of the messages
+// queued before the column existed, one update per distinct recipient, so the
+// message log and DSN lookups find them by identifier.Normalize as well
+func migrateNormalizedRecipients(db *gorm.DB) error {
+	var recipients []string
+	err := db.Model(&OutgoingMessage{}).
+		Where("recipient_normalized IS NULL OR recipient_normalized = ''").
+		Distinct().Pluck("recipient", &recipients).Error
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	log.Printf("Backfilling the normalized recipient of messages to %d recipient(s)", len(recipients))
+	for _, recipient := range recipients {
+		err := db.Model(&OutgoingMessage{}).
+			Where("recipient = ? AND (recipient_normalized IS NULL OR recipient_normalized = '')", recipient).
+			UpdateColumn("recipient_normalized", identifier.Normalize(recipient)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Every chi route must be described by the OpenAPI document
+func TestOpenAPICoversAllRoutes(t *testing.T) {
+	doc, err := loadOpenAPISpec()
+	if err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+
+	app := &Config{}
+	walkFn := func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		path := strings.TrimSuffix(route, "/")
+		if path == "" {
+			path = "/"
+		}
+		item := doc.Paths.Find(path)
+		if item == nil || item.GetOperation(method) == nil {
+			t.Errorf("route %s %s has no OpenAPI entry", method, path)
+		}
+		return nil
+	}
+	if err := chi.Walk(app.routes().(chi.Routes), walkFn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Requests that do not match the document are rejected before reaching a handler
+func TestOpenAPIRejectsInvalidRequest(t *testing.T) {
+	app := &Config{ValidateResponses: true}
+	handler := app.routes()
+
+	body := strings.NewReader(`{"username": "testuser", "mailAddress": 42}`)
+	req := httptest.NewRequest(http.MethodPost, "/send-auth-code-mail", body)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// The document itself is served and passes response validation
+func TestOpenAPIServesDocument(t *testing.T) {
+	app := &Config{ValidateResponses: true}
+	handler := app.routes()
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected Content-Type %q", ct)
+	}
+}
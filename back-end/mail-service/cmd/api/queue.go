@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"shared/identifier"
+)
+
+// Outgoing message states. The queue moves a message from queued (or deferred)
+// to sent, deferred, bounced or dead; delivery events reported later by the
+// provider or a DSN move a sent message on, see deliveryTransitions.
+const (
+	messageQueued     = "queued"     // Waiting for its first attempt
+	messageDeferred   = "deferred"   // An attempt failed, waiting for the next one
+	messageSent       = "sent"       // Accepted by the transport
+	messageDelivered  = "delivered"  // Reached the mailbox, as reported by the provider
+	messageBounced    = "bounced"    // Rejected for the recipient, by the SMTP server or later in a bounce
+	messageComplained = "complained" // Reported as spam by the recipient
+	messageSuppressed = "suppressed" // Not sent, the recipient is on the suppression list
+	messageDead       = "dead"       // Given up after mailQueueMaxAttempts
+)
+
+const (
+	mailQueuePollInterval = 5 * time.Second
+	mailQueueBatchSize    = 50
+	mailQueueSendTimeout  = time.Minute
+	mailQueueLease        = 5 * time.Minute // A claimed message is retried after this if the worker dies mid-send
+	mailQueueRetryBase    = 30 * time.Second
+	mailQueueRetryMax     = time.Hour
+)
+
+// OutgoingMessage is a rendered mail waiting to be sent, or the record of one
+// that was sent or given up on. Handlers only enqueue, the mail queue workers send.
+type OutgoingMessage struct {
+	ID                  uint      `gorm:"primaryKey"`
+	Template            string    `gorm:"not null"`
+	Locale              string    `gorm:"not null"`
+	Recipient           string    `gorm:"not null"`
+	RecipientNormalized string    `gorm:"index"` // Case-folded lookup key, backfilled by migrateNormalizedRecipients
+	Subject             string    `gorm:"not null"`
+	Text                string    `gorm:"type:text;not null"`
+	HTML                string    `gorm:"type:text"`
+	MessageIDHeader     string    `gorm:"index"` // Message-ID header, how delivery events refer to the message
+	SendRequestID       *uint     `gorm:"index"` // The /v1/send request that queued the message, see SendRequest
+	Status              string    `gorm:"not null;index:idx_outgoing_messages_due,priority:1"`
+	Attempts            int       `gorm:"not null;default:0"`
+	NextAttemptAt       time.Time `gorm:"not null;index:idx_outgoing_messages_due,priority:2"`
+	LastError           string
+	SentAt              *time.Time
+	CreatedAt           time.Time `gorm:"autoCreateTime"`
+	UpdatedAt           time.Time `gorm:"autoUpdateTime"`
+}
+
+// ErrInvalidRecipient is returned when enqueueing mail for an invalid address
+var ErrInvalidRecipient = errors.New("invalid recipient")
+
+// Queue settings, see MAIL_SERVICE_QUEUE_* in env.go
+var mailQueueWorkers, mailQueueMaxAttempts, mailQueueSettingsErr = parseQueueSettings()
+
+// parseQueueSettings reads the worker count (default 4) and attempts before a
+// message is dead-lettered (default 8)
+func parseQueueSettings() (int, int, error) {
+	workers, attempts := 4, 8
+	if QueueWorkers != "" {
+		n, err := strconv.Atoi(QueueWorkers)
+		if err != nil || n < 1 {
+			return workers, attempts, fmt.Errorf("invalid worker count %q", QueueWorkers)
+		}
+		workers = n
+	}
+	if QueueMaxAttempts != "" {
+		n, err := strconv.Atoi(QueueMaxAttempts)
+		if err != nil || n < 1 {
+			return workers, attempts, fmt.Errorf("invalid max attempts %q", QueueMaxAttempts)
+		}
+		attempts = n
+	}
+	return workers, attempts, nil
+}
+
+// mailQueueWake wakes the dispatcher when a message is enqueued, so it goes out
+// right away instead of at the next poll
+var mailQueueWake = make(chan struct{}, 1)
+
+// enqueueTemplate renders a template and queues the mail within tx, carrying
+// the attachments (see resolveAttachments)
+func enqueueTemplate(tx *gorm.DB, to, name, locale string, data map[string]interface{}, attachments []MessageAttachment) (*OutgoingMessage, error) {
+	var inline []string
+	for _, a := range attachments {
+		if a.ContentID != "" {
+			inline = append(inline, a.ContentID)
+		}
+	}
+	rendered, err := RenderTemplate(name, locale, data, inline)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := (&Message{To: []string{to}}).Recipients(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+	}
+
+	messageID, err := newMessageID(mailerConfig.From.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &OutgoingMessage{
+		Template:            name,
+		Locale:              rendered.Locale,
+		Recipient:           to,
+		RecipientNormalized: identifier.Normalize(to),
+		Subject:             rendered.Subject,
+		Text:                rendered.Text,
+		HTML:                rendered.HTML,
+		MessageIDHeader:     messageID,
+		Status:              messageQueued,
+		NextAttemptAt:       time.Now(),
+	}
+	if err := tx.Create(msg).Error; err != nil {
+		return nil, err
+	}
+	if len(attachments) > 0 {
+		carried := make([]MessageAttachment, len(attachments))
+		for i, a := range attachments {
+			carried[i] = MessageAttachment{MessageID: msg.ID, AttachmentID: a.AttachmentID, ContentID: a.ContentID}
+		}
+		if err := tx.Omit(clause.Associations).Create(&carried).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := recordEvent(tx, msg.ID, messageQueued, eventSourceQueue, "", msg.CreatedAt); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// wakeMailQueue tells the dispatcher that there is new work, without blocking
+func wakeMailQueue() {
+	select {
+	case mailQueueWake <- struct{}{}:
+	default:
+	}
+}
+
+// mailQueueRetryDelay is the backoff before the next attempt after attempts failures
+func mailQueueRetryDelay(attempts int) time.Duration {
+	delay := mailQueueRetryBase
+	for i := 1; i < attempts && delay < mailQueueRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > mailQueueRetryMax {
+		delay = mailQueueRetryMax
+	}
+	return delay
+}
+
+// runMailQueue claims due messages and hands them to a pool of workers until
+// the process exits. Several instances may run concurrently.
+func (app *Config) runMailQueue(workers int) {
+	jobs := make(chan OutgoingMessage)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for msg := range jobs {
+				if err := app.deliverMessage(msg); err != nil {
+					log.Printf("❌ Mail %d: %v", msg.ID, err)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(mailQueuePollInterval)
+	defer ticker.Stop()
+	for {
+		messages, err := app.claimMessages()
+		if err != nil {
+			log.Printf("❌ Mail queue poll failed: %v", err)
+		}
+		for _, msg := range messages {
+			jobs <- msg
+		}
+		// A full batch means there may be more due right away
+		if len(messages) == mailQueueBatchSize {
+			continue
+		}
+		select {
+		case <-ticker.C:
+		case <-mailQueueWake:
+		}
+	}
+}
+
+// claimMessages claims due messages by pushing their next attempt past the lease
+func (app *Config) claimMessages() ([]OutgoingMessage, error) {
+	var messages []OutgoingMessage
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?", []string{messageQueued, messageDeferred}, time.Now()).
+			Order("next_attempt_at").
+			Limit(mailQueueBatchSize).
+			Find(&messages).Error
+		if err != nil || len(messages) == 0 {
+			return err
+		}
+		ids := make([]uint, 0, len(messages))
+		for _, msg := range messages {
+			ids = append(ids, msg.ID)
+		}
+		return tx.Model(&OutgoingMessage{}).Where("id IN ?", ids).Update("next_attempt_at", time.Now().Add(mailQueueLease)).Error
+	})
+	return messages, err
+}
+
+// deliverMessage makes one send attempt and records its outcome
+func (app *Config) deliverMessage(msg OutgoingMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mailQueueSendTimeout)
+	defer cancel()
+
+	// Messages queued before Message-IDs were stored get one now
+	if msg.MessageIDHeader == "" {
+		messageID, err := newMessageID(mailerConfig.From.Address)
+		if err != nil {
+			return err
+		}
+		msg.MessageIDHeader = messageID
+	}
+
+	category := mailTemplates[msg.Template].Category
+	suppression, err := findSuppression(app.DB, msg.Recipient, category)
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	if suppression == nil {
+		attachments, err := app.messageAttachments(msg.ID)
+		if err != nil {
+			return err
+		}
+		sendErr = app.Mailer.Send(ctx, &Message{
+			MessageID:   msg.MessageIDHeader,
+			From:        mailerConfig.From,
+			To:          []string{msg.Recipient},
+			Subject:     msg.Subject,
+			Text:        msg.Text,
+			HTML:        msg.HTML,
+			Headers:     listUnsubscribeHeaders(msg.Recipient, category),
+			Attachments: attachments,
+		})
+		msg.Attempts++
+	}
+
+	now := time.Now()
+	msg.LastError = ""
+	switch {
+	case suppression != nil:
+		msg.Status = messageSuppressed
+		msg.LastError = "recipient " + suppression.Reason
+		log.Printf("⚠️ Mail %d (%s) suppressed, the recipient %s", msg.ID, msg.Template, suppression.Reason)
+	case sendErr == nil:
+		msg.Status = messageSent
+		msg.SentAt = &now
+		log.Printf("✅ Mail %d (%s) sent", msg.ID, msg.Template)
+	case isPermanentSendError(sendErr):
+		msg.Status = messageBounced
+		msg.LastError = sendErr.Error()
+		log.Printf("❌ Mail %d (%s) bounced: %v", msg.ID, msg.Template, sendErr)
+	case msg.Attempts >= mailQueueMaxAttempts:
+		msg.Status = messageDead
+		msg.LastError = sendErr.Error()
+		log.Printf("❌ Mail %d (%s) dead after %d attempt(s): %v", msg.ID, msg.Template, msg.Attempts, sendErr)
+	default:
+		msg.Status = messageDeferred
+		msg.NextAttemptAt = now.Add(mailQueueRetryDelay(msg.Attempts))
+		msg.LastError = sendErr.Error()
+		log.Printf("⚠️ Mail %d (%s) attempt %d failed, retrying at %s: %v", msg.ID, msg.Template, msg.Attempts, msg.NextAttemptAt.Format(time.RFC3339), sendErr)
+	}
+	// Secrets such as auth codes and token links are only kept for as long as the mail may still be sent
+	if msg.Status != messageDeferred && mailTemplates[msg.Template].Secret {
+		msg.Text, msg.HTML = "", ""
+	}
+	return app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&msg).Error; err != nil {
+			return err
+		}
+		if msg.Status == messageBounced {
+			if err := suppress(tx, msg.Recipient, "", suppressionBounced, msg.LastError, &msg.ID); err != nil {
+				return err
+			}
+		}
+		return recordEvent(tx, msg.ID, msg.Status, eventSourceQueue, msg.LastError, now)
+	})
+}
+
+// isPermanentSendError reports whether retrying cannot help, the message
+// bounced: the SMTP server rejected the recipient (550 mailbox unavailable,
+// 551 not local, 553 mailbox name not allowed). Other 5xx replies, such as 535
+// for bad credentials, are configuration problems that are retried until they
+// are fixed.
+func isPermanentSendError(err error) bool {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return false
+	}
+	return protoErr.Code == 550 || protoErr.Code == 551 || protoErr.Code == 553
+}
+
+// MessageStatusResponse is the status of an outgoing message
+type MessageStatusResponse struct {
+	ID            uint       `json:"id"`
+	Template      string     `json:"template"`
+	Locale        string     `json:"locale"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// MessageStatusHandler reports the delivery status of a queued message, for
+// internal services authenticated by SendClientMiddleware. SMTP replies may
+// name other recipients or the relay, they stay in the admin message log.
+func (app *Config) MessageStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+
+	var msg OutgoingMessage
+	if err := app.DB.First(&msg, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "Message not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+
+	response := MessageStatusResponse{
+		ID:        msg.ID,
+		Template:  msg.Template,
+		Locale:    msg.Locale,
+		Status:    msg.Status,
+		Attempts:  msg.Attempts,
+		SentAt:    msg.SentAt,
+		CreatedAt: msg.CreatedAt,
+	}
+	if msg.Status == messageQueued || msg.Status == messageDeferred {
+		response.NextAttemptAt = &msg.NextAttemptAt
+	}
+	writeJSON(w, http.StatusOK, response)
+}
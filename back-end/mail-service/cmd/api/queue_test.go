@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakeMailer records the messages it is given and fails with err
+type fakeMailer struct {
+	err  error
+	sent []*Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg *Message) error {
+	m.sent = append(m.sent, msg)
+	return m.err
+}
+
+// queueTestMessage queues a mail of template to recipient with the template's sample data
+func queueTestMessage(t *testing.T, app *Config, template, recipient string) OutgoingMessage {
+	t.Helper()
+	var msg *OutgoingMessage
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		msg, err = enqueueTemplate(tx, recipient, template, "en", mailTemplates[template].Sample, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *msg
+}
+
+func TestMailQueueRetryDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		50: time.Hour,
+	}
+	for attempts, want := range tests {
+		if got := mailQueueRetryDelay(attempts); got != want {
+			t.Errorf("%d attempts: expected %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+// Only rejections of the recipient are permanent, other errors are retried
+func TestIsPermanentSendError(t *testing.T) {
+	tests := map[error]bool{
+		&textproto.Error{Code: 550, Msg: "mailbox unavailable"}:                         true,
+		&textproto.Error{Code: 551, Msg: "user not local"}:                              true,
+		&textproto.Error{Code: 553, Msg: "mailbox name not allowed"}:                    true,
+		fmt.Errorf("rcpt: %w", &textproto.Error{Code: 550, Msg: "mailbox unavailable"}): true,
+		&textproto.Error{Code: 535, Msg: "authentication failed"}:                       false,
+		&textproto.Error{Code: 552, Msg: "exceeded storage allocation"}:                 false,
+		&textproto.Error{Code: 421, Msg: "service not available"}:                       false,
+		errors.New("connection refused"):                                                false,
+	}
+	for err, want := range tests {
+		if got := isPermanentSendError(err); got != want {
+			t.Errorf("%v: expected %v, got %v", err, want, got)
+		}
+	}
+}
+
+// Each outcome of an attempt moves the message to its state, secret bodies are
+// cleared once the mail will not be sent again
+func TestDeliverMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		sendErr      error
+		attempts     int  // Earlier attempts
+		suppressed   bool // The recipient bounced before
+		wantStatus   string
+		wantAttempts int
+		wantBodies   bool
+	}{
+		{"sent", nil, 0, false, messageSent, 1, false},
+		{"temporary failure", &textproto.Error{Code: 421, Msg: "try later"}, 0, false, messageDeferred, 1, true},
+		{"configuration error", &textproto.Error{Code: 535, Msg: "bad credentials"}, 2, false, messageDeferred, 3, true},
+		{"last attempt", &textproto.Error{Code: 421, Msg: "try later"}, mailQueueMaxAttempts - 1, false, messageDead, mailQueueMaxAttempts, false},
+		{"rejected recipient", &textproto.Error{Code: 550, Msg: "no such user"}, 0, false, messageBounced, 1, false},
+		{"suppressed recipient", nil, 0, true, messageSuppressed, 0, false},
+	}
+	for i, tt := range tests {
+		app := newTestApp(t)
+		mailer := &fakeMailer{err: tt.sendErr}
+		app.Mailer = mailer
+		recipient := fmt.Sprintf("user%d@example.com", i)
+		if tt.suppressed {
+			if err := suppress(app.DB, recipient, "", suppressionBounced, "550", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		msg := queueTestMessage(t, app, "invitation", recipient)
+		msg.Attempts = tt.attempts
+
+		before := time.Now()
+		if err := app.deliverMessage(msg); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		var stored OutgoingMessage
+		if err := app.DB.First(&stored, msg.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != tt.wantStatus || stored.Attempts != tt.wantAttempts {
+			t.Errorf("%s: expected %s after %d attempts, got %s after %d", tt.name, tt.wantStatus, tt.wantAttempts, stored.Status, stored.Attempts)
+		}
+		if hasBodies := stored.Text != "" && stored.HTML != ""; hasBodies != tt.wantBodies {
+			t.Errorf("%s: expected bodies kept %v, got text %q", tt.name, tt.wantBodies, stored.Text)
+		}
+		if tt.suppressed != (len(mailer.sent) == 0) {
+			t.Errorf("%s: expected the mailer to be called %v, got %d sends", tt.name, !tt.suppressed, len(mailer.sent))
+		}
+		if tt.sendErr != nil && stored.LastError != tt.sendErr.Error() {
+			t.Errorf("%s: expected the send error to be kept, got %q", tt.name, stored.LastError)
+		}
+
+		switch tt.wantStatus {
+		case messageSent:
+			if stored.SentAt == nil {
+				t.Errorf("%s: expected SentAt to be set", tt.name)
+			}
+			if sent := mailer.sent[0]; sent.MessageID != msg.MessageIDHeader || sent.To[0] != recipient || sent.Text == "" {
+				t.Errorf("%s: unexpected message %+v", tt.name, sent)
+			}
+		case messageDeferred:
+			wantNext := before.Add(mailQueueRetryDelay(tt.wantAttempts))
+			if stored.NextAttemptAt.Before(wantNext.Add(-time.Second)) || stored.NextAttemptAt.After(wantNext.Add(time.Second)) {
+				t.Errorf("%s: expected the next attempt at %s, got %s", tt.name, wantNext, stored.NextAttemptAt)
+			}
+		case messageBounced:
+			suppression, err := findSuppression(app.DB, recipient, categoryInvitation)
+			if err != nil || suppression == nil || suppression.Reason != suppressionBounced || suppression.MessageID == nil || *suppression.MessageID != msg.ID {
+				t.Errorf("%s: expected a bounce suppression of the message, got %+v, %v", tt.name, suppression, err)
+			}
+		case messageSuppressed:
+			if stored.LastError != "recipient "+suppressionBounced {
+				t.Errorf("%s: expected the suppression reason, got %q", tt.name, stored.LastError)
+			}
+		}
+
+		var event MessageEvent
+		if err := app.DB.Where("message_id = ?", msg.ID).Order("id DESC").First(&event).Error; err != nil || event.Type != tt.wantStatus {
+			t.Errorf("%s: expected a %s event, got %+v, %v", tt.name, tt.wantStatus, event, err)
+		}
+	}
+}
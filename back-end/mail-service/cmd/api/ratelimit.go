@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"shared/identifier"
+	"shared/ratelimit"
+)
+
+// Names of the rate limits, as used in MAIL_SERVICE_RATE_LIMITS
+const (
+	rateLimitIP        = "ip"        // All requests, per client IP
+	rateLimitAuthCode  = "auth-code" // Auth code mails, per client IP
+	rateLimitRecipient = "recipient" // Auth code mails, per recipient address
+	rateLimitVerify    = "verify"    // Auth code verifications, per client IP
+)
+
+// mailAddressBodyMaxSize bounds the body byMailAddress reads, auth code
+// requests are far smaller
+const mailAddressBodyMaxSize = 4 << 10
+
+// defaultRateLimits apply unless overridden in MAIL_SERVICE_RATE_LIMITS
+var defaultRateLimits = map[string]ratelimit.Limit{
+	rateLimitIP:        {Requests: 300, Period: time.Minute},
+	rateLimitAuthCode:  {Requests: 5, Period: 10 * time.Minute},
+	rateLimitRecipient: {Requests: 3, Period: 10 * time.Minute},
+	rateLimitVerify:    {Requests: 10, Period: time.Minute},
+}
+
+// rateLimits are the configured limits, e.g. MAIL_SERVICE_RATE_LIMITS="verify=20/1m,ip=off"
+var rateLimits, rateLimitsErr = ratelimit.ParseLimits(RateLimits, defaultRateLimits)
+
+// newRateLimiter opens the bucket store configured in MAIL_SERVICE_RATE_LIMIT_STORE
+func newRateLimiter() (*ratelimit.Limiter, error) {
+	store, err := ratelimit.OpenStore(RateLimitStore)
+	if err != nil {
+		return nil, err
+	}
+	return ratelimit.New(store, rateLimits), nil
+}
+
+// RateLimit limits requests under the limit called name, counted per key.
+// Without a limiter (tests) requests pass unchecked.
+func (app *Config) RateLimit(name string, key ratelimit.KeyFunc) func(http.Handler) http.Handler {
+	if app.Limiter == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return app.Limiter.Handler(name, key)
+}
+
+// byMailAddress counts requests per normalized mailAddress of the JSON body,
+// so one address cannot be flooded from many IPs. The body is restored for
+// the handler, cut off after mailAddressBodyMaxSize so it fails to decode.
+func byMailAddress(r *http.Request) string {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, mailAddressBodyMaxSize))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		MailAddress string `json:"mailAddress"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	return identifier.Normalize(req.MailAddress)
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"shared/ratelimit"
 )
 
 // Define routes for the application
@@ -20,6 +22,18 @@ func (app *Config) routes() http.Handler {
 
 	app.publicRoutes(mux) // Public routes (no authentication required)
 
+	// Admin routes (JWT of an Admin required)
+	mux.Group(func(r chi.Router) {
+		r.Use(AdminMiddleware)
+		app.adminRoutes(r)
+	})
+
+	// Internal routes (client credentials of another service required)
+	mux.Group(func(r chi.Router) {
+		r.Use(SendClientMiddleware)
+		app.internalRoutes(r)
+	})
+
 	return mux
 }
 
@@ -49,8 +63,32 @@ func (app *Config) setupMiddleware(mux *chi.Mux) {
 // Public routes
 func (app *Config) publicRoutes(mux *chi.Mux) {
 	mux.Get("/health", app.HealthCheckHandler)
-	mux.Post("/send-auth-code-mail", app.GenerateAndSendAuthCode)
-	mux.Delete("/delete-mail", app.DeleteMailHandler)
-	mux.Post("/signin", app.SigninHandler)
+	mux.With(app.RateLimit(rateLimitAuthCode, ratelimit.ByIP), app.RateLimit(rateLimitRecipient, byMailAddress)).Post("/send-auth-code-mail", app.GenerateAndSendAuthCode)
+	mux.With(app.RateLimit(rateLimitVerify, ratelimit.ByIP)).Post("/verify-auth-code", app.VerifyAuthCodeHandler)
 	mux.Get("/metrics", promhttp.Handler().ServeHTTP)
+	mux.Get("/openapi.json", app.OpenAPIHandler)
+	mux.Post("/webhooks/delivery-events", app.DeliveryEventsWebhookHandler) // Signed with MAIL_SERVICE_WEBHOOK_SECRET
+	mux.Post("/webhooks/dsn", app.DSNWebhookHandler)                        // Signed with MAIL_SERVICE_WEBHOOK_SECRET
+	mux.Get("/unsubscribe", app.UnsubscribePageHandler)
+	mux.Post("/unsubscribe", app.UnsubscribeHandler) // RFC 8058 one-click unsubscribe
+}
+
+// Admin routes
+func (app *Config) adminRoutes(r chi.Router) {
+	r.Get("/v1/templates", app.ListTemplatesHandler)
+	r.Get("/v1/templates/{name}/preview", app.PreviewTemplateHandler)
+	r.Get("/v1/messages", app.ListMessagesHandler)
+	r.Get("/v1/messages/{id}", app.GetMessageLogHandler)
+	r.Get("/v1/suppressions", app.ListSuppressionsHandler)
+	r.Delete("/v1/suppressions/{id}", app.DeleteSuppressionHandler)
+	r.Get("/v1/dkim/self-test", app.DKIMSelfTestHandler)
+}
+
+// Internal routes
+func (app *Config) internalRoutes(r chi.Router) {
+	r.Post("/v1/send", app.SendMailHandler)
+	r.Post("/v1/attachments", app.UploadAttachmentHandler)
+	r.Delete("/v1/attachments/{id}", app.DeleteAttachmentHandler)
+	r.Delete("/delete-mail", app.DeleteMailHandler)
+	r.Get("/messages/{id}", app.MessageStatusHandler)
 }
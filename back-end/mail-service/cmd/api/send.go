@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"shared/clientauth"
+)
+
+const sendMaxRecipients = 50 // Recipients of one /v1/send request, each gets their own message
+
+// Clients allowed to call /v1/send, see MAIL_SERVICE_SEND_CLIENTS
+var sendClients, sendClientsErr = clientauth.Parse(SendClients)
+
+// SendClientMiddleware only lets through internal services with client
+// credentials from MAIL_SERVICE_SEND_CLIENTS, sent with HTTP Basic
+// authentication. Without configured clients the endpoints are unavailable.
+func SendClientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(sendClients) == 0 {
+			writeError(w, http.StatusServiceUnavailable, "Send API is disabled")
+			return
+		}
+
+		id, secret, ok := r.BasicAuth()
+		if !ok || !sendClients.Verify(id, secret) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mail-service"`)
+			writeError(w, http.StatusUnauthorized, "Invalid client credentials")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SendRequest is the same request for a /v1/send idempotency key, so a retry
+// returns the messages of the first attempt instead of sending them again.
+// Keys are scoped to the client and kept as long as their messages.
+type SendRequest struct {
+	ID             uint      `gorm:"primaryKey"`
+	ClientID       string    `gorm:"not null;uniqueIndex:idx_send_requests_client_key,priority:1"`
+	IdempotencyKey string    `gorm:"not null;uniqueIndex:idx_send_requests_client_key,priority:2"`
+	RequestHash    string    `gorm:"not null"` // A key reused for a different request is a conflict
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+}
+
+// ErrIdempotencyConflict is returned when an idempotency key was used for a different request
+var ErrIdempotencyConflict = errors.New("idempotency key was used for a different request")
+
+// SendMailRequest asks for a template to be mailed to each recipient
+type SendMailRequest struct {
+	Template       string                 `json:"template"`
+	To             []string               `json:"to"`
+	Locale         string                 `json:"locale"` // Defaults to Accept-Language
+	Data           map[string]interface{} `json:"data"`
+	Attachments    []AttachmentRef        `json:"attachments"`
+	IdempotencyKey string                 `json:"idempotencyKey"` // Or the Idempotency-Key header
+}
+
+// hash identifies the request for its idempotency key, locale as resolved
+func (req *SendMailRequest) hash(locale string) (string, error) {
+	canonical, err := json.Marshal(map[string]interface{}{
+		"template":    req.Template,
+		"to":          req.To,
+		"locale":      locale,
+		"data":        req.Data,
+		"attachments": req.Attachments,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SentMessage is a message queued by /v1/send
+type SentMessage struct {
+	ID        uint   `json:"id"`
+	Recipient string `json:"recipient"`
+	Status    string `json:"status"`
+}
+
+// claimIdempotencyKey records the key for the request within tx and returns
+// the new record, or the record of an earlier attempt with the same key.
+// Concurrent attempts wait on the unique index until the first one is done.
+func claimIdempotencyKey(tx *gorm.DB, clientID, key, hash string) (*SendRequest, *SendRequest, error) {
+	record := &SendRequest{ClientID: clientID, IdempotencyKey: key, RequestHash: hash}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return record, nil, nil
+	}
+
+	var earlier SendRequest
+	if err := tx.Where("client_id = ? AND idempotency_key = ?", clientID, key).First(&earlier).Error; err != nil {
+		return nil, nil, err
+	}
+	if earlier.RequestHash != hash {
+		return nil, nil, ErrIdempotencyConflict
+	}
+	return nil, &earlier, nil
+}
+
+// SendMailHandler queues a template for each recipient, for internal services
+// authenticated by SendClientMiddleware. Every recipient gets their own
+// message, so suppressions and unsubscribe links apply per recipient.
+func (app *Config) SendMailHandler(w http.ResponseWriter, r *http.Request) {
+	var req SendMailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return
+	}
+	if len(req.To) == 0 || len(req.To) > sendMaxRecipients {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("to must list 1 to %d recipients", sendMaxRecipients))
+		return
+	}
+	key := valueOr(r.Header.Get("Idempotency-Key"), req.IdempotencyKey)
+	if req.IdempotencyKey != "" && key != req.IdempotencyKey {
+		writeError(w, http.StatusBadRequest, "Idempotency-Key header and idempotencyKey differ")
+		return
+	}
+
+	clientID, _, _ := r.BasicAuth()
+	locale := resolveLocale(req.Locale, r.Header.Get("Accept-Language"))
+	hash, err := req.hash(locale)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return
+	}
+
+	var messages []OutgoingMessage
+	replayed := false
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		var sendRequestID *uint
+		if key != "" {
+			record, earlier, err := claimIdempotencyKey(tx, clientID, key, hash)
+			if err != nil {
+				return err
+			}
+			if earlier != nil {
+				replayed = true
+				return tx.Where("send_request_id = ?", earlier.ID).Order("id").Find(&messages).Error
+			}
+			sendRequestID = &record.ID
+		}
+
+		attachments, err := resolveAttachments(tx, clientID, req.Attachments)
+		if err != nil {
+			return err
+		}
+		for _, to := range req.To {
+			msg, err := enqueueTemplate(tx, to, req.Template, locale, req.Data, attachments)
+			if err != nil {
+				return err
+			}
+			if sendRequestID != nil {
+				if err := tx.Model(msg).UpdateColumn("send_request_id", *sendRequestID).Error; err != nil {
+					return err
+				}
+			}
+			messages = append(messages, *msg)
+		}
+		return nil
+	})
+	if err != nil {
+		var dataErr *TemplateDataError
+		switch {
+		case errors.Is(err, ErrIdempotencyConflict):
+			writeError(w, http.StatusConflict, "Idempotency key was already used for a different request")
+		case errors.As(err, &dataErr) || errors.Is(err, ErrUnknownTemplate) || errors.Is(err, ErrInvalidRecipient) || errors.Is(err, ErrInvalidAttachment):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			log.Printf("❌ Failed to queue %s for client %s: %v", req.Template, clientID, err)
+			writeError(w, http.StatusInternalServerError, ErrDatabase)
+		}
+		return
+	}
+
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	} else {
+		wakeMailQueue()
+		log.Printf("📧 Client %s queued %s for %d recipient(s)", clientID, req.Template, len(messages))
+	}
+
+	response := make([]SentMessage, 0, len(messages))
+	for _, msg := range messages {
+		response = append(response, SentMessage{ID: msg.ID, Recipient: msg.Recipient, Status: msg.Status})
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"messages": response})
+}
@@ -15,10 +15,20 @@ func startServer() {
 		log.Fatalf("❌ Database connection failed : %v", err)
 	}
 
+	limiter, err := newRateLimiter()
+	if err != nil {
+		log.Fatalf("❌ Rate limit store failed : %v", err)
+	}
+
+	app := &Config{DB: db, Mailer: newMailer(mailerConfig), Limiter: limiter}
+
+	// Sends the queued mail in the background
+	go app.runMailQueue(mailQueueWorkers)
+
 	fmt.Printf("🚀 %s is running on port: %s\n", ServiceName, ServicePort)
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", ServicePort),
-		Handler: (&Config{DB: db}).routes(), // Pass db to routes
+		Handler: app.routes(), // Pass db to routes
 	}
 
 	err = srv.ListenAndServe()
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileMailer writes every message as an .eml file into dir, for local development
+type fileMailer struct {
+	dir string
+}
+
+// Send implements Mailer
+func (m *fileMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := msg.Bytes(time.Now())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	name, err := uniqueFileName()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(m.dir, name+".eml")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	log.Printf("📁 Mail to %v written to %s", msg.To, path)
+	return nil
+}
+
+// maildirMailer delivers messages into a Maildir (tmp/new/cur), readable by
+// mutt, Thunderbird and most local mail tools
+type maildirMailer struct {
+	dir string
+}
+
+// Send implements Mailer. The message is written to tmp/ and then renamed into
+// new/ so readers never see a partial file.
+func (m *maildirMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := msg.Bytes(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.dir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+	name, err := uniqueFileName()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(m.dir, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(m.dir, "new", name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	log.Printf("📁 Mail to %v delivered to maildir %s", msg.To, m.dir)
+	return nil
+}
+
+// uniqueFileName returns a Maildir style "<time>.<random>.<host>" name
+func uniqueFileName() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), hex.EncodeToString(random), localName()), nil
+}
+
+// logMailer only logs messages, nothing is delivered
+type logMailer struct{}
+
+// Send implements Mailer
+func (logMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := msg.Bytes(time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("📧 Mail not delivered (log transport):\n%s", data)
+	return nil
+}
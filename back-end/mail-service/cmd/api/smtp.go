@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP connection security
+const (
+	smtpStartTLS    = "starttls" // Plain connection upgraded with STARTTLS, required (default)
+	smtpImplicitTLS = "tls"      // TLS from the first byte (SMTPS, usually port 465)
+	smtpNoTLS       = "none"     // Unencrypted, only for local relays and test servers
+)
+
+// SMTP auth mechanisms
+const (
+	smtpAuthPlain   = "plain"
+	smtpAuthLogin   = "login"
+	smtpAuthCRAMMD5 = "cram-md5"
+	smtpAuthNone    = "none"
+)
+
+// smtpMailer delivers messages through an SMTP server, one connection per message
+type smtpMailer struct {
+	host     string
+	port     int
+	security string
+	auth     string
+	username string
+	password string
+	timeout  time.Duration
+}
+
+// Send implements Mailer
+func (m *smtpMailer) Send(ctx context.Context, msg *Message) error {
+	recipients, err := msg.Recipients()
+	if err != nil {
+		return err
+	}
+	data, err := msg.Bytes(time.Now())
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(m.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	// The deadline covers the whole SMTP transaction
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err := m.send(c, msg.From.Address, recipients, data); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("smtp %s:%d: %w", m.host, m.port, ctx.Err())
+		}
+		return fmt.Errorf("smtp %s:%d: %w", m.host, m.port, err)
+	}
+	return nil
+}
+
+// dial opens the connection, already in TLS for implicit TLS
+func (m *smtpMailer) dial(ctx context.Context) (net.Conn, error) {
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	if m.security == smtpImplicitTLS {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: m.host}}
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// send runs the SMTP transaction on an open client
+func (m *smtpMailer) send(c *smtp.Client, from string, recipients []string, data []byte) error {
+	if err := c.Hello(localName()); err != nil {
+		return err
+	}
+
+	if m.security == smtpStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+
+	if auth := m.smtpAuth(); auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("server does not support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// smtpAuth returns the configured auth mechanism, nil when disabled
+func (m *smtpMailer) smtpAuth() smtp.Auth {
+	if m.auth == smtpAuthNone || m.username == "" {
+		return nil
+	}
+	switch m.auth {
+	case smtpAuthLogin:
+		return &loginAuth{username: m.username, password: m.password, host: m.host}
+	case smtpAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(m.username, m.password)
+	default:
+		return smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp lacks but Office 365
+// and many older servers still require
+type loginAuth struct {
+	username, password, host string
+}
+
+// Start implements smtp.Auth. Like PlainAuth it refuses to send credentials
+// over an unencrypted connection, except to localhost.
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+// Next implements smtp.Auth
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// localName is the name sent with EHLO
+func localName() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "localhost"
+}
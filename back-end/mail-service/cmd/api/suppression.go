@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"shared/identifier"
+)
+
+// Mail categories, see templateSpec.Category. Recipients unsubscribe per category.
+const (
+	categoryTransactional = "transactional" // Mail the recipient just asked for or needs for their account's security
+	categoryInvitation    = "invitation"    // Invitations to join, sent on someone else's initiative
+)
+
+// categorySpec describes how mail of a category is sent
+type categorySpec struct {
+	Unsubscribe bool // Carries List-Unsubscribe headers
+}
+
+var mailCategories = map[string]categorySpec{
+	categoryTransactional: {Unsubscribe: false},
+	categoryInvitation:    {Unsubscribe: true},
+}
+
+// Suppression reasons
+const (
+	suppressionBounced      = "bounced"      // Hard bounce, by the SMTP server, the provider or a DSN
+	suppressionComplained   = "complained"   // Reported as spam
+	suppressionUnsubscribed = "unsubscribed" // One-click unsubscribe from a category
+)
+
+// Suppression stops mail to a recipient, for one category or for all of them
+// (empty Category). Categories in MAIL_SERVICE_SUPPRESSION_EXEMPT are sent anyway.
+type Suppression struct {
+	ID                  uint   `gorm:"primaryKey"`
+	Recipient           string `gorm:"not null"`
+	RecipientNormalized string `gorm:"not null;uniqueIndex:idx_suppressions_recipient_category,priority:1"`
+	Category            string `gorm:"not null;default:'';uniqueIndex:idx_suppressions_recipient_category,priority:2"`
+	Reason              string `gorm:"not null"`
+	Detail              string
+	MessageID           *uint     // The message that bounced or was complained about, if any
+	CreatedAt           time.Time `gorm:"autoCreateTime"`
+}
+
+// Categories sent despite suppressions, see MAIL_SERVICE_SUPPRESSION_EXEMPT
+var suppressionExempt, suppressionExemptErr = parseSuppressionExempt()
+
+// parseSuppressionExempt reads a comma-separated list of categories, by
+// default only transactional mail. "none" exempts no category.
+func parseSuppressionExempt() (map[string]bool, error) {
+	exempt := map[string]bool{}
+	spec := valueOr(SuppressionExempt, categoryTransactional)
+	if spec == "none" {
+		return exempt, nil
+	}
+	for _, category := range strings.Split(spec, ",") {
+		category = strings.TrimSpace(category)
+		if _, ok := mailCategories[category]; !ok {
+			return exempt, fmt.Errorf("unknown category %q", category)
+		}
+		exempt[category] = true
+	}
+	return exempt, nil
+}
+
+// suppress adds a suppression within tx, unless the recipient is already suppressed for category
+func suppress(tx *gorm.DB, recipient, category, reason, detail string, messageID *uint) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Suppression{
+		Recipient:           recipient,
+		RecipientNormalized: identifier.Normalize(recipient),
+		Category:            category,
+		Reason:              reason,
+		Detail:              detail,
+		MessageID:           messageID,
+	}).Error
+}
+
+// findSuppression returns the suppression that stops mail of category to
+// recipient, nil if it may be sent
+func findSuppression(db *gorm.DB, recipient, category string) (*Suppression, error) {
+	if suppressionExempt[category] {
+		return nil, nil
+	}
+	var suppression Suppression
+	err := db.Where("recipient_normalized = ? AND category IN ?", identifier.Normalize(recipient), []string{"", category}).
+		Order("id").First(&suppression).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &suppression, nil
+}
+
+// unsubscribeMAC signs the payload of an unsubscribe token
+func unsubscribeMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(UnsubscribeSecret))
+	mac.Write([]byte("unsubscribe." + payload))
+	return mac.Sum(nil)
+}
+
+// unsubscribeToken returns the token that unsubscribes recipient from
+// category. It does not expire, the mail may be read long after it was sent.
+func unsubscribeToken(recipient, category string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(identifier.Normalize(recipient) + "\x00" + category))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(unsubscribeMAC(payload))
+}
+
+// parseUnsubscribeToken verifies a token from unsubscribeToken
+func parseUnsubscribeToken(token string) (recipient, category string, err error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || UnsubscribeSecret == "" {
+		return "", "", errors.New("invalid token")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, unsubscribeMAC(payload)) {
+		return "", "", errors.New("invalid token")
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", errors.New("invalid token")
+	}
+	recipient, category, ok = strings.Cut(string(decoded), "\x00")
+	if !ok || recipient == "" {
+		return "", "", errors.New("invalid token")
+	}
+	if _, known := mailCategories[category]; !known {
+		return "", "", errors.New("invalid token")
+	}
+	return recipient, category, nil
+}
+
+// listUnsubscribeHeaders returns the RFC 8058 one-click unsubscribe headers for
+// mail of category to recipient, nil for categories without unsubscribe or
+// when MAIL_SERVICE_PUBLIC_URL or MAIL_SERVICE_UNSUBSCRIBE_SECRET is unset
+func listUnsubscribeHeaders(recipient, category string) map[string]string {
+	if !mailCategories[category].Unsubscribe || PublicURL == "" || UnsubscribeSecret == "" {
+		return nil
+	}
+	link := strings.TrimRight(PublicURL, "/") + "/unsubscribe?token=" + url.QueryEscape(unsubscribeToken(recipient, category))
+	return map[string]string{
+		"List-Unsubscribe":      "<" + link + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// unsubscribePage is shown to recipients who open the unsubscribe link. Opening
+// it must not unsubscribe, link scanners follow links in mail; the button posts
+// the same one-click request a mail client would.
+var unsubscribePage = htmltemplate.Must(htmltemplate.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Unsubscribe</title></head>
+<body style="font-family:Arial,Helvetica,sans-serif;max-width:480px;margin:48px auto;padding:0 16px;color:#18181b;">
+{{if .Done}}<p>{{.Recipient}} will no longer receive {{.Category}} mail.</p>
+{{else if .Error}}<p>{{.Error}}</p>
+{{else}}<p>Stop sending {{.Category}} mail to {{.Recipient}}?</p>
+<form method="post"><input type="hidden" name="List-Unsubscribe" value="One-Click"><button type="submit" style="padding:12px 24px;background:#18181b;color:#ffffff;border:0;border-radius:6px;font-weight:bold;">Unsubscribe</button></form>
+{{end}}</body></html>
+`))
+
+func writeUnsubscribePage(w http.ResponseWriter, status int, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := unsubscribePage.Execute(w, data); err != nil {
+		log.Printf("❌ Failed to render unsubscribe page: %v", err)
+	}
+}
+
+// UnsubscribePageHandler asks the recipient to confirm unsubscribing
+func (app *Config) UnsubscribePageHandler(w http.ResponseWriter, r *http.Request) {
+	recipient, category, err := parseUnsubscribeToken(r.URL.Query().Get("token"))
+	if err != nil {
+		writeUnsubscribePage(w, http.StatusBadRequest, map[string]interface{}{"Error": "This unsubscribe link is invalid."})
+		return
+	}
+	writeUnsubscribePage(w, http.StatusOK, map[string]interface{}{"Recipient": recipient, "Category": category})
+}
+
+// UnsubscribeHandler is the RFC 8058 one-click unsubscribe: a POST to the
+// List-Unsubscribe URL, sent by the mail client or by the confirmation page
+func (app *Config) UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	recipient, category, err := parseUnsubscribeToken(r.URL.Query().Get("token"))
+	if err != nil {
+		writeUnsubscribePage(w, http.StatusBadRequest, map[string]interface{}{"Error": "This unsubscribe link is invalid."})
+		return
+	}
+
+	if err := suppress(app.DB, recipient, category, suppressionUnsubscribed, "one-click unsubscribe", nil); err != nil {
+		log.Printf("❌ Failed to unsubscribe %s from %s: %v", recipient, category, err)
+		writeUnsubscribePage(w, http.StatusInternalServerError, map[string]interface{}{"Error": "Something went wrong, please try again later."})
+		return
+	}
+	log.Printf("📧 %s unsubscribed from %s mail", recipient, category)
+	writeUnsubscribePage(w, http.StatusOK, map[string]interface{}{"Done": true, "Recipient": recipient, "Category": category})
+}
+
+// SuppressionResponse is a suppression in the admin API
+type SuppressionResponse struct {
+	ID        uint      `json:"id"`
+	Recipient string    `json:"recipient"`
+	Category  string    `json:"category,omitempty"` // Empty for all categories
+	Reason    string    `json:"reason"`
+	Detail    string    `json:"detail,omitempty"`
+	MessageID *uint     `json:"messageId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ListSuppressionsHandler lists suppressions, newest first, optionally by
+// ?recipient and ?reason. ?before=<id> continues after the previous page.
+func (app *Config) ListSuppressionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := app.DB.Model(&Suppression{})
+	if recipient := r.URL.Query().Get("recipient"); recipient != "" {
+		query = query.Where("recipient_normalized = ?", identifier.Normalize(recipient))
+	}
+	if reason := r.URL.Query().Get("reason"); reason != "" {
+		query = query.Where("reason = ?", reason)
+	}
+	if before := r.URL.Query().Get("before"); before != "" {
+		id, err := strconv.ParseUint(before, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, `query parameter "before" must be a suppression id`)
+			return
+		}
+		query = query.Where("id < ?", id)
+	}
+
+	var suppressions []Suppression
+	if err := query.Order("id DESC").Limit(messageLogDefaultLimit).Find(&suppressions).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+
+	response := make([]SuppressionResponse, 0, len(suppressions))
+	for _, s := range suppressions {
+		response = append(response, SuppressionResponse{
+			ID:        s.ID,
+			Recipient: s.Recipient,
+			Category:  s.Category,
+			Reason:    s.Reason,
+			Detail:    s.Detail,
+			MessageID: s.MessageID,
+			CreatedAt: s.CreatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"suppressions": response})
+}
+
+// DeleteSuppressionHandler removes a suppression, e.g. once a bouncing mailbox was fixed
+func (app *Config) DeleteSuppressionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Suppression not found")
+		return
+	}
+	result := app.DB.Delete(&Suppression{}, id)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeError(w, http.StatusNotFound, "Suppression not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// withUnsubscribeSecret sets UnsubscribeSecret for the duration of a test
+func withUnsubscribeSecret(t *testing.T, secret string) {
+	t.Helper()
+	previous := UnsubscribeSecret
+	UnsubscribeSecret = secret
+	t.Cleanup(func() { UnsubscribeSecret = previous })
+}
+
+// signedToken signs an arbitrary payload the way unsubscribeToken does
+func signedToken(payload string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(unsubscribeMAC(encoded))
+}
+
+func TestParseUnsubscribeToken(t *testing.T) {
+	withUnsubscribeSecret(t, "secret")
+	token := unsubscribeToken("Ayse@Example.com", categoryInvitation)
+
+	recipient, category, err := parseUnsubscribeToken(token)
+	if err != nil || recipient != "ayse@example.com" || category != categoryInvitation {
+		t.Fatalf("expected ayse@example.com and invitation, got %q, %q, %v", recipient, category, err)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	otherCategory := base64.RawURLEncoding.EncodeToString([]byte("ayse@example.com\x00" + categoryTransactional))
+	otherRecipient := base64.RawURLEncoding.EncodeToString([]byte("mehmet@example.com\x00" + categoryInvitation))
+
+	rejected := map[string]string{
+		"tampered signature":    payload + "." + signature[:len(signature)-2] + "AA",
+		"other category":        otherCategory + "." + signature,
+		"other recipient":       otherRecipient + "." + signature,
+		"unknown category":      signedToken("ayse@example.com\x00marketing"),
+		"no category":           signedToken("ayse@example.com"),
+		"no recipient":          signedToken("\x00" + categoryInvitation),
+		"no signature":          payload,
+		"undecodable payload":   "!!!." + signature,
+		"undecodable signature": payload + ".!!!",
+		"empty":                 "",
+	}
+	for name, token := range rejected {
+		if _, _, err := parseUnsubscribeToken(token); err == nil {
+			t.Errorf("%s: expected the token to be rejected", name)
+		}
+	}
+
+	// Tokens of another secret, or without a secret, are rejected
+	withUnsubscribeSecret(t, "other")
+	if _, _, err := parseUnsubscribeToken(token); err == nil {
+		t.Errorf("expected a token of another secret to be rejected")
+	}
+	withUnsubscribeSecret(t, "")
+	if _, _, err := parseUnsubscribeToken(token); err == nil {
+		t.Errorf("expected tokens to be rejected without a secret")
+	}
+}
+
+func TestParseSuppressionExempt(t *testing.T) {
+	previous := SuppressionExempt
+	t.Cleanup(func() { SuppressionExempt = previous })
+
+	tests := map[string]map[string]bool{
+		"":                            {categoryTransactional: true},
+		"none":                        {},
+		"invitation":                  {categoryInvitation: true},
+		" transactional, invitation ": {categoryTransactional: true, categoryInvitation: true},
+	}
+	for spec, want := range tests {
+		SuppressionExempt = spec
+		exempt, err := parseSuppressionExempt()
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", spec, err)
+			continue
+		}
+		if len(exempt) != len(want) {
+			t.Errorf("%q: expected %v, got %v", spec, want, exempt)
+		}
+		for category := range want {
+			if !exempt[category] {
+				t.Errorf("%q: expected %s to be exempt", spec, category)
+			}
+		}
+	}
+	for _, spec := range []string{"marketing", "transactional,", "none,invitation"} {
+		SuppressionExempt = spec
+		if _, err := parseSuppressionExempt(); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+// Suppressions without category stop every category that is not exempt,
+// category suppressions only their own category
+func TestFindSuppression(t *testing.T) {
+	app := newTestApp(t)
+	previous := suppressionExempt
+	t.Cleanup(func() { suppressionExempt = previous })
+
+	if err := suppress(app.DB, "Bounced@example.com", "", suppressionBounced, "550 no such user", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := suppress(app.DB, "unsubscribed@example.com", categoryInvitation, suppressionUnsubscribed, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	// A second suppression of the same recipient and category is ignored
+	if err := suppress(app.DB, "unsubscribed@example.com", categoryInvitation, suppressionComplained, "", nil); err != nil {
+		t.Fatalf("expected a duplicate suppression to be ignored, got %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		exempt     map[string]bool
+		recipient  string
+		category   string
+		wantReason string
+	}{
+		{"bounce stops invitations", map[string]bool{categoryTransactional: true}, "bounced@example.com", categoryInvitation, suppressionBounced},
+		{"exempt category bypasses a bounce", map[string]bool{categoryTransactional: true}, "BOUNCED@example.com", categoryTransactional, ""},
+		{"bounce stops everything without exemptions", map[string]bool{}, "bounced@example.com", categoryTransactional, suppressionBounced},
+		{"unsubscribe stops its category", map[string]bool{}, "unsubscribed@example.com", categoryInvitation, suppressionUnsubscribed},
+		{"unsubscribe does not stop other categories", map[string]bool{}, "unsubscribed@example.com", categoryTransactional, ""},
+		{"other recipients are not suppressed", map[string]bool{}, "other@example.com", categoryInvitation, ""},
+	}
+	for _, tt := range tests {
+		suppressionExempt = tt.exempt
+		suppression, err := findSuppression(app.DB, tt.recipient, tt.category)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		reason := ""
+		if suppression != nil {
+			reason = suppression.Reason
+		}
+		if reason != tt.wantReason {
+			t.Errorf("%s: expected suppression %q, got %q", tt.name, tt.wantReason, reason)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/text/language"
+)
+
+// Mail templates, one .txt (subject and plain-text content) and one .html
+// (HTML content) per template and locale, wrapped in the shared layout.*
+//
+//go:embed templates
+var templateFS embed.FS
+
+// Locales with templates, the first one is the fallback of the matcher
+var supportedLocales = []language.Tag{language.English, language.Turkish}
+
+var localeMatcher = language.NewMatcher(supportedLocales)
+
+// templateSpec declares the data a template needs and sample data for previews
+type templateSpec struct {
+	Fields   []string
+	Sample   map[string]interface{}
+	Category string // See mailCategories, recipients unsubscribe and are suppressed per category
+	Secret   bool   // The mail carries a code or a token link, its bodies are cleared from the queue once sent
+}
+
+// mailTemplates lists every template under templates/<locale>/
+var mailTemplates = map[string]templateSpec{
+	"auth-code": {
+		Fields:   []string{"AuthCode", "ExpiresAt"},
+		Sample:   map[string]interface{}{"AuthCode": "123456", "ExpiresAt": sampleExpiry},
+		Category: categoryTransactional,
+		Secret:   true,
+	},
+	"email-change-confirmation": {
+		Fields:   []string{"Username", "ConfirmURL", "ExpiresAt"},
+		Sample:   map[string]interface{}{"Username": "ayse", "ConfirmURL": "https://example.com/confirm-email?token=sample", "ExpiresAt": sampleExpiry},
+		Category: categoryTransactional,
+		Secret:   true,
+	},
+	"email-change-notification": {
+		Fields:   []string{"Username", "NewMailAddress", "RevertURL", "ExpiresAt"},
+		Sample:   map[string]interface{}{"Username": "ayse", "NewMailAddress": "ayse.new@example.com", "RevertURL": "https://example.com/revert-email?token=sample", "ExpiresAt": sampleExpiry},
+		Category: categoryTransactional,
+		Secret:   true,
+	},
+	"account-invitation": {
+		Fields:   []string{"Username", "Role", "SetupURL", "ExpiresAt"},
+		Sample:   map[string]interface{}{"Username": "mehmet", "Role": "SalesRepresentative", "SetupURL": "https://example.com/setup-password?token=sample", "ExpiresAt": sampleExpiry},
+		Category: categoryInvitation,
+		Secret:   true,
+	},
+	"password-reset": {
+		Fields:   []string{"Username", "SetupURL", "ExpiresAt"},
+		Sample:   map[string]interface{}{"Username": "ayse", "SetupURL": "https://example.com/set-password?token=sample", "ExpiresAt": sampleExpiry},
+		Category: categoryTransactional,
+		Secret:   true,
+	},
+	"invitation": {
+		Fields:   []string{"InvitedBy", "Role", "AcceptURL", "ExpiresAt"},
+		Sample:   map[string]interface{}{"InvitedBy": "admin", "Role": "SalesRepresentative", "AcceptURL": "https://example.com/accept-invitation?token=sample", "ExpiresAt": sampleExpiry},
+		Category: categoryInvitation,
+		Secret:   true,
+	},
+}
+
+var sampleExpiry = time.Date(2030, time.January, 2, 15, 4, 0, 0, time.UTC)
+
+// dateTimeFormats format times in templates, per locale
+var dateTimeFormats = map[string]string{
+	"en": "Mon, 02 Jan 2006 15:04 MST",
+	"tr": "02.01.2006 15:04 MST",
+}
+
+// ErrUnknownTemplate is returned when rendering a template that does not exist
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// TemplateDataError lists the fields missing from the data of a template
+type TemplateDataError struct {
+	Template string
+	Missing  []string
+}
+
+func (e *TemplateDataError) Error() string {
+	return fmt.Sprintf("template %s is missing %s", e.Template, strings.Join(e.Missing, ", "))
+}
+
+// RenderedMail is a template rendered for one locale
+type RenderedMail struct {
+	Locale  string `json:"locale"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// localizedTemplate holds the parsed templates of one template and locale
+type localizedTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// parsedTemplates maps template name and locale to the parsed templates
+var parsedTemplates = mustParseTemplates()
+
+// mustParseTemplates parses every template for every locale. The templates are
+// embedded, so an error is a bug and stops the service.
+func mustParseTemplates() map[string]map[string]*localizedTemplate {
+	parsed := map[string]map[string]*localizedTemplate{}
+	for name := range mailTemplates {
+		parsed[name] = map[string]*localizedTemplate{}
+		for _, tag := range supportedLocales {
+			locale := tag.String()
+			funcs := templateFuncs(locale)
+
+			text, err := texttemplate.New("layout.txt").Funcs(texttemplate.FuncMap(funcs)).Option("missingkey=error").ParseFS(templateFS,
+				"templates/layout.txt", "templates/"+locale+"/footer.txt", "templates/"+locale+"/"+name+".txt")
+			if err != nil {
+				log.Fatalf("❌ Invalid mail template %s (%s): %v", name, locale, err)
+			}
+			html, err := htmltemplate.New("layout.html").Funcs(htmltemplate.FuncMap(funcs)).Option("missingkey=error").ParseFS(templateFS,
+				"templates/layout.html", "templates/"+locale+"/footer.html", "templates/"+locale+"/"+name+".html")
+			if err != nil {
+				log.Fatalf("❌ Invalid mail template %s (%s): %v", name, locale, err)
+			}
+			parsed[name][locale] = &localizedTemplate{text: text, html: html}
+		}
+	}
+	return parsed
+}
+
+// templateFuncs are available in all templates
+func templateFuncs(locale string) map[string]interface{} {
+	return map[string]interface{}{
+		"datetime": func(v interface{}) string {
+			t, ok := templateTime(v)
+			if !ok {
+				return fmt.Sprint(v)
+			}
+			return t.Format(dateTimeFormats[locale])
+		},
+		"button": func(link, label string) htmltemplate.HTML {
+			if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				link = "#"
+			}
+			return htmltemplate.HTML(fmt.Sprintf(
+				`<a href="%s" style="display:inline-block;padding:12px 24px;background:#18181b;color:#ffffff;border-radius:6px;text-decoration:none;font-weight:bold;">%s</a>`,
+				htmltemplate.HTMLEscapeString(link), htmltemplate.HTMLEscapeString(label)))
+		},
+	}
+}
+
+// templateTime accepts a time.Time or an RFC 3339 string
+func templateTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+// resolveLocale picks the supported locale for a requested locale, falling
+// back to an Accept-Language header and then to MAIL_SERVICE_DEFAULT_LOCALE
+func resolveLocale(requested, acceptLanguage string) string {
+	for _, preference := range []string{requested, acceptLanguage} {
+		if preference == "" {
+			continue
+		}
+		tags, _, err := language.ParseAcceptLanguage(preference)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+		if _, index, confidence := localeMatcher.Match(tags...); confidence != language.No {
+			return supportedLocales[index].String()
+		}
+	}
+	return valueOr(DefaultLocale, "en")
+}
+
+// isSupportedLocale reports whether there are templates for locale
+func isSupportedLocale(locale string) bool {
+	for _, tag := range supportedLocales {
+		if tag.String() == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTemplateData checks that data has a non-empty value for every field of spec
+func validateTemplateData(name string, spec templateSpec, data map[string]interface{}) error {
+	var missing []string
+	for _, field := range spec.Fields {
+		switch v := data[field].(type) {
+		case nil:
+			missing = append(missing, field)
+		case string:
+			if strings.TrimSpace(v) == "" {
+				missing = append(missing, field)
+			}
+		case time.Time:
+			if v.IsZero() {
+				missing = append(missing, field)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return &TemplateDataError{Template: name, Missing: missing}
+	}
+	return nil
+}
+
+// RenderTemplate renders the template called name in locale ("" for the default)
+// with data. The layout shows the inline images named by their Content-IDs, such
+// as a "logo" in the header.
+func RenderTemplate(name, locale string, data map[string]interface{}, inline []string) (*RenderedMail, error) {
+	spec, ok := mailTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+	if err := validateTemplateData(name, spec, data); err != nil {
+		return nil, err
+	}
+	locale = resolveLocale(locale, "")
+	tmpl := parsedTemplates[name][locale]
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	layout := map[string]interface{}{
+		"Locale":  locale,
+		"Subject": headerValue(subject.String()),
+		"Data":    data,
+		"Inline":  inlineImageURLs(inline),
+	}
+	if err := tmpl.text.Execute(&text, layout); err != nil {
+		return nil, err
+	}
+	if err := tmpl.html.Execute(&html, layout); err != nil {
+		return nil, err
+	}
+	return &RenderedMail{
+		Locale:  locale,
+		Subject: headerValue(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// inlineImageURLs maps Content-IDs to their cid: URLs, which html/template
+// would otherwise replace as unsafe
+func inlineImageURLs(contentIDs []string) map[string]htmltemplate.URL {
+	urls := make(map[string]htmltemplate.URL, len(contentIDs))
+	for _, id := range contentIDs {
+		urls[id] = htmltemplate.URL("cid:" + url.PathEscape(id))
+	}
+	return urls
+}
+
+// templateNames returns the template names in alphabetical order
+func templateNames() []string {
+	names := make([]string, 0, len(mailTemplates))
+	for name := range mailTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templateInfo describes a template in ListTemplatesHandler
+type templateInfo struct {
+	Name     string   `json:"name"`
+	Category string   `json:"category"`
+	Locales  []string `json:"locales"`
+	Fields   []string `json:"fields"`
+}
+
+// ListTemplatesHandler lists the mail templates with their locales and data fields
+func (app *Config) ListTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	locales := make([]string, len(supportedLocales))
+	for i, tag := range supportedLocales {
+		locales[i] = tag.String()
+	}
+	templates := make([]templateInfo, 0, len(mailTemplates))
+	for _, name := range templateNames() {
+		templates = append(templates, templateInfo{Name: name, Category: mailTemplates[name].Category, Locales: locales, Fields: mailTemplates[name].Fields})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"templates": templates})
+}
+
+// PreviewTemplateHandler renders a template with its sample data. ?locale picks
+// the language and ?format=html or ?format=text returns only that body, so the
+// HTML can be opened directly in a browser.
+func (app *Config) PreviewTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	spec, ok := mailTemplates[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Template not found")
+		return
+	}
+
+	locale := resolveLocale(r.URL.Query().Get("locale"), r.Header.Get("Accept-Language"))
+	rendered, err := RenderTemplate(name, locale, spec.Sample, nil)
+	if err != nil {
+		log.Printf("❌ Failed to render mail template %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "Failed to render template")
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(rendered.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(rendered.Text))
+	default:
+		writeJSON(w, http.StatusOK, rendered)
+	}
+}
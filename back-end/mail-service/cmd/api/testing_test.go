@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestApp returns an app backed by a fresh in-memory SQLite database
+func newTestApp(t *testing.T) *Config {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would get its own in-memory database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return &Config{DB: db, ValidateResponses: true}
+}
+
+// jsonRequest returns a request with body encoded as JSON
+func jsonRequest(method, path string, body interface{}) *http.Request {
+	var payload bytes.Buffer
+	json.NewEncoder(&payload).Encode(body)
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// serve sends req through the routes of app
+func serve(app *Config, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	app.routes().ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"shared/identifier"
+	"shared/verification"
+)
+
+// Purposes of verification challenges, a code only verifies for the purpose it was issued for
+const (
+	purposeSignup      = "signup"       // Proves ownership of the mail address of a new account
+	purposeReset       = "reset"        // Proves ownership before a password reset
+	purposeEmailChange = "email-change" // Proves ownership of a new mail address
+)
+
+var challengePurposes = []string{purposeSignup, purposeReset, purposeEmailChange}
+
+const (
+	challengeCodeTTL        = 15 * time.Minute
+	challengeMaxAttempts    = 5 // Wrong guesses before the code is locked, a new code must be sent
+	challengeMaxResends     = 5 // New codes after the first one within challengeWindow
+	challengeResendCooldown = time.Minute
+	challengeWindow         = 24 * time.Hour   // After this a challenge starts over with no resends used
+	verificationTokenTTL    = 30 * time.Minute // To finish a signup after entering the code
+)
+
+// VerificationChallenge asks the owner of a mail address to enter the code mailed
+// to them, for one purpose. Only an HMAC of the current code is stored, the code
+// itself only ever appears in the mail. Sending again replaces the code.
+type VerificationChallenge struct {
+	ID                  uint       `gorm:"primaryKey"`
+	Recipient           string     `gorm:"not null"`
+	RecipientNormalized string     `gorm:"not null;uniqueIndex:idx_verification_challenges_recipient_purpose,priority:1"`
+	Purpose             string     `gorm:"not null;uniqueIndex:idx_verification_challenges_recipient_purpose,priority:2"`
+	CodeHash            string     `gorm:"not null"`
+	ExpiresAt           time.Time  `gorm:"not null"`
+	Attempts            int        `gorm:"not null;default:0"` // Wrong guesses of the current code
+	Resends             int        `gorm:"not null;default:0"`
+	LastSentAt          time.Time  `gorm:"not null"`
+	ConsumedAt          *time.Time // Set once the code was verified, it cannot be used again
+	CreatedAt           time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt           time.Time  `gorm:"autoUpdateTime"`
+}
+
+// Challenge verification errors
+var (
+	ErrCodeInvalid = errors.New("invalid or expired code")
+	ErrCodeLocked  = errors.New("too many wrong codes, request a new one")
+)
+
+// ResendLimitError is returned when a code was sent too recently or too often
+type ResendLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ResendLimitError) Error() string {
+	return fmt.Sprintf("code sent too often, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// codeKey keys the code HMACs, see MAIL_SERVICE_AUTH_CODE_SECRET. Without the
+// key a leaked hash is as good as the code, six digits are quickly guessed.
+var codeKey = loadCodeKey()
+
+// loadCodeKey returns MAIL_SERVICE_AUTH_CODE_SECRET, or a random key when it is
+// unset, in which case codes do not survive a restart and only verify on the
+// instance that issued them
+func loadCodeKey() []byte {
+	if AuthCodeSecret != "" {
+		return []byte(AuthCodeSecret)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("❌ Failed to generate auth code key: %v", err)
+	}
+	return key
+}
+
+// GenerateAuthCode generates a 6-digit authentication code with crypto/rand
+func GenerateAuthCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashCode binds the code to its recipient and purpose, so a hash cannot be
+// reused for another address or purpose
+func hashCode(recipientNormalized, purpose, code string) string {
+	mac := hmac.New(sha256.New, codeKey)
+	mac.Write([]byte(purpose + "\x00" + recipientNormalized + "\x00" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isChallengePurpose reports whether purpose is one of challengePurposes
+func isChallengePurpose(purpose string) bool {
+	for _, p := range challengePurposes {
+		if p == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+// issueChallenge sends a new code to recipient for purpose within tx, starting
+// a challenge or replacing the code of the current one. A resend must wait
+// challengeResendCooldown and there are at most challengeMaxResends per
+// challengeWindow, otherwise a *ResendLimitError is returned.
+func issueChallenge(tx *gorm.DB, recipient, purpose string) (string, *VerificationChallenge, error) {
+	normalized := identifier.Normalize(recipient)
+	now := time.Now()
+
+	// Insert the row if there is none, then lock it, so concurrent sends to
+	// the same address are serialized instead of failing on the unique index
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&VerificationChallenge{
+		Recipient:           recipient,
+		RecipientNormalized: normalized,
+		Purpose:             purpose,
+		ExpiresAt:           now,
+	}).Error
+	if err != nil {
+		return "", nil, err
+	}
+	var challenge VerificationChallenge
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("recipient_normalized = ? AND purpose = ?", normalized, purpose).
+		First(&challenge).Error
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case challenge.LastSentAt.IsZero():
+		// Inserted above
+	case challenge.ConsumedAt != nil || now.Sub(challenge.CreatedAt) >= challengeWindow:
+		challenge.Resends = 0
+		challenge.ConsumedAt = nil
+		challenge.CreatedAt = now
+	case challenge.Resends >= challengeMaxResends:
+		return "", nil, &ResendLimitError{RetryAfter: challenge.CreatedAt.Add(challengeWindow).Sub(now)}
+	case now.Sub(challenge.LastSentAt) < challengeResendCooldown:
+		return "", nil, &ResendLimitError{RetryAfter: challenge.LastSentAt.Add(challengeResendCooldown).Sub(now)}
+	default:
+		challenge.Resends++
+	}
+
+	code, err := GenerateAuthCode()
+	if err != nil {
+		return "", nil, err
+	}
+	challenge.Recipient = recipient
+	challenge.CodeHash = hashCode(normalized, purpose, code)
+	challenge.ExpiresAt = now.Add(challengeCodeTTL)
+	challenge.Attempts = 0
+	challenge.LastSentAt = now
+	if err := tx.Save(&challenge).Error; err != nil {
+		return "", nil, err
+	}
+	return code, &challenge, nil
+}
+
+// verifyChallenge checks code against the current code of the challenge for
+// recipient and purpose and consumes it on a match. Every wrong guess counts
+// towards challengeMaxAttempts; a missing, expired or consumed code is ErrCodeInvalid.
+func (app *Config) verifyChallenge(recipient, purpose, code string) error {
+	normalized := identifier.Normalize(recipient)
+	// Hashed before the lookup so the response time does not tell whether a challenge exists
+	hash := hashCode(normalized, purpose, code)
+
+	var result error
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		var challenge VerificationChallenge
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("recipient_normalized = ? AND purpose = ? AND consumed_at IS NULL", normalized, purpose).
+			First(&challenge).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			result = ErrCodeInvalid
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case challenge.Attempts >= challengeMaxAttempts:
+			result = ErrCodeLocked
+			return nil
+		case time.Now().After(challenge.ExpiresAt):
+			result = ErrCodeInvalid
+			return nil
+		case !hmac.Equal([]byte(hash), []byte(challenge.CodeHash)):
+			result = ErrCodeInvalid
+			return tx.Model(&challenge).UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+		}
+
+		now := time.Now()
+		return tx.Model(&challenge).UpdateColumn("consumed_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+	return result
+}
+
+// writeResendLimit answers a send refused by a *ResendLimitError with 429
+func writeResendLimit(w http.ResponseWriter, err *ResendLimitError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "A code was sent too recently or too often, try again later")
+}
+
+// VerifyAuthCodeRequest is a code entered by the user
+type VerifyAuthCodeRequest struct {
+	MailAddress string `json:"mailAddress"`
+	Code        string `json:"code"`
+	Purpose     string `json:"purpose"` // Defaults to signup
+}
+
+// VerifyAuthCodeResponse proves a verified code to another service, which
+// checks the token with the shared MAIL_SERVICE_VERIFICATION_TOKEN_SECRET
+type VerifyAuthCodeResponse struct {
+	Message           string     `json:"message"`
+	VerificationToken string     `json:"verificationToken,omitempty"` // See shared/verification
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+}
+
+// VerifyAuthCodeHandler checks the code the user received at their mail address
+// and returns a token proving it, e.g. for user-service to accept the signup
+func (app *Config) VerifyAuthCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var req VerifyAuthCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return
+	}
+	purpose := valueOr(req.Purpose, purposeSignup)
+	if !isChallengePurpose(purpose) {
+		writeError(w, http.StatusBadRequest, "Unknown purpose")
+		return
+	}
+
+	err := app.verifyChallenge(req.MailAddress, purpose, req.Code)
+	switch {
+	case err == nil:
+		response := VerifyAuthCodeResponse{Message: "Code verified"}
+		if VerificationTokenSecret != "" {
+			expiresAt := time.Now().Add(verificationTokenTTL)
+			response.VerificationToken = verification.Issue(VerificationTokenSecret, req.MailAddress, purpose, expiresAt)
+			response.ExpiresAt = &expiresAt
+		}
+		writeJSON(w, http.StatusOK, response)
+	case errors.Is(err, ErrCodeInvalid):
+		writeError(w, http.StatusUnauthorized, "Invalid or expired code")
+	case errors.Is(err, ErrCodeLocked):
+		writeError(w, http.StatusTooManyRequests, "Too many wrong codes, request a new one")
+	default:
+		log.Printf("❌ Failed to verify auth code: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrDatabase)
+	}
+}
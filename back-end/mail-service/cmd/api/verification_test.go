@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// issue sends a code to recipient for purpose
+func issue(t *testing.T, app *Config, recipient, purpose string) (string, error) {
+	t.Helper()
+	var code string
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		code, _, err = issueChallenge(tx, recipient, purpose)
+		return err
+	})
+	return code, err
+}
+
+// mustIssue is issue for sends that must be allowed
+func mustIssue(t *testing.T, app *Config, recipient, purpose string) string {
+	t.Helper()
+	code, err := issue(t, app, recipient, purpose)
+	if err != nil {
+		t.Fatalf("expected a code to be sent, got %v", err)
+	}
+	return code
+}
+
+// backdate moves a time column of the challenge of recipient and purpose into the past
+func backdate(t *testing.T, app *Config, recipient, purpose, column string, by time.Duration) {
+	t.Helper()
+	var challenge VerificationChallenge
+	if err := app.DB.Where("recipient = ? AND purpose = ?", recipient, purpose).First(&challenge).Error; err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]time.Time{"last_sent_at": challenge.LastSentAt, "created_at": challenge.CreatedAt, "expires_at": challenge.ExpiresAt}
+	if err := app.DB.Model(&challenge).UpdateColumn(column, values[column].Add(-by)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wrongCode returns a code that differs from code
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "000001"
+	}
+	return "000000"
+}
+
+// After challengeMaxAttempts wrong guesses even the right code is refused until a new one is sent
+func TestChallengeLockout(t *testing.T) {
+	app := newTestApp(t)
+	code := mustIssue(t, app, "a@example.com", purposeSignup)
+
+	for i := 0; i < challengeMaxAttempts; i++ {
+		if err := app.verifyChallenge("a@example.com", purposeSignup, wrongCode(code)); !errors.Is(err, ErrCodeInvalid) {
+			t.Fatalf("guess %d: expected ErrCodeInvalid, got %v", i+1, err)
+		}
+	}
+	if err := app.verifyChallenge("a@example.com", purposeSignup, code); !errors.Is(err, ErrCodeLocked) {
+		t.Fatalf("expected ErrCodeLocked, got %v", err)
+	}
+
+	// A new code starts with no wrong guesses
+	backdate(t, app, "a@example.com", purposeSignup, "last_sent_at", challengeResendCooldown)
+	code = mustIssue(t, app, "a@example.com", purposeSignup)
+	if err := app.verifyChallenge("a@example.com", purposeSignup, code); err != nil {
+		t.Fatalf("expected the new code to verify, got %v", err)
+	}
+}
+
+// A verified code cannot be used again, and a new challenge may start right away
+func TestChallengeConsumed(t *testing.T) {
+	app := newTestApp(t)
+	code := mustIssue(t, app, "a@example.com", purposeSignup)
+
+	// Addresses are matched normalized
+	if err := app.verifyChallenge("A@Example.com", purposeSignup, code); err != nil {
+		t.Fatalf("expected the code to verify, got %v", err)
+	}
+	if err := app.verifyChallenge("a@example.com", purposeSignup, code); !errors.Is(err, ErrCodeInvalid) {
+		t.Fatalf("expected a consumed code to be refused, got %v", err)
+	}
+
+	// No cooldown after a consumed code, and the resends start over
+	code = mustIssue(t, app, "a@example.com", purposeSignup)
+	var challenge VerificationChallenge
+	if err := app.DB.Where("recipient = ? AND purpose = ?", "a@example.com", purposeSignup).First(&challenge).Error; err != nil {
+		t.Fatal(err)
+	}
+	if challenge.Resends != 0 || challenge.ConsumedAt != nil {
+		t.Errorf("expected a fresh challenge, got %+v", challenge)
+	}
+	if err := app.verifyChallenge("a@example.com", purposeSignup, code); err != nil {
+		t.Errorf("expected the new code to verify, got %v", err)
+	}
+}
+
+// An expired code is refused without counting as a wrong guess
+func TestChallengeExpired(t *testing.T) {
+	app := newTestApp(t)
+	code := mustIssue(t, app, "a@example.com", purposeSignup)
+	backdate(t, app, "a@example.com", purposeSignup, "expires_at", challengeCodeTTL+time.Second)
+
+	if err := app.verifyChallenge("a@example.com", purposeSignup, code); !errors.Is(err, ErrCodeInvalid) {
+		t.Fatalf("expected an expired code to be refused, got %v", err)
+	}
+	var challenge VerificationChallenge
+	if err := app.DB.Where("recipient = ?", "a@example.com").First(&challenge).Error; err != nil {
+		t.Fatal(err)
+	}
+	if challenge.Attempts != 0 || challenge.ConsumedAt != nil {
+		t.Errorf("expected the challenge to be untouched, got %+v", challenge)
+	}
+}
+
+// A code only verifies for the address and purpose it was sent for
+func TestChallengeBinding(t *testing.T) {
+	app := newTestApp(t)
+	code := mustIssue(t, app, "a@example.com", purposeSignup)
+
+	if err := app.verifyChallenge("a@example.com", purposeReset, code); !errors.Is(err, ErrCodeInvalid) {
+		t.Errorf("expected another purpose to be refused, got %v", err)
+	}
+	if err := app.verifyChallenge("b@example.com", purposeSignup, code); !errors.Is(err, ErrCodeInvalid) {
+		t.Errorf("expected another address to be refused, got %v", err)
+	}
+
+	// Even with the hash copied over, the code of one challenge does not verify another
+	var signup VerificationChallenge
+	if err := app.DB.Where("purpose = ?", purposeSignup).First(&signup).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range []struct{ recipient, purpose string }{{"a@example.com", purposeEmailChange}, {"b@example.com", purposeSignup}} {
+		mustIssue(t, app, other.recipient, other.purpose)
+		err := app.DB.Model(&VerificationChallenge{}).
+			Where("recipient = ? AND purpose = ?", other.recipient, other.purpose).
+			UpdateColumn("code_hash", signup.CodeHash).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := app.verifyChallenge(other.recipient, other.purpose, code); !errors.Is(err, ErrCodeInvalid) {
+			t.Errorf("%s %s: expected the copied hash to be refused, got %v", other.recipient, other.purpose, err)
+		}
+	}
+
+	if err := app.verifyChallenge("a@example.com", purposeSignup, code); err != nil {
+		t.Errorf("expected the code to verify for its own challenge, got %v", err)
+	}
+}
+
+// A code is only sent again after challengeResendCooldown
+func TestChallengeResendCooldown(t *testing.T) {
+	app := newTestApp(t)
+	first := mustIssue(t, app, "a@example.com", purposeSignup)
+
+	_, err := issue(t, app, "a@example.com", purposeSignup)
+	var limit *ResendLimitError
+	if !errors.As(err, &limit) {
+		t.Fatalf("expected a ResendLimitError, got %v", err)
+	}
+	if limit.RetryAfter <= challengeResendCooldown-5*time.Second || limit.RetryAfter > challengeResendCooldown {
+		t.Errorf("expected a retry after about %s, got %s", challengeResendCooldown, limit.RetryAfter)
+	}
+
+	backdate(t, app, "a@example.com", purposeSignup, "last_sent_at", challengeResendCooldown)
+	second := mustIssue(t, app, "a@example.com", purposeSignup)
+	// The new code replaces the first one
+	if first != second {
+		if err := app.verifyChallenge("a@example.com", purposeSignup, first); !errors.Is(err, ErrCodeInvalid) {
+			t.Errorf("expected the replaced code to be refused, got %v", err)
+		}
+	}
+	if err := app.verifyChallenge("a@example.com", purposeSignup, second); err != nil {
+		t.Errorf("expected the new code to verify, got %v", err)
+	}
+}
+
+// At most challengeMaxResends codes follow the first one within challengeWindow
+func TestChallengeMaxResends(t *testing.T) {
+	app := newTestApp(t)
+	mustIssue(t, app, "a@example.com", purposeSignup)
+	for i := 0; i < challengeMaxResends; i++ {
+		backdate(t, app, "a@example.com", purposeSignup, "last_sent_at", challengeResendCooldown)
+		mustIssue(t, app, "a@example.com", purposeSignup)
+	}
+
+	backdate(t, app, "a@example.com", purposeSignup, "last_sent_at", challengeResendCooldown)
+	_, err := issue(t, app, "a@example.com", purposeSignup)
+	var limit *ResendLimitError
+	if !errors.As(err, &limit) || limit.RetryAfter <= challengeWindow-time.Minute {
+		t.Fatalf("expected a ResendLimitError until the window ends, got %v", err)
+	}
+
+	// Other purposes have their own challenge
+	mustIssue(t, app, "a@example.com", purposeReset)
+
+	// A new window starts over
+	backdate(t, app, "a@example.com", purposeSignup, "created_at", challengeWindow)
+	mustIssue(t, app, "a@example.com", purposeSignup)
+	var challenge VerificationChallenge
+	if err := app.DB.Where("recipient = ? AND purpose = ?", "a@example.com", purposeSignup).First(&challenge).Error; err != nil {
+		t.Fatal(err)
+	}
+	if challenge.Resends != 0 {
+		t.Errorf("expected no resends in the new window, got %d", challenge.Resends)
+	}
+}
+
+// A refused resend is answered with 429 and the seconds until the next code may be sent
+func TestSendAuthCodeResendLimit(t *testing.T) {
+	app := newTestApp(t)
+	send := func() *httptest.ResponseRecorder {
+		return serve(app, jsonRequest(http.MethodPost, "/send-auth-code-mail", map[string]string{"mailAddress": "a@example.com"}))
+	}
+
+	rec := send()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), fmt.Sprintf(`"resendsRemaining":%d`, challengeMaxResends)) {
+		t.Errorf("expected %d resends remaining, got %s", challengeMaxResends, rec.Body.String())
+	}
+
+	rec = send()
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected 429 with Retry-After 60, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	backdate(t, app, "a@example.com", purposeSignup, "last_sent_at", challengeResendCooldown)
+	rec = send()
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), fmt.Sprintf(`"resendsRemaining":%d`, challengeMaxResends-1)) {
+		t.Fatalf("expected a resend, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,39 @@
+// Package clientauth checks the client credentials internal services
+// authenticate with, configured as comma-separated "clientID:secret" pairs.
+package clientauth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// Credentials maps client IDs to their secrets
+type Credentials map[string]string
+
+// Parse parses comma-separated "clientID:secret" pairs
+func Parse(s string) (Credentials, error) {
+	clients := Credentials{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid client credentials %q, expected clientID:secret", pair)
+		}
+		clients[id] = secret
+	}
+	return clients, nil
+}
+
+// Verify reports whether secret is the secret of the client id, in constant
+// time for a known client
+func (c Credentials) Verify(id, secret string) bool {
+	expected, known := c[id]
+	if !known || id == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1
+}
@@ -0,0 +1,33 @@
+package clientauth
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	clients, err := Parse(" user-service:s3cret , ,billing:a:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || clients["user-service"] != "s3cret" || clients["billing"] != "a:b" {
+		t.Fatalf("unexpected %v", clients)
+	}
+	if clients, err := Parse(""); err != nil || len(clients) != 0 {
+		t.Fatalf("expected no clients, got %v, %v", clients, err)
+	}
+	for _, s := range []string{"user-service", ":secret", "user-service:"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	clients := Credentials{"user-service": "s3cret"}
+	if !clients.Verify("user-service", "s3cret") {
+		t.Fatal("expected the client to verify")
+	}
+	for _, c := range [][2]string{{"user-service", "wrong"}, {"user-service", ""}, {"billing", "s3cret"}, {"", ""}} {
+		if clients.Verify(c[0], c[1]) {
+			t.Errorf("%q: expected the credentials to be rejected", c)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+module shared
+
+go 1.23.1
+
+require (
+	github.com/redis/go-redis/v9 v9.7.3
+	golang.org/x/crypto v0.33.0
+	golang.org/x/text v0.22.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
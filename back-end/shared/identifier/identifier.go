@@ -0,0 +1,26 @@
+// Package identifier normalizes usernames and mail addresses, so every service
+// looks them up and compares them the same way.
+package identifier
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize folds a username or mail address for case-insensitive lookups.
+// Turkish dotted and dotless I (İ, I, ı, i) all fold to "i", so neither Turkish nor
+// English lowercasing can produce two accounts that only differ in those letters.
+func Normalize(s string) string {
+	s = norm.NFKC.String(strings.TrimSpace(s))
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case 'İ', 'I', 'ı':
+			return 'i'
+		}
+		return unicode.ToLower(r)
+	}, s)
+	// Non-Turkish lowercasing of İ yields "i" followed by a combining dot above
+	return strings.ReplaceAll(s, "i\u0307", "i")
+}
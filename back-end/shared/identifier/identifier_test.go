@@ -0,0 +1,20 @@
+package identifier
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"  Murat.Tunc@Example.COM ": "murat.tunc@example.com",
+		"İSTANBUL":                  "istanbul",
+		"ıstanbul":                  "istanbul",
+		"Istanbul":                  "istanbul",
+		"i\u0307stanbul":            "istanbul", // İ lowercased without Turkish rules
+		"ｍｕｒａｔ":                     "murat",    // Fullwidth letters fold with NFKC
+		"ÇAĞRI":                     "çağri",
+	}
+	for in, want := range cases {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
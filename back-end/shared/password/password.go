@@ -0,0 +1,210 @@
+// Package password hashes and verifies user passwords for all services.
+//
+// New hashes use argon2id and are encoded in the PHC string format
+// ($argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>). bcrypt hashes written by
+// earlier versions are still recognised; Verify reports that they need a
+// rehash, so callers can upgrade them on the next successful login.
+//
+// An optional server-side pepper is mixed into argon2id hashes with
+// HMAC-SHA256 before hashing. Peppered hashes carry a keyid parameter derived
+// from the pepper. A hash made with a pepper the Hasher does not know cannot be
+// verified at all, so rotating the pepper locks those users out unless the old
+// pepper is passed as a previous pepper; its hashes are then marked for rehash.
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownFormat is returned for hashes that are neither argon2id nor bcrypt
+var ErrUnknownFormat = errors.New("password: unknown hash format")
+
+// ErrPepperMismatch is returned for hashes made with a pepper that is neither the current nor a previous one
+var ErrPepperMismatch = errors.New("password: hash was made with a different pepper")
+
+// Params are the argon2id cost parameters
+type Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follow the OWASP recommendation for argon2id (64 MiB, 3 passes)
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// ParseParams builds Params from configuration strings; empty values keep the default
+func ParseParams(memory, iterations, parallelism string) (Params, error) {
+	params := DefaultParams
+	if memory != "" {
+		v, err := strconv.ParseUint(memory, 10, 32)
+		if err != nil || v < 8*1024 {
+			return params, fmt.Errorf("argon2 memory must be a number of KiB >= 8192, got %q", memory)
+		}
+		params.Memory = uint32(v)
+	}
+	if iterations != "" {
+		v, err := strconv.ParseUint(iterations, 10, 32)
+		if err != nil || v < 1 {
+			return params, fmt.Errorf("argon2 iterations must be a positive number, got %q", iterations)
+		}
+		params.Iterations = uint32(v)
+	}
+	if parallelism != "" {
+		v, err := strconv.ParseUint(parallelism, 10, 8)
+		if err != nil || v < 1 {
+			return params, fmt.Errorf("argon2 parallelism must be between 1 and 255, got %q", parallelism)
+		}
+		params.Parallelism = uint8(v)
+	}
+	return params, nil
+}
+
+// Hasher hashes passwords with argon2id and verifies argon2id and bcrypt hashes
+type Hasher struct {
+	params  Params
+	keyID   string            // keyid of the current pepper, "" without one
+	peppers map[string][]byte // Current and previous peppers by keyid
+}
+
+// NewHasher creates a Hasher. pepper may be empty; previousPeppers still verify
+// the hashes made with them until those are rehashed.
+func NewHasher(params Params, pepper string, previousPeppers ...string) *Hasher {
+	h := &Hasher{params: params, peppers: map[string][]byte{}}
+	for _, previous := range previousPeppers {
+		if previous != "" {
+			h.peppers[pepperKeyID(previous)] = []byte(previous)
+		}
+	}
+	if pepper != "" {
+		h.keyID = pepperKeyID(pepper)
+		h.peppers[h.keyID] = []byte(pepper)
+	}
+	return h
+}
+
+// pepperKeyID identifies a pepper in hashes without revealing it
+func pepperKeyID(pepper string) string {
+	sum := sha256.Sum256([]byte(pepper))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Hash returns the argon2id PHC string of password
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(h.peppered(password, h.keyID), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	settings := fmt.Sprintf("m=%d,t=%d,p=%d", h.params.Memory, h.params.Iterations, h.params.Parallelism)
+	if h.keyID != "" {
+		settings += ",keyid=" + h.keyID
+	}
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, settings,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches encoded, and whether encoded should be
+// replaced by a fresh Hash because it uses another algorithm, other parameters or
+// a previous pepper. An error means encoded could not be checked at all.
+func (h *Hasher) Verify(password, encoded string) (ok, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return h.verifyArgon2id(password, encoded)
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	}
+	return false, false, ErrUnknownFormat
+}
+
+func (h *Hasher) verifyArgon2id(password, encoded string) (bool, bool, error) {
+	// "", "argon2id", "v=19", settings, salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false, false, ErrUnknownFormat
+	}
+
+	var params Params
+	var keyID string
+	for _, setting := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(setting, "=")
+		switch name {
+		case "m", "t", "p":
+			v, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return false, false, ErrUnknownFormat
+			}
+			switch name {
+			case "m":
+				params.Memory = uint32(v)
+			case "t":
+				params.Iterations = uint32(v)
+			case "p":
+				params.Parallelism = uint8(v)
+			}
+		case "keyid":
+			keyID = value
+		}
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return false, false, ErrUnknownFormat
+	}
+	if _, known := h.peppers[keyID]; keyID != "" && !known {
+		return false, false, ErrPepperMismatch
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrUnknownFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrUnknownFormat
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	computed := argon2.IDKey(h.peppered(password, keyID), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false, nil
+	}
+	return true, params != h.params || keyID != h.keyID, nil
+}
+
+// peppered returns the argon2id input: the password itself, or its HMAC with the
+// pepper when the hash is peppered
+func (h *Hasher) peppered(password, keyID string) []byte {
+	if keyID == "" {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, h.peppers[keyID])
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,125 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters keep the tests fast, the code paths are the same
+var testParams = Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func mustHash(t *testing.T, h *Hasher, password string) string {
+	t.Helper()
+	encoded, err := h.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func checkVerify(t *testing.T, h *Hasher, password, encoded string, wantOK, wantRehash bool) {
+	t.Helper()
+	ok, needsRehash, err := h.Verify(password, encoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok != wantOK || needsRehash != wantRehash {
+		t.Fatalf("expected ok=%v needsRehash=%v, got ok=%v needsRehash=%v", wantOK, wantRehash, ok, needsRehash)
+	}
+}
+
+// Fresh hashes verify without a rehash and reject other passwords
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := NewHasher(testParams, "")
+	encoded := mustHash(t, h, "correct horse")
+
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=8192,t=1,p=1$") {
+		t.Fatalf("unexpected encoding %s", encoded)
+	}
+	checkVerify(t, h, "correct horse", encoded, true, false)
+	checkVerify(t, h, "wrong horse", encoded, false, false)
+}
+
+// Hashes made with other parameters still verify and are marked for rehash
+func TestArgon2idParamsChange(t *testing.T) {
+	encoded := mustHash(t, NewHasher(testParams, ""), "correct horse")
+
+	stronger := testParams
+	stronger.Iterations = 2
+	checkVerify(t, NewHasher(stronger, ""), "correct horse", encoded, true, true)
+}
+
+// bcrypt hashes of earlier versions verify and are marked for rehash
+func TestBcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHasher(testParams, "pepper")
+
+	checkVerify(t, h, "correct horse", string(legacy), true, true)
+	checkVerify(t, h, "wrong horse", string(legacy), false, false)
+}
+
+// A peppered hash only verifies with its pepper, the password alone is not enough
+func TestPepper(t *testing.T) {
+	h := NewHasher(testParams, "pepper")
+	encoded := mustHash(t, h, "correct horse")
+
+	if !strings.Contains(encoded, ",keyid="+pepperKeyID("pepper")+"$") {
+		t.Fatalf("expected the keyid of the pepper in %s", encoded)
+	}
+	checkVerify(t, h, "correct horse", encoded, true, false)
+	checkVerify(t, h, "wrong horse", encoded, false, false)
+
+	if _, _, err := NewHasher(testParams, "").Verify("correct horse", encoded); !errors.Is(err, ErrPepperMismatch) {
+		t.Fatalf("expected ErrPepperMismatch without the pepper, got %v", err)
+	}
+}
+
+// After a rotation hashes of the previous pepper verify and are marked for
+// rehash, hashes of an unknown pepper do not verify
+func TestPepperRotation(t *testing.T) {
+	encoded := mustHash(t, NewHasher(testParams, "old"), "correct horse")
+
+	rotated := NewHasher(testParams, "new", "old")
+	checkVerify(t, rotated, "correct horse", encoded, true, true)
+	checkVerify(t, rotated, "wrong horse", encoded, false, false)
+
+	if _, _, err := NewHasher(testParams, "new").Verify("correct horse", encoded); !errors.Is(err, ErrPepperMismatch) {
+		t.Fatalf("expected ErrPepperMismatch for an unknown pepper, got %v", err)
+	}
+
+	// Unpeppered hashes are upgraded once a pepper is configured
+	plain := mustHash(t, NewHasher(testParams, ""), "correct horse")
+	checkVerify(t, rotated, "correct horse", plain, true, true)
+}
+
+// Malformed and unknown hashes are errors, not mismatches
+func TestUnknownFormat(t *testing.T) {
+	h := NewHasher(testParams, "")
+	for _, encoded := range []string{"", "plaintext", "$argon2id$v=19$m=8192$abc", "$argon2id$v=18$m=8192,t=1,p=1$c2FsdA$a2V5"} {
+		if _, _, err := h.Verify("correct horse", encoded); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("%q: expected ErrUnknownFormat, got %v", encoded, err)
+		}
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	params, err := ParseParams("", "", "")
+	if err != nil || params != DefaultParams {
+		t.Fatalf("expected the defaults, got %+v, %v", params, err)
+	}
+	params, err = ParseParams("16384", "4", "1")
+	if err != nil || params.Memory != 16384 || params.Iterations != 4 || params.Parallelism != 1 {
+		t.Fatalf("unexpected %+v, %v", params, err)
+	}
+	for _, values := range [][3]string{{"1024", "", ""}, {"", "0", ""}, {"", "", "256"}, {"lots", "", ""}} {
+		if _, err := ParseParams(values[0], values[1], values[2]); err == nil {
+			t.Errorf("%v: expected an error", values)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package ratelimit limits requests with token buckets kept in memory or in Redis.
+//
+// A Limit of "5/1m" is a bucket holding 5 tokens that refills at 5 tokens per
+// minute: bursts of up to 5 requests pass, after that one request every 12s.
+// Responses carry RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers (draft-ietf-httpapi-ratelimit-headers); rejected
+// requests get a 429 with Retry-After.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests requests per Period, in bursts of up to Requests
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// rate is the refill rate in tokens per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// String formats the limit the way ParseLimit reads it
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// ParseLimit parses "<requests>/<period>", e.g. "10/1m" or "100/1h"
+func ParseLimit(s string) (Limit, error) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected <requests>/<period> like 10/1m", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, requests must be a positive number", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, period must be a duration like 30s, 1m or 1h", s)
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// ParseLimits applies comma-separated "<name>=<limit>" overrides to defaults.
+// "<name>=off" disables a limit.
+func ParseLimits(spec string, defaults map[string]Limit) (map[string]Limit, error) {
+	limits := make(map[string]Limit, len(defaults))
+	for name, limit := range defaults {
+		limits[name] = limit
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid rate limit entry %q, expected <name>=<requests>/<period> or <name>=off", entry)
+		}
+		if _, known := defaults[name]; !known {
+			return nil, fmt.Errorf("unknown rate limit %q", name)
+		}
+		if strings.TrimSpace(value) == "off" {
+			delete(limits, name)
+			continue
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // Until the bucket is full again
+	RetryAfter time.Duration // Until the next token, when not allowed
+}
+
+// newResult derives a Result from the tokens left in a bucket
+func newResult(allowed bool, tokens float64, limit Limit) Result {
+	result := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Requests) - tokens) / limit.rate() * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / limit.rate() * float64(time.Second))
+	}
+	return result
+}
+
+// Store keeps the buckets
+type Store interface {
+	// Take removes a token from the bucket under key, if there is one
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// OpenStore returns a MemoryStore for "" or "memory" and a RedisStore for a
+// redis:// or rediss:// URL
+func OpenStore(url string) (Store, error) {
+	if url == "" || url == "memory" {
+		return NewMemoryStore(), nil
+	}
+	if strings.HasPrefix(url, "redis://") || strings.HasPrefix(url, "rediss://") {
+		return NewRedisStoreFromURL(url)
+	}
+	return nil, fmt.Errorf("invalid rate limit store %q, expected memory or a redis:// URL", url)
+}
+
+// KeyFunc identifies who a request is counted against. An empty key skips the limit.
+type KeyFunc func(r *http.Request) string
+
+// ByIP counts requests per client IP. Behind a reverse proxy install
+// middleware.RealIP first so RemoteAddr is the client's address.
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ByHeader counts requests per value of a request header, e.g. the
+// authenticated user set by an auth middleware
+func ByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// Limiter applies named limits
+type Limiter struct {
+	store  Store
+	limits map[string]Limit
+}
+
+// New creates a Limiter. Names missing from limits are not limited.
+func New(store Store, limits map[string]Limit) *Limiter {
+	return &Limiter{store: store, limits: limits}
+}
+
+// Handler limits requests under the limit called name, counted per key. Store
+// errors are logged and let the request through.
+func (l *Limiter) Handler(name string, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limit, ok := l.limits[name]
+		if !ok {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := l.store.Take(r.Context(), name+":"+k, limit)
+			if err != nil {
+				log.Printf("❌ Rate limit %s unavailable: %v", name, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			setHeaders(w.Header(), limit, result)
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error":"Too many requests"}` + "\n"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setHeaders sets the RateLimit headers. When several limits apply the one
+// with the fewest remaining requests is reported.
+func setHeaders(h http.Header, limit Limit, result Result) {
+	if current := h.Get("RateLimit-Remaining"); current != "" {
+		if remaining, err := strconv.Atoi(current); err == nil && remaining <= result.Remaining {
+			return
+		}
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Period)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
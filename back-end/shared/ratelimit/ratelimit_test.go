@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit(" 10/1m ")
+	if err != nil || limit != (Limit{Requests: 10, Period: time.Minute}) {
+		t.Fatalf("unexpected %+v, %v", limit, err)
+	}
+	for _, s := range []string{"", "10", "0/1m", "-1/1m", "ten/1m", "10/", "10/0s", "10/soon"} {
+		if _, err := ParseLimit(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	defaults := map[string]Limit{"ip": {Requests: 300, Period: time.Minute}, "login": {Requests: 5, Period: time.Minute}}
+
+	limits, err := ParseLimits("login=10/1h, ip=off", defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := limits["ip"]; ok {
+		t.Errorf("expected ip to be disabled")
+	}
+	if limits["login"] != (Limit{Requests: 10, Period: time.Hour}) {
+		t.Errorf("unexpected login limit %v", limits["login"])
+	}
+	if defaults["ip"].Requests != 300 {
+		t.Errorf("defaults were modified")
+	}
+
+	for _, spec := range []string{"unknown=1/1m", "login", "=1/1m", "login=fast"} {
+		if _, err := ParseLimits(spec, defaults); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+// A bucket lets a burst of Requests through, then refills at Requests per Period
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take(ctx, "k", limit)
+		if err != nil || !result.Allowed || result.Remaining != i {
+			t.Fatalf("expected an allowed request with %d remaining, got %+v, %v", i, result, err)
+		}
+	}
+	result, _ := store.Take(ctx, "k", limit)
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("expected the bucket to be empty, got %+v", result)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Errorf("expected a retry within the 1s a token takes, got %v", result.RetryAfter)
+	}
+	if result.Reset <= 2*time.Second || result.Reset > 3*time.Second {
+		t.Errorf("expected the bucket to be full within 3s, got %v", result.Reset)
+	}
+
+	// Other keys have their own bucket
+	if result, _ := store.Take(ctx, "other", limit); !result.Allowed {
+		t.Fatalf("expected another key to be allowed, got %+v", result)
+	}
+
+	// Two seconds later two tokens are back
+	store.buckets["k"].updated = store.buckets["k"].updated.Add(-2 * time.Second)
+	for i := 0; i < 2; i++ {
+		if result, _ := store.Take(ctx, "k", limit); !result.Allowed {
+			t.Fatalf("expected refilled token %d, got %+v", i+1, result)
+		}
+	}
+	if result, _ := store.Take(ctx, "k", limit); result.Allowed {
+		t.Fatalf("expected the refill to stop at two tokens, got %+v", result)
+	}
+
+	// A bucket never holds more than Requests tokens
+	store.buckets["k"].updated = store.buckets["k"].updated.Add(-time.Hour)
+	if result, _ := store.Take(ctx, "k", limit); result.Remaining != 2 {
+		t.Fatalf("expected a full bucket, got %+v", result)
+	}
+}
+
+// Full buckets are dropped by the sweep, others are kept
+func TestMemoryStoreSweep(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limit := Limit{Requests: 2, Period: time.Hour}
+
+	store.Take(ctx, "full", limit)
+	store.Take(ctx, "busy", limit)
+	store.buckets["full"].updated = store.buckets["full"].updated.Add(-time.Hour)
+	store.sweep(time.Now())
+
+	if _, ok := store.buckets["full"]; ok {
+		t.Errorf("expected the full bucket to be dropped")
+	}
+	if _, ok := store.buckets["busy"]; !ok {
+		t.Errorf("expected the busy bucket to be kept")
+	}
+}
+
+func limitedHandler(limiter *Limiter, name string, key KeyFunc) http.Handler {
+	return limiter.Handler(name, key)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+// Requests are counted per header value, rejected ones get a 429 with Retry-After
+func TestHandlerByHeader(t *testing.T) {
+	limiter := New(NewMemoryStore(), map[string]Limit{"user": {Requests: 2, Period: time.Minute}})
+	handler := limitedHandler(limiter, "user", ByHeader("X-User-ID"))
+
+	serve := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if user != "" {
+			req.Header.Set("X-User-ID", user)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := serve("1"); rec.Code != http.StatusNoContent {
+			t.Fatalf("request %d: expected 204, got %d", i+1, rec.Code)
+		}
+	}
+	rec := serve("1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("expected Retry-After 30, got %q", rec.Header().Get("Retry-After"))
+	}
+	for header, want := range map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "0", "RateLimit-Policy": "2;w=60"} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("expected %s %q, got %q", header, want, got)
+		}
+	}
+
+	if rec := serve("2"); rec.Code != http.StatusNoContent {
+		t.Errorf("expected another user to pass, got %d", rec.Code)
+	}
+	// Without the header there is no key and no limit
+	for i := 0; i < 3; i++ {
+		if rec := serve(""); rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("expected unlimited requests without a key, got %d", rec.Code)
+		}
+	}
+}
+
+// Names without a limit pass through untouched
+func TestHandlerUnknownName(t *testing.T) {
+	limiter := New(NewMemoryStore(), map[string]Limit{})
+	rec := httptest.NewRecorder()
+	limitedHandler(limiter, "user", ByIP).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("expected an unlimited request, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+// When several limits apply the headers report the one with the fewest remaining requests
+func TestSetHeadersFewestRemaining(t *testing.T) {
+	h := http.Header{}
+	wide := Limit{Requests: 100, Period: time.Minute}
+	narrow := Limit{Requests: 5, Period: time.Minute}
+
+	setHeaders(h, narrow, Result{Allowed: true, Remaining: 4})
+	setHeaders(h, wide, Result{Allowed: true, Remaining: 99})
+	if h.Get("RateLimit-Limit") != "5" || h.Get("RateLimit-Remaining") != "4" {
+		t.Fatalf("expected the narrow limit to be kept, got %v", h)
+	}
+
+	setHeaders(h, wide, Result{Allowed: true, Remaining: 1})
+	if h.Get("RateLimit-Limit") != "100" || h.Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("expected the wide limit to replace it, got %v", h)
+	}
+}
+
+func TestByIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	if key := ByIP(req); key != "203.0.113.7" {
+		t.Errorf("expected the host, got %q", key)
+	}
+	req.RemoteAddr = "203.0.113.7"
+	if key := ByIP(req); key != "203.0.113.7" {
+		t.Errorf("expected the address without a port, got %q", key)
+	}
+}
@@ -0,0 +1,53 @@
+// Package verification issues and checks the tokens that prove a mail address
+// was verified for a purpose, so mail-service can verify a code and
+// user-service can act on it without trusting the client in between.
+//
+// A token is "<expiry unix time>.<hex HMAC-SHA256 of "<purpose>:<address>:<expiry>">",
+// keyed with a secret both services share. The address is normalized with
+// identifier.Normalize, so the token does not depend on how it was typed.
+package verification
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/identifier"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, expired or made
+// for another address, purpose or secret
+var ErrInvalidToken = errors.New("verification: invalid or expired token")
+
+// Issue returns a token proving mailAddress was verified for purpose, valid until expiresAt
+func Issue(secret, mailAddress, purpose string, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + sign(secret, mailAddress, purpose, expiry)
+}
+
+// Check returns nil if token proves mailAddress was verified for purpose and
+// has not expired at now
+func Check(secret, token, mailAddress, purpose string, now time.Time) error {
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return ErrInvalidToken
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || !now.Before(time.Unix(unix, 0)) {
+		return ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(secret, mailAddress, purpose, expiry))) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func sign(secret, mailAddress, purpose, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose + ":" + identifier.Normalize(mailAddress) + ":" + expiry))
+	return hex.EncodeToString(mac.Sum(nil))
+}
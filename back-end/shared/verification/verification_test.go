@@ -0,0 +1,44 @@
+package verification
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIssueCheck(t *testing.T) {
+	now := time.Unix(1760955298, 0)
+	token := Issue("secret", "Ayşe.Yılmaz@Example.com", "signup", now.Add(30*time.Minute))
+
+	if err := Check("secret", token, " ayşe.yilmaz@example.com", "signup", now); err != nil {
+		t.Fatalf("expected the token to prove the normalized address, got %v", err)
+	}
+
+	rejected := map[string]struct {
+		secret, token, mailAddress, purpose string
+		now                                 time.Time
+	}{
+		"other secret":  {"other", token, "ayşe.yılmaz@example.com", "signup", now},
+		"other address": {"secret", token, "mehmet@example.com", "signup", now},
+		"other purpose": {"secret", token, "ayşe.yılmaz@example.com", "reset", now},
+		"expired":       {"secret", token, "ayşe.yılmaz@example.com", "signup", now.Add(30 * time.Minute)},
+		"no secret":     {"", Issue("", "a@example.com", "signup", now.Add(time.Minute)), "a@example.com", "signup", now},
+		"extended":      {"secret", "9999999999" + token[len("1760957098"):], "ayşe.yılmaz@example.com", "signup", now},
+		"malformed":     {"secret", "1760957098", "ayşe.yılmaz@example.com", "signup", now},
+		"empty":         {"secret", "", "ayşe.yılmaz@example.com", "signup", now},
+	}
+	for name, c := range rejected {
+		if err := Check(c.secret, c.token, c.mailAddress, c.purpose, c.now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+// The format is simple enough for scripts to compute, this one was made with
+// printf 'signup:a@example.com:1760957098' | openssl dgst -sha256 -hmac secret
+func TestTokenFormat(t *testing.T) {
+	token := Issue("secret", "A@Example.com", "signup", time.Unix(1760957098, 0))
+	if want := "1760957098.7016227fee57939af41cfc41a979ab0a71f957cda34270dc7c1df5745d8d8fc3"; token != want {
+		t.Fatalf("expected %s, got %s", want, token)
+	}
+}
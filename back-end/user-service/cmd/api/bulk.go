@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"shared/identifier"
+)
+
+const (
+	maxImportRows      = 1000
+	maxImportBodyBytes = 5 << 20
+	exportBatchSize    = 500
+)
+
+// importRow is one user of a bulk import. Password may be omitted when
+// invitations are sent; the user then chooses one through the invitation link.
+type importRow struct {
+	Username    string `json:"username" validate:"required,username"`
+	MailAddress string `json:"mailAddress" validate:"required,mailaddress"`
+	Role        string `json:"role" validate:"required,role"`
+	Password    string `json:"password" validate:"omitempty,password"`
+}
+
+// ImportRowResult reports what happened (or, in a dry run, would happen) to one row
+type ImportRowResult struct {
+	Row            int          `json:"row"` // 1-based, not counting the CSV header
+	Username       string       `json:"username"`
+	MailAddress    string       `json:"mailAddress"`
+	Action         string       `json:"action,omitempty"` // "create" or "update"
+	ID             uint         `json:"id,omitempty"`
+	Errors         []FieldError `json:"errors,omitempty"`
+	InvitationSent *bool        `json:"invitationSent,omitempty"`
+}
+
+// ImportResponse is returned by POST /v1/users/import
+type ImportResponse struct {
+	DryRun  bool              `json:"dryRun"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// importOptions are the query parameters of POST /v1/users/import
+type importOptions struct {
+	DryRun bool // Validate and report without writing anything
+	Upsert bool // Update users whose mail address already exists instead of failing the row
+	Invite bool // Mail every created user a link to set their password
+}
+
+// csvImportColumns maps accepted CSV header names (lowercase) to importRow fields
+var csvImportColumns = map[string]string{
+	"username":     "username",
+	"mailaddress":  "mailAddress",
+	"mail_address": "mailAddress",
+	"email":        "mailAddress",
+	"role":         "role",
+	"password":     "password",
+}
+
+// parseImportRows reads the users of an import body, either a JSON array or a CSV file with a header row
+func parseImportRows(r *http.Request) ([]importRow, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		return parseImportCSV(r.Body)
+	}
+
+	var rows []importRow
+	if err := json.NewDecoder(r.Body).Decode(&rows); err != nil {
+		return nil, errors.New(ErrInvalidRequestBody)
+	}
+	return rows, nil
+}
+
+func parseImportCSV(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("CSV header row is missing")
+	}
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		field, ok := csvImportColumns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))]
+		if !ok {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		columns[i] = field
+		seen[field] = true
+	}
+	for _, required := range []string{"username", "mailAddress", "role"} {
+		if !seen[required] {
+			return nil, fmt.Errorf("CSV column %q is missing", required)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+
+		var row importRow
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch columns[i] {
+			case "username":
+				row.Username = value
+			case "mailAddress":
+				row.MailAddress = value
+			case "role":
+				row.Role = value
+			case "password":
+				row.Password = value
+			}
+		}
+		rows = append(rows, row)
+		if len(rows) > maxImportRows {
+			break
+		}
+	}
+	return rows, nil
+}
+
+// boolQuery reads an optional boolean query parameter
+func boolQuery(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("query parameter %q must be true or false", name)
+	}
+	return b, nil
+}
+
+// ImportUsersV1Handler handles POST /v1/users/import (admins only). Created
+// users join the admin's organization.
+// The import is all-or-nothing: if any row is invalid nothing is written and
+// every problem is reported with a 422.
+func (app *Config) ImportUsersV1Handler(w http.ResponseWriter, r *http.Request) {
+	var opts importOptions
+	var err error
+	for name, dst := range map[string]*bool{"dryRun": &opts.DryRun, "upsert": &opts.Upsert, "invite": &opts.Invite} {
+		if *dst, err = boolQuery(r, name); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBodyBytes)
+	rows, err := parseImportRows(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		writeError(w, http.StatusBadRequest, "No users to import")
+		return
+	}
+	if len(rows) > maxImportRows {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("At most %d users can be imported at once", maxImportRows))
+		return
+	}
+
+	organizationID := callerOrganizationID(r)
+	response := ImportResponse{DryRun: opts.DryRun, Rows: make([]ImportRowResult, len(rows))}
+	existing := make([]*User, len(rows))
+	usernamesInFile := map[string]int{}
+	mailAddressesInFile := map[string]int{}
+
+	for i, row := range rows {
+		result := &response.Rows[i]
+		*result = ImportRowResult{Row: i + 1, Username: row.Username, MailAddress: row.MailAddress}
+		result.Errors = validateStruct(&row)
+		if row.Password == "" && !opts.Invite {
+			result.Errors = append(result.Errors, FieldError{Field: "password", Message: "is required unless invite=true"})
+		}
+		if len(result.Errors) > 0 {
+			continue
+		}
+
+		// Duplicates within the file itself
+		if first, ok := usernamesInFile[identifier.Normalize(row.Username)]; ok {
+			result.Errors = append(result.Errors, FieldError{Field: "username", Message: fmt.Sprintf("duplicates row %d", first)})
+		} else {
+			usernamesInFile[identifier.Normalize(row.Username)] = i + 1
+		}
+		if first, ok := mailAddressesInFile[identifier.Normalize(row.MailAddress)]; ok {
+			result.Errors = append(result.Errors, FieldError{Field: "mailAddress", Message: fmt.Sprintf("duplicates row %d", first)})
+		} else {
+			mailAddressesInFile[identifier.Normalize(row.MailAddress)] = i + 1
+		}
+		if len(result.Errors) > 0 {
+			continue
+		}
+
+		// Conflicts with existing users
+		user, err := app.findUserByMailAddress(row.MailAddress)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user != nil && organizationID != 0 {
+			// Accounts of other organizations are neither visible nor updatable
+			if _, err := app.membershipRole(organizationID, user.ID); errors.Is(err, errNotMember) {
+				result.Errors = append(result.Errors, FieldError{Field: "mailAddress", Message: "already in use"})
+				continue
+			}
+		}
+		if user != nil {
+			switch {
+			case !opts.Upsert:
+				result.Errors = append(result.Errors, FieldError{Field: "mailAddress", Message: "already in use (set upsert=true to update)"})
+			case identifier.Normalize(row.Username) != user.UsernameNormalized:
+				result.Errors = append(result.Errors, FieldError{Field: "username", Message: "differs from the existing account; rename it through PUT /v1/users/{id}/username"})
+			case row.Password != "" && organizationID != 0:
+				// Passwords apply in every organization of a user, like PUT /v1/users/{id}/password
+				elsewhere, err := app.memberElsewhere(organizationID, user.ID)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, "Database error")
+					return
+				}
+				if elsewhere {
+					result.Errors = append(result.Errors, FieldError{Field: "password", Message: "cannot be set, the user also belongs to another organization"})
+					break
+				}
+				fallthrough
+			default:
+				result.Action = "update"
+				result.ID = user.ID
+				existing[i] = user
+			}
+			continue
+		}
+
+		taken, err := app.usernameTaken(row.Username, 0)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if taken {
+			result.Errors = append(result.Errors, FieldError{Field: "username", Message: "already in use"})
+			continue
+		}
+		result.Action = "create"
+	}
+
+	for _, result := range response.Rows {
+		switch {
+		case len(result.Errors) > 0:
+			response.Failed++
+		case result.Action == "create":
+			response.Created++
+		case result.Action == "update":
+			response.Updated++
+		}
+	}
+	if response.Failed > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, response)
+		return
+	}
+	if opts.DryRun {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	created := make([]*User, len(rows))
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		for i, row := range rows {
+			if user := existing[i]; user != nil {
+				user.Username = row.Username
+				if organizationID != 0 {
+					if err := setMembershipRole(tx, organizationID, user.ID, canonicalRole(row.Role)); err != nil {
+						return err
+					}
+				} else {
+					user.Role = canonicalRole(row.Role)
+				}
+				if row.Password != "" {
+					hashedPassword, err := app.HashPassword(row.Password)
+					if err != nil {
+						return err
+					}
+					user.Password = hashedPassword
+				}
+				if err := tx.Save(user).Error; err != nil {
+					return err
+				}
+				continue
+			}
+
+			password := row.Password
+			if password == "" {
+				// Nobody knows this password; the user sets one through the invitation link
+				var err error
+				if password, _, err = generateToken(); err != nil {
+					return err
+				}
+			}
+			hashedPassword, err := app.HashPassword(password)
+			if err != nil {
+				return err
+			}
+
+			user := &User{
+				Username:    row.Username,
+				MailAddress: row.MailAddress,
+				Password:    hashedPassword,
+				Role:        canonicalRole(row.Role),
+				Activated:   true,
+			}
+			if organizationID != 0 {
+				// The organization role is carried by the membership
+				user.Role = RoleCustomer
+			}
+			if err := tx.Create(user).Error; err != nil {
+				return err
+			}
+			if organizationID != 0 {
+				membership := Membership{OrganizationID: organizationID, UserID: user.ID, Role: canonicalRole(row.Role)}
+				if err := tx.Create(&membership).Error; err != nil {
+					return err
+				}
+			}
+			created[i] = user
+			response.Rows[i].ID = user.ID
+		}
+		return nil
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		writeError(w, http.StatusConflict, "A user was created concurrently, retry the import")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to import users")
+		return
+	}
+
+	if opts.Invite {
+		for i, user := range created {
+			if user == nil {
+				continue
+			}
+			sent := true
+			if err := app.sendAccountInvitation(user); err != nil {
+				log.Printf("❌ Failed to send invitation to imported user %d: %v", user.ID, err)
+				sent = false
+			}
+			response.Rows[i].InvitationSent = &sent
+		}
+	}
+
+	fmt.Printf("%d users created and %d updated by import of %s\n", response.Created, response.Updated, r.Header.Get("X-Username"))
+	writeJSON(w, http.StatusOK, response)
+}
+
+// exportColumns is the header of the CSV export
+var exportColumns = []string{"id", "username", "mailAddress", "role", "activated", "createdAt", "updatedAt"}
+
+// ExportUsersV1Handler handles GET /v1/users/export (admins only). Users are
+// streamed in batches as CSV (default) or as a JSON array with ?format=json.
+func (app *Config) ExportUsersV1Handler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		writeError(w, http.StatusBadRequest, `format must be "csv" or "json"`)
+		return
+	}
+
+	filename := fmt.Sprintf("users-%s.%s", time.Now().UTC().Format("20060102"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	flusher, _ := w.(http.Flusher)
+
+	// Within an organization, users are exported with their role there
+	roles := map[uint]string{}
+	if organizationID := callerOrganizationID(r); organizationID != 0 {
+		var memberships []Membership
+		if err := app.DB.Where("organization_id = ?", organizationID).Find(&memberships).Error; err != nil {
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		for _, membership := range memberships {
+			roles[membership.UserID] = membership.Role
+		}
+	}
+	roleOf := func(user User) string {
+		if role, ok := roles[user.ID]; ok {
+			return role
+		}
+		return user.Role
+	}
+
+	var writeBatch func([]User) error
+	var finish func()
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer := csv.NewWriter(w)
+		writer.Write(exportColumns)
+		writeBatch = func(users []User) error {
+			for _, user := range users {
+				writer.Write([]string{
+					strconv.FormatUint(uint64(user.ID), 10),
+					user.Username,
+					user.MailAddress,
+					roleOf(user),
+					strconv.FormatBool(user.Activated),
+					user.CreatedAt.UTC().Format(time.RFC3339),
+					user.UpdatedAt.UTC().Format(time.RFC3339),
+				})
+			}
+			writer.Flush()
+			return writer.Error()
+		}
+		finish = func() {}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "[")
+		first := true
+		writeBatch = func(users []User) error {
+			for _, user := range users {
+				if !first {
+					io.WriteString(w, ",")
+				}
+				first = false
+				response := newUserResponse(user)
+				response.Role = roleOf(user)
+				body, err := json.Marshal(response)
+				if err != nil {
+					return err
+				}
+				if _, err := w.Write(body); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		finish = func() { io.WriteString(w, "]\n") }
+	}
+
+	var users []User
+	err := scopeUsers(r, app.DB).Order("id").FindInBatches(&users, exportBatchSize, func(tx *gorm.DB, batch int) error {
+		if err := writeBatch(users); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}).Error
+	if err != nil {
+		// The status line is already sent; all we can do is cut the stream short
+		log.Printf("❌ User export aborted: %v", err)
+		return
+	}
+	finish()
+}
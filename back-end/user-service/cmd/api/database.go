@@ -1,32 +1,53 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+
+	"shared/identifier"
+	"shared/ratelimit"
 )
 
 // User model for GORM
 type User struct {
-	ID          uint      `gorm:"primaryKey"`
-	Username    string    `gorm:"unique;not null"`
-	MailAddress string    `gorm:"unique;not null"`
-	Password    string    `gorm:"not null"`
-	Role        string    `gorm:"not null"` // Admin or Sales Representative
-	Activated   bool      `gorm:"default:false"`
-	LoginStatus bool      `gorm:"default:false"`
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	ID                    uint       `gorm:"primaryKey"`
+	Username              string     `gorm:"unique;not null"`
+	MailAddress           string     `gorm:"unique;not null"`
+	UsernameNormalized    string     // Case-folded lookup key, unique index created by migrateNormalizedIdentifiers
+	MailAddressNormalized string     // Case-folded lookup key, unique index created by migrateNormalizedIdentifiers
+	Password              string     `gorm:"not null"`
+	Role                  string     `gorm:"not null"` // Admin or Sales Representative
+	Activated             bool       `gorm:"default:false"`
+	LoginStatus           bool       `gorm:"default:false"`
+	TokensValidAfter      *time.Time // Tokens issued up to this time are refused, set when the account may have been taken over
+	CreatedAt             time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt             time.Time  `gorm:"autoUpdateTime"`
+
+	stored *User `gorm:"-"` // State before the current update, see BeforeUpdate
+}
+
+// BeforeSave keeps the normalized lookup columns in sync with Username and MailAddress
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	u.UsernameNormalized = identifier.Normalize(u.Username)
+	u.MailAddressNormalized = identifier.Normalize(u.MailAddress)
+	return nil
 }
 
 // Config struct to hold database connection
 type Config struct {
-	DB *gorm.DB
+	DB                *gorm.DB
+	Limiter           *ratelimit.Limiter // Rate limits, none when nil
+	ValidateResponses bool               // Check responses against the OpenAPI document (tests only)
 }
 
+// models are the tables AutoMigrate creates
+var models = []interface{}{&User{}, &EmailChange{}, &UsernameChange{}, &PasswordSetup{}, &Invitation{}, &Organization{}, &Membership{}, &Team{}, &TeamMember{}, &RevokedToken{}, &OutboxEvent{}, &WebhookSubscription{}, &WebhookDelivery{}}
+
 // connectToDB retries connecting to PostgreSQL until it succeeds or fails after retries
 func connectToDB() (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
@@ -37,7 +58,7 @@ func connectToDB() (*gorm.DB, error) {
 
 	// Retry logic: Try connecting 10 times with a 5-second delay
 	for i := 1; i <= 10; i++ {
-		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 		if err == nil {
 			fmt.Println("✅ DATABASE connection success!")
 			break
@@ -51,10 +72,61 @@ func connectToDB() (*gorm.DB, error) {
 	}
 
 	// AutoMigrate to create tables
-	err = db.AutoMigrate(&User{})
+	err = db.AutoMigrate(models...)
 	if err != nil {
 		log.Fatalf("❌ Failed to migrate database : %v", err)
 	}
 
+	err = migrateNormalizedIdentifiers(db)
+	if err != nil {
+		log.Fatalf("❌ Failed to migrate normalized identifiers : %v", err)
+	}
+
 	return db, nil
 }
+
+// findUserByUsername looks a user up by username, ignoring case. Previous
+// usernames resolve to their account while they are reserved.
+func (app *Config) findUserByUsername(username string) (*User, error) {
+	var user User
+	err := app.DB.Where("username_normalized = ?", identifier.Normalize(username)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// A recently released name still resolves to the account that used it
+		return app.findReservedUsername(username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// findUserByMailAddress looks a user up by mail address, ignoring case
+func (app *Config) findUserByMailAddress(mailAddress string) (*User, error) {
+	var user User
+	err := app.DB.Where("mail_address_normalized = ?", identifier.Normalize(mailAddress)).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// usernameTaken reports whether a user other than excludeID already uses or reserves username, ignoring case
+func (app *Config) usernameTaken(username string, excludeID uint) (bool, error) {
+	var count int64
+	err := app.DB.Model(&User{}).
+		Where("username_normalized = ? AND id <> ?", identifier.Normalize(username), excludeID).
+		Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	return app.usernameReserved(username, excludeID)
+}
+
+// mailAddressTaken reports whether a user other than excludeID already uses mailAddress, ignoring case
+func (app *Config) mailAddressTaken(mailAddress string, excludeID uint) (bool, error) {
+	var count int64
+	err := app.DB.Model(&User{}).
+		Where("mail_address_normalized = ? AND id <> ?", identifier.Normalize(mailAddress), excludeID).
+		Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+
+	"shared/identifier"
+)
+
+const (
+	emailChangeConfirmTTL = 24 * time.Hour     // How long the link sent to the new address is valid
+	emailChangeRevertTTL  = 7 * 24 * time.Hour // How long the old address can undo a confirmed change
+)
+
+var errMailDelivery = errors.New("failed to send mail")
+
+// EmailChange is a requested mail address change. It is applied only once the
+// new address confirms it, after which the old address may revert it for a while.
+type EmailChange struct {
+	ID               uint      `gorm:"primaryKey"`
+	UserID           uint      `gorm:"not null;index"`
+	OldMailAddress   string    `gorm:"not null"`
+	NewMailAddress   string    `gorm:"not null"`
+	ConfirmTokenHash string    `gorm:"uniqueIndex;not null"`
+	ConfirmExpiresAt time.Time `gorm:"not null"`
+	ConfirmedAt      *time.Time
+	RevertTokenHash  *string `gorm:"uniqueIndex"`
+	RevertExpiresAt  *time.Time
+	RevertedAt       *time.Time
+	CreatedAt        time.Time `gorm:"autoCreateTime"`
+}
+
+// EmailChangeResponse is returned when a change has been requested
+type EmailChangeResponse struct {
+	ID             uint      `json:"id"`
+	NewMailAddress string    `json:"newMailAddress"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+}
+
+type emailChangeTokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// startEmailChange records a pending change for user and mails a confirmation link to the new address.
+// Any earlier unconfirmed request of the same user is discarded.
+func (app *Config) startEmailChange(user *User, newMailAddress string) (*EmailChange, error) {
+	token, hash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	change := EmailChange{
+		UserID:           user.ID,
+		OldMailAddress:   user.MailAddress,
+		NewMailAddress:   newMailAddress,
+		ConfirmTokenHash: hash,
+		ConfirmExpiresAt: time.Now().Add(emailChangeConfirmTTL),
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND confirmed_at IS NULL", user.ID).Delete(&EmailChange{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&change).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sendMail("email-change-confirmation", newMailAddress, map[string]interface{}{
+		"Username":   user.Username,
+		"ConfirmURL": appLink("/confirm-email", token),
+		"ExpiresAt":  change.ConfirmExpiresAt,
+	}, "email-change-confirmation/"+hash)
+	if err != nil {
+		log.Printf("❌ Failed to send email change confirmation for user %d: %v", user.ID, err)
+		app.DB.Delete(&change)
+		return nil, errMailDelivery
+	}
+	return &change, nil
+}
+
+// writeEmailChangeError maps startEmailChange errors to responses
+func writeEmailChangeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errMailDelivery) {
+		writeError(w, http.StatusBadGateway, "Failed to send confirmation mail")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "Failed to request email change")
+}
+
+// ConfirmEmailChangeHandler handles POST /v1/email-changes/confirm with the token mailed to the new address
+func (app *Config) ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var req emailChangeTokenRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	var change EmailChange
+	err := app.DB.Where("confirm_token_hash = ? AND confirmed_at IS NULL", hashToken(req.Token)).First(&change).Error
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Invalid confirmation link")
+		return
+	}
+	if time.Now().After(change.ConfirmExpiresAt) {
+		writeError(w, http.StatusGone, "Confirmation link has expired")
+		return
+	}
+
+	var user User
+	if err := app.DB.First(&user, change.UserID).Error; err != nil {
+		writeError(w, http.StatusNotFound, ErrUserNotFound)
+		return
+	}
+	if !app.ensureMailAddressAvailable(w, change.NewMailAddress, user.ID) {
+		return
+	}
+
+	revertToken, revertHash, err := generateToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to confirm email change")
+		return
+	}
+
+	now := time.Now()
+	revertExpiresAt := now.Add(emailChangeRevertTTL)
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		user.MailAddress = change.NewMailAddress
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		change.ConfirmedAt = &now
+		change.RevertTokenHash = &revertHash
+		change.RevertExpiresAt = &revertExpiresAt
+		return tx.Save(&change).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		writeError(w, http.StatusConflict, ErrMailAddressTaken)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to confirm email change")
+		return
+	}
+
+	// Tell the previous owner of the account, with a way to undo the change
+	err = sendMail("email-change-notification", change.OldMailAddress, map[string]interface{}{
+		"Username":       user.Username,
+		"NewMailAddress": change.NewMailAddress,
+		"RevertURL":      appLink("/revert-email", revertToken),
+		"ExpiresAt":      revertExpiresAt,
+	}, "email-change-notification/"+revertHash)
+	if err != nil {
+		log.Printf("❌ Failed to notify old address of email change %d: %v", change.ID, err)
+	}
+
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// RevertEmailChangeHandler handles POST /v1/email-changes/revert ("this wasn't me") with the token mailed to the old address
+func (app *Config) RevertEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var req emailChangeTokenRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	var change EmailChange
+	err := app.DB.Where("revert_token_hash = ? AND reverted_at IS NULL", hashToken(req.Token)).First(&change).Error
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Invalid revert link")
+		return
+	}
+	if change.RevertExpiresAt == nil || time.Now().After(*change.RevertExpiresAt) {
+		writeError(w, http.StatusGone, "Revert link has expired")
+		return
+	}
+
+	var user User
+	if err := app.DB.First(&user, change.UserID).Error; err != nil {
+		writeError(w, http.StatusNotFound, ErrUserNotFound)
+		return
+	}
+	if identifier.Normalize(user.MailAddress) != identifier.Normalize(change.NewMailAddress) {
+		writeError(w, http.StatusConflict, "Mail address has changed again since, contact support")
+		return
+	}
+	if !app.ensureMailAddressAvailable(w, change.OldMailAddress, user.ID) {
+		return
+	}
+
+	// Whoever changed the address may know the password and hold tokens: both are
+	// invalidated and the owner chooses a new password through a reset link
+	unusablePassword, _, err := generateToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to revert email change")
+		return
+	}
+	hashedPassword, err := app.HashPassword(unusablePassword)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrHashingPassword)
+		return
+	}
+
+	now := time.Now()
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		user.MailAddress = change.OldMailAddress
+		user.Password = hashedPassword
+		user.TokensValidAfter = &now
+		user.LoginStatus = false
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		change.RevertedAt = &now
+		return tx.Save(&change).Error
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to revert email change")
+		return
+	}
+
+	log.Printf("⚠️ Email change %d of user %d reverted by the previous address", change.ID, user.ID)
+	if err := app.sendPasswordReset(&user); err != nil {
+		log.Printf("❌ Failed to send password reset to user %d: %v", user.ID, err)
+	}
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Reverting an email change restores the address, drops the password and invalidates earlier tokens
+func TestRevertEmailChange(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app, "user", RoleCustomer, true)
+	token, err := GenerateJWT(user.Username, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revertToken, revertHash, err := generateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	change := EmailChange{
+		UserID:           user.ID,
+		OldMailAddress:   "previous@example.com",
+		NewMailAddress:   user.MailAddress,
+		ConfirmTokenHash: "confirmed",
+		ConfirmExpiresAt: expiresAt,
+		ConfirmedAt:      &expiresAt,
+		RevertTokenHash:  &revertHash,
+		RevertExpiresAt:  &expiresAt,
+	}
+	if err := app.DB.Create(&change).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(app, http.MethodPost, "/v1/email-changes/revert", "", map[string]string{"token": revertToken})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var reverted User
+	if err := app.DB.First(&reverted, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if reverted.MailAddress != "previous@example.com" {
+		t.Errorf("expected the previous address, got %s", reverted.MailAddress)
+	}
+	if reverted.TokensValidAfter == nil {
+		t.Errorf("expected the tokens to be invalidated")
+	}
+	if app.CheckPassword(&reverted, "Secret123!") {
+		t.Errorf("expected the password to be dropped")
+	}
+	if rec := serve(app, http.MethodGet, fmt.Sprintf("/v1/users/%d", user.ID), token, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a token issued before the revert, got %d", rec.Code)
+	}
+	// The link is used up
+	if rec := serve(app, http.MethodPost, "/v1/email-changes/revert", "", map[string]string{"token": revertToken}); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a second revert, got %d", rec.Code)
+	}
+}
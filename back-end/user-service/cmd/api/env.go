@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+
+	"shared/password"
 )
 
 // Load environment variables
@@ -15,11 +18,47 @@ var (
 	ServicePort = os.Getenv("USER_SERVICE_PORT")
 	ServiceName = os.Getenv("USER_SERVICE_NAME")
 	JWTSecret   = os.Getenv("USER_SERVICE_JWT_SECRET")
+
+	MailServiceURL         = os.Getenv("USER_SERVICE_MAIL_SERVICE_URL")         // e.g. http://mail-service:8081
+	MailServiceCredentials = os.Getenv("USER_SERVICE_MAIL_SERVICE_CREDENTIALS") // "clientID:secret", one of MAIL_SERVICE_SEND_CLIENTS
+	AppURL                 = os.Getenv("USER_SERVICE_APP_URL")                  // Web app base URL used in emailed links
+
+	SelfRegistration        = os.Getenv("USER_SERVICE_SELF_REGISTRATION")         // "open" (default), "closed" or "domains"
+	SelfRegistrationDomains = os.Getenv("USER_SERVICE_SELF_REGISTRATION_DOMAINS") // Comma-separated mail domains allowed in "domains" mode
+	VerificationTokenSecret = os.Getenv("USER_SERVICE_VERIFICATION_TOKEN_SECRET") // Same secret as MAIL_SERVICE_VERIFICATION_TOKEN_SECRET, checks that signups verified their mail address
+
+	GRPCPort  = os.Getenv("USER_SERVICE_GRPC_PORT")  // Internal gRPC API, disabled when empty
+	GRPCToken = os.Getenv("USER_SERVICE_GRPC_TOKEN") // Shared secret required from gRPC callers, mandatory with a gRPC port
+
+	IntrospectionClients = os.Getenv("USER_SERVICE_INTROSPECTION_CLIENTS") // Comma-separated "clientID:secret" pairs allowed to call /introspect
+
+	WebhookAllowedHosts = os.Getenv("USER_SERVICE_WEBHOOK_ALLOWED_HOSTS") // Comma-separated hosts webhooks may reach although they resolve to internal addresses
+
+	PasswordPepper           = os.Getenv("USER_SERVICE_PASSWORD_PEPPER")            // Optional server-side secret mixed into password hashes
+	PasswordPreviousPeppers  = os.Getenv("USER_SERVICE_PASSWORD_PREVIOUS_PEPPERS")  // Comma-separated former peppers, still verified until their hashes are rehashed
+	PasswordArgon2Memory     = os.Getenv("USER_SERVICE_PASSWORD_ARGON2_MEMORY")     // KiB, default 65536
+	PasswordArgon2Iterations = os.Getenv("USER_SERVICE_PASSWORD_ARGON2_ITERATIONS") // Default 3
+	PasswordArgon2Threads    = os.Getenv("USER_SERVICE_PASSWORD_ARGON2_THREADS")    // Default 2
+
+	RateLimits     = os.Getenv("USER_SERVICE_RATE_LIMITS")      // Comma-separated "<name>=<requests>/<period>" or "<name>=off" overrides
+	RateLimitStore = os.Getenv("USER_SERVICE_RATE_LIMIT_STORE") // "memory" (default) or a redis:// URL shared by all instances
+	BehindProxy    = os.Getenv("USER_SERVICE_BEHIND_PROXY")     // "true" to take the client IP from X-Real-IP / X-Forwarded-For
 )
 
+// Argon2id parameters for new password hashes
+var passwordParams, passwordParamsErr = password.ParseParams(PasswordArgon2Memory, PasswordArgon2Iterations, PasswordArgon2Threads)
+
 // Set DBPort explicitly to 5432 inside the container
 const DBPort = "5432"
 
+// setOrUnset hides the value of a secret in the printed environment
+func setOrUnset(secret string) string {
+	if secret == "" {
+		return "unset"
+	}
+	return "set"
+}
+
 // PrintEnvVariables prints all environment variables for debugging
 func PrintEnvVariables() {
 
@@ -32,6 +71,22 @@ func PrintEnvVariables() {
 	fmt.Printf("ServicePort: %s\n", ServicePort)
 	fmt.Printf("ServiceName: %s\n", ServiceName)
 	fmt.Printf("JWTSecret: %s\n", JWTSecret)
+	fmt.Printf("MailServiceURL: %s\n", MailServiceURL)
+	fmt.Printf("MailServiceCredentials: %s\n", setOrUnset(MailServiceCredentials))
+	fmt.Printf("AppURL: %s\n", AppURL)
+	fmt.Printf("SelfRegistration: %s\n", SelfRegistration)
+	fmt.Printf("SelfRegistrationDomains: %s\n", SelfRegistrationDomains)
+	fmt.Printf("VerificationTokenSecret: %s\n", setOrUnset(VerificationTokenSecret))
+	fmt.Printf("GRPCPort: %s\n", GRPCPort)
+	fmt.Printf("GRPCToken: %s\n", setOrUnset(GRPCToken))
+	fmt.Printf("IntrospectionClients: %s\n", setOrUnset(IntrospectionClients))
+	fmt.Printf("WebhookAllowedHosts: %s\n", WebhookAllowedHosts)
+	fmt.Printf("PasswordPepper: %s\n", setOrUnset(PasswordPepper))
+	fmt.Printf("PasswordPreviousPeppers: %s\n", setOrUnset(PasswordPreviousPeppers))
+	fmt.Printf("PasswordArgon2: memory=%s iterations=%s threads=%s\n", PasswordArgon2Memory, PasswordArgon2Iterations, PasswordArgon2Threads)
+	fmt.Printf("RateLimits: %s\n", RateLimits)
+	fmt.Printf("RateLimitStore: %s\n", RateLimitStore)
+	fmt.Printf("BehindProxy: %s\n", BehindProxy)
 
 	// Ensure all required environment variables are set
 	missingEnvVars := false
@@ -44,6 +99,51 @@ func PrintEnvVariables() {
 		missingEnvVars = true
 	}
 
+	switch SelfRegistration {
+	case "", registrationOpen, registrationClosed:
+	case registrationDomains:
+		if SelfRegistrationDomains == "" {
+			fmt.Println("❌ Error: USER_SERVICE_SELF_REGISTRATION is \"domains\" but USER_SERVICE_SELF_REGISTRATION_DOMAINS is empty")
+			missingEnvVars = true
+		}
+	default:
+		fmt.Printf("❌ Error: USER_SERVICE_SELF_REGISTRATION must be %q, %q or %q\n", registrationOpen, registrationClosed, registrationDomains)
+		missingEnvVars = true
+	}
+	if SelfRegistration != registrationClosed && VerificationTokenSecret == "" {
+		fmt.Println("⚠️ Warning: USER_SERVICE_VERIFICATION_TOKEN_SECRET not set, self-registration is refused")
+	}
+
+	if MailServiceURL == "" || AppURL == "" {
+		fmt.Println("⚠️ Warning: USER_SERVICE_MAIL_SERVICE_URL or USER_SERVICE_APP_URL not set, emails cannot be sent")
+	}
+	if MailServiceCredentials == "" {
+		fmt.Println("⚠️ Warning: USER_SERVICE_MAIL_SERVICE_CREDENTIALS not set, mail-service will refuse to send")
+	} else if id, secret, ok := strings.Cut(MailServiceCredentials, ":"); !ok || id == "" || secret == "" {
+		fmt.Println("❌ Error: USER_SERVICE_MAIL_SERVICE_CREDENTIALS must be clientID:secret")
+		missingEnvVars = true
+	}
+
+	if passwordParamsErr != nil {
+		fmt.Printf("❌ Error: USER_SERVICE_PASSWORD_ARGON2_*: %v\n", passwordParamsErr)
+		missingEnvVars = true
+	}
+
+	if rateLimitsErr != nil {
+		fmt.Printf("❌ Error: USER_SERVICE_RATE_LIMITS: %v\n", rateLimitsErr)
+		missingEnvVars = true
+	}
+
+	if introspectionClientsErr != nil {
+		fmt.Printf("❌ Error: USER_SERVICE_INTROSPECTION_CLIENTS: %v\n", introspectionClientsErr)
+		missingEnvVars = true
+	}
+
+	if GRPCPort != "" && GRPCToken == "" {
+		fmt.Println("❌ Error: USER_SERVICE_GRPC_TOKEN is required with USER_SERVICE_GRPC_PORT, the gRPC API would serve every organization to anyone")
+		missingEnvVars = true
+	}
+
 	if missingEnvVars {
 		log.Fatal("❌ Exiting due to missing environment variables.")
 	}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+
+	"user-service/pkg/userpb"
+)
+
+const (
+	grpcDefaultPageSize = 100
+	grpcMaxPageSize     = 500
+	grpcMaxBatchSize    = 500
+)
+
+// userGRPCServer implements userpb.UserServiceServer for internal callers
+type userGRPCServer struct {
+	userpb.UnimplementedUserServiceServer
+	app *Config
+}
+
+// newGRPCServer creates the gRPC server. Every call must carry GRPCToken as
+// "authorization: Bearer <token>" metadata.
+func (app *Config) newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(grpcAuthInterceptor))
+	userpb.RegisterUserServiceServer(server, &userGRPCServer{app: app})
+	return server
+}
+
+// grpcAuthInterceptor checks the shared service token. Without a configured token every call is refused.
+func grpcAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if GRPCToken == "" {
+		return nil, status.Error(codes.Unauthenticated, "gRPC API has no service token configured")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(GRPCToken)) == 1 {
+			return handler(ctx, req)
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "invalid service token")
+}
+
+// toProtoUser converts a User into its protobuf representation with the role it has in organizationID
+func (s *userGRPCServer) toProtoUser(organizationID uint, user User) *userpb.User {
+	response := s.app.userResponseIn(organizationID, user)
+	return &userpb.User{
+		Id:          uint64(response.ID),
+		Username:    response.Username,
+		MailAddress: response.MailAddress,
+		Role:        response.Role,
+		Activated:   response.Activated,
+		CreatedAt:   timestamppb.New(response.CreatedAt),
+		UpdatedAt:   timestamppb.New(response.UpdatedAt),
+	}
+}
+
+// scopeOrganization restricts a users query to members of organizationID (0 for no restriction)
+func scopeOrganization(db *gorm.DB, organizationID uint64) *gorm.DB {
+	if organizationID != 0 {
+		return db.Where("users.id IN (SELECT user_id FROM memberships WHERE organization_id = ?)", organizationID)
+	}
+	return db
+}
+
+func (s *userGRPCServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+	var (
+		user *User
+		err  error
+	)
+	switch lookup := req.GetLookup().(type) {
+	case *userpb.GetUserRequest_Id:
+		user = &User{}
+		err = s.app.DB.WithContext(ctx).First(user, lookup.Id).Error
+	case *userpb.GetUserRequest_Username:
+		user, err = s.app.findUserByUsername(lookup.Username)
+	case *userpb.GetUserRequest_MailAddress:
+		user, err = s.app.findUserByMailAddress(lookup.MailAddress)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "one of id, username or mail_address is required")
+	}
+	if err == nil && req.OrganizationId != 0 {
+		_, err = s.app.membershipRole(uint(req.OrganizationId), user.ID)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, errNotMember) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "database error")
+	}
+	return &userpb.GetUserResponse{User: s.toProtoUser(uint(req.OrganizationId), *user)}, nil
+}
+
+func (s *userGRPCServer) BatchGetUsers(ctx context.Context, req *userpb.BatchGetUsersRequest) (*userpb.BatchGetUsersResponse, error) {
+	if len(req.Ids) > grpcMaxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d ids per batch", grpcMaxBatchSize)
+	}
+	response := &userpb.BatchGetUsersResponse{}
+	if len(req.Ids) == 0 {
+		return response, nil
+	}
+
+	var users []User
+	err := scopeOrganization(s.app.DB.WithContext(ctx), req.OrganizationId).Where("id IN ?", req.Ids).Find(&users).Error
+	if err != nil {
+		return nil, status.Error(codes.Internal, "database error")
+	}
+
+	found := make(map[uint64]User, len(users))
+	for _, user := range users {
+		found[uint64(user.ID)] = user
+	}
+	// Keep the order of the request; duplicates are returned once
+	seen := make(map[uint64]bool, len(req.Ids))
+	for _, id := range req.Ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if user, ok := found[id]; ok {
+			response.Users = append(response.Users, s.toProtoUser(uint(req.OrganizationId), user))
+		} else {
+			response.MissingIds = append(response.MissingIds, id)
+		}
+	}
+	return response, nil
+}
+
+// ValidateToken applies the rules of /introspect, so a token is only valid while
+// it is not revoked, its user is activated and its role is still the user's role
+func (s *userGRPCServer) ValidateToken(ctx context.Context, req *userpb.ValidateTokenRequest) (*userpb.ValidateTokenResponse, error) {
+	tokenString := strings.TrimPrefix(strings.TrimSpace(req.Token), "Bearer ")
+	if tokenString == "" {
+		return &userpb.ValidateTokenResponse{Error: "missing token"}, nil
+	}
+
+	introspection, user, err := s.app.introspect(tokenString)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "database error")
+	}
+	if !introspection.Active {
+		return &userpb.ValidateTokenResponse{Error: "invalid token"}, nil
+	}
+
+	response := &userpb.ValidateTokenResponse{
+		Valid:          true,
+		Username:       introspection.Username,
+		Role:           introspection.Role,
+		OrganizationId: uint64(introspection.OrganizationID),
+		User:           s.toProtoUser(introspection.OrganizationID, *user),
+	}
+	if introspection.ExpiresAt != 0 {
+		response.ExpiresAt = timestamppb.New(time.Unix(introspection.ExpiresAt, 0))
+	}
+	return response, nil
+}
+
+func (s *userGRPCServer) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = grpcDefaultPageSize
+	}
+	if pageSize > grpcMaxPageSize {
+		pageSize = grpcMaxPageSize
+	}
+
+	// The page token is the id of the last user of the previous page
+	var after uint64
+	if req.PageToken != "" {
+		var err error
+		if after, err = strconv.ParseUint(req.PageToken, 10, 64); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+	}
+
+	query := scopeOrganization(s.app.DB.WithContext(ctx), req.OrganizationId).Where("users.id > ?", after)
+	if req.Role != "" {
+		role := canonicalRole(req.Role)
+		if role == "" {
+			return nil, status.Error(codes.InvalidArgument, "invalid role")
+		}
+		if req.OrganizationId != 0 {
+			query = query.Where("users.id IN (SELECT user_id FROM memberships WHERE organization_id = ? AND role = ?)", req.OrganizationId, role)
+		} else {
+			query = query.Where("users.role = ?", role)
+		}
+	}
+
+	var users []User
+	if err := query.Order("users.id").Limit(pageSize + 1).Find(&users).Error; err != nil {
+		return nil, status.Error(codes.Internal, "database error")
+	}
+
+	response := &userpb.ListUsersResponse{}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		response.NextPageToken = strconv.FormatUint(uint64(users[pageSize-1].ID), 10)
+	}
+	for _, user := range users {
+		response.Users = append(response.Users, s.toProtoUser(uint(req.OrganizationId), user))
+	}
+	return response, nil
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+
+	"shared/identifier"
+	"shared/password"
 )
 
 // Constants for error and success messages
@@ -20,6 +28,9 @@ const (
 	ErrInsertingUser      = "Error inserting user"
 	ErrUserNotFound       = "User not found"
 	ErrInvalidCredentials = "Invalid credentials"
+	ErrValidationFailed   = "Validation failed"
+	ErrMailAddressTaken   = "Mail address already in use"
+	ErrUsernameTaken      = "Username already in use"
 	UserCreatedSuccess    = "User created successfully"
 	UserUpdatedSuccess    = "User updated successfully"
 	UserDeletedSuccess    = "User deleted successfully"
@@ -29,6 +40,12 @@ const (
 // Secret key for JWT signing
 var jwtSecret = []byte(JWTSecret)
 
+// Hashes and verifies passwords, see shared/password
+var passwordHasher = password.NewHasher(passwordParams, PasswordPepper, strings.Split(PasswordPreviousPeppers, ",")...)
+
+// tokenTTL is how long an issued JWT is valid
+const tokenTTL = 24 * time.Hour
+
 // HealthCheckHandler checks if the database is available
 func (app *Config) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	sqlDB, err := app.DB.DB() // Get *sql.DB from *gorm.DB
@@ -49,64 +66,38 @@ func (app *Config) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
-// HashPassword hashes a password using bcrypt
+// HashPassword hashes a password with the current algorithm (argon2id)
 func (app *Config) HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
-}
-
-// CheckPassword compares a hashed password with a plain one
-func (app *Config) CheckPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+	return passwordHasher.Hash(password)
 }
 
-func (app *Config) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user User
-	err := json.NewDecoder(r.Body).Decode(&user)
+// CheckPassword compares the stored hash of user with a plain password. On a match
+// a hash made with an older algorithm, other parameters or another pepper is
+// replaced by a current one.
+func (app *Config) CheckPassword(user *User, password string) bool {
+	ok, needsRehash, err := passwordHasher.Verify(password, user.Password)
 	if err != nil {
-		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
-		return
+		log.Printf("❌ Cannot verify password of user %d: %v", user.ID, err)
+		return false
 	}
-
-	// Ensure MailAddress is provided
-	if user.MailAddress == "" {
-		http.Error(w, "Mail address cannot be empty", http.StatusBadRequest)
-		return
+	if !ok || !needsRehash {
+		return ok
 	}
 
-	// Check if user already exists (by username OR mail address)
-	var existingUser User
-	if err := app.DB.Where("username = ? OR mail_address = ?", user.Username, user.MailAddress).First(&existingUser).Error; err == nil {
-		http.Error(w, "User already exists", http.StatusConflict)
-		return
+	hashedPassword, err := passwordHasher.Hash(password)
+	if err == nil {
+		err = app.DB.Model(user).UpdateColumn("password", hashedPassword).Error
 	}
-
-	// Hash the password before saving
-	hashedPassword, err := app.HashPassword(user.Password)
 	if err != nil {
-		http.Error(w, ErrHashingPassword, http.StatusInternalServerError)
-		return
-	}
-	user.Password = hashedPassword
-
-	//  Sets Activated = true for every new user.
-	user.Activated = true
-
-	// Insert user into database using GORM
-	result := app.DB.Create(&user)
-	if result.Error != nil {
-		http.Error(w, ErrInsertingUser, http.StatusInternalServerError)
-		return
+		log.Printf("❌ Failed to rehash password of user %d: %v", user.ID, err)
 	}
+	return true
+}
 
-	// Generate JWT token
-	token, err := GenerateJWT(user.Username, user.Role)
-	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+func (app *Config) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Register the user like POST /v1/users
+	user, token, ok := app.createUser(w, r)
+	if !ok {
 		return
 	}
 
@@ -120,32 +111,33 @@ func (app *Config) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user User
+	var req createSessionRequest
 	var storedUser User
 
 	// Parse the incoming request body
-	err := json.NewDecoder(r.Body).Decode(&user)
-	if err != nil {
-		http.Error(w, "Invalid request body from UI", http.StatusBadRequest)
+	if !decodeAndValidate(w, r, &req) {
 		return
 	}
 
 	// Find user in DB by MailAddress
-	result := app.DB.Where("mail_address = ?", user.MailAddress).First(&storedUser)
+	result := app.DB.Where("mail_address_normalized = ?", identifier.Normalize(req.MailAddress)).First(&storedUser)
 	if result.Error != nil {
 		http.Error(w, "User-mail address not found! Please check your mail address ro Signup", http.StatusUnauthorized)
 		return
 	}
 
 	// Compare passwords (Hash the input password and compare with stored hashed password)
-	err = bcrypt.CompareHashAndPassword([]byte(storedUser.Password), []byte(user.Password))
-	if err != nil {
+	if !app.CheckPassword(&storedUser, req.Password) {
 		http.Error(w, "The password entered is incorrect. Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if !storedUser.Activated {
+		http.Error(w, "Account is deactivated", http.StatusForbidden)
+		return
+	}
 
-	// Generate JWT token
-	token, err := GenerateJWT(storedUser.MailAddress, storedUser.Role)
+	// Generate JWT token for the user's first organization, if any
+	session, err := app.issueTokenAs(storedUser.MailAddress, &storedUser, 0)
 	if err != nil {
 		http.Error(w, "Failed to generate JWT token", http.StatusInternalServerError)
 		return
@@ -158,146 +150,119 @@ func (app *Config) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Send response with token, message, login status, and username
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"token":       token,
+		"token":       session.Token,
 		"message":     "Login successful",
 		"loginStatus": "true",
 		"username":    storedUser.Username, // Add username to the response
 	})
 }
 
-func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
-	var requestData struct {
-		Username    string `json:"username"`
-		NewPassword string `json:"new_password"`
+// callV1 runs the /v1 successor of a deprecated alias for the user the alias
+// names by username, with body as the JSON request body, so both apply the same
+// authorization rules. Error responses of the successor are passed through; on
+// success its response is dropped and the alias answers in its own format.
+func (app *Config) callV1(w http.ResponseWriter, r *http.Request, username string, handler http.HandlerFunc, body interface{}) (*User, bool) {
+	user, err := app.findScopedUserByUsername(r, username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, ErrUserNotFound)
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
 	}
 
-	// Decode request body
-	err := json.NewDecoder(r.Body).Decode(&requestData)
+	payload, err := json.Marshal(body)
 	if err != nil {
-		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
-		return
+		writeError(w, http.StatusInternalServerError, "Failed to encode request")
+		return nil, false
 	}
+	r = withUserID(r, user.ID)
+	r.Body = io.NopCloser(bytes.NewReader(payload))
+	r.ContentLength = int64(len(payload))
 
-	// Log the incoming request for debugging
-	fmt.Println("Received request to update password for username:", requestData.Username)
+	rec := &bufferedResponse{header: http.Header{}, statusCode: http.StatusOK}
+	handler(rec, r)
+	if rec.statusCode >= http.StatusMultipleChoices {
+		rec.flush(w)
+		return nil, false
+	}
+	return user, true
+}
 
-	// Find the user by username
-	var user User
-	result := app.DB.Where("username = ?", requestData.Username).First(&user)
+// withUserID returns r with the {id} URL parameter of /v1/users/{id} set to id
+func withUserID(r *http.Request, id uint) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.FormatUint(uint64(id), 10))
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
 
-	// Log the result of the query to help debug
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			http.Error(w, ErrUserNotFound, http.StatusNotFound)
-			fmt.Println("User not found:", requestData.Username) // Add log for failed query
-			return
-		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+func (app *Config) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Username    string `json:"username" validate:"required"`
+		NewPassword string `json:"new_password" validate:"required,password"`
 	}
 
-	// Log the found user for debugging
-	fmt.Println("Found user:", user)
-
-	// Hash new password
-	hashedPassword, err := app.HashPassword(requestData.NewPassword)
-	if err != nil {
-		http.Error(w, ErrHashingPassword, http.StatusInternalServerError)
+	// Decode and validate request body
+	if !decodeAndValidate(w, r, &requestData) {
 		return
 	}
 
-	// Update the password
-	user.Password = hashedPassword
-	app.DB.Save(&user)
-
-	// Log successful password update
-	fmt.Println("Password updated for user:", requestData.Username)
+	// Update the password through PUT /v1/users/{id}/password
+	body := updatePasswordRequest{Password: requestData.NewPassword}
+	if _, ok := app.callV1(w, r, requestData.Username, app.UpdatePasswordV1Handler, body); !ok {
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "Password updated successfully")
 }
 
 func (app *Config) GetUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user User
-	id := r.URL.Query().Get("id")
-
-	// Fetch the user by ID from the database
-	result := app.DB.First(&user, id)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			http.Error(w, ErrUserNotFound, http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, ErrUserNotFound, http.StatusNotFound)
 		return
 	}
 
-	// Check if the MailAddress is empty and log a warning or handle appropriately
-	if user.MailAddress == "" {
-		// You can log this if necessary or handle the empty field case
-		fmt.Println("Warning: User has no MailAddress.")
-	}
-
-	// Respond with user data in JSON format
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(user)
+	// Same rules and representation as GET /v1/users/{id}
+	app.GetUserV1Handler(w, withUserID(r, uint(id)))
 }
 
 func (app *Config) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request body to get username and updated fields
 	var requestBody struct {
-		Username string `json:"username"`
-		Password string `json:"password,omitempty"`
+		Username string `json:"username" validate:"required"`
+		Password string `json:"password,omitempty" validate:"omitempty,password"`
 		Email    string `json:"email,omitempty"`
-		Role     string `json:"role,omitempty"`
+		Role     string `json:"role,omitempty" validate:"omitempty,role"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, ErrInvalidRequestBody, http.StatusBadRequest)
+	if !decodeAndValidate(w, r, &requestBody) {
 		return
 	}
 
-	if requestBody.Username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
-		return
-	}
-
-	// Find user by username
-	var user User
-	result := app.DB.Where("username = ?", requestBody.Username).First(&user)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			http.Error(w, ErrUserNotFound, http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	// Mail address changes must be confirmed by the new address (see /update-email)
+	if requestBody.Email != "" {
+		writeValidationError(w, []FieldError{{Field: "email", Message: "must be changed through /update-email"}})
 		return
 	}
 
-	// Update user fields if provided
+	// Update the fields that were provided through PATCH /v1/users/{id}
+	var body patchUserRequest
 	if requestBody.Password != "" {
-		hashedPassword, err := app.HashPassword(requestBody.Password)
-		if err != nil {
-			http.Error(w, ErrHashingPassword, http.StatusInternalServerError)
-			return
-		}
-		user.Password = hashedPassword
-	}
-	if requestBody.Email != "" {
-		user.MailAddress = requestBody.Email
+		body.Password = &requestBody.Password
 	}
 	if requestBody.Role != "" {
-		user.Role = requestBody.Role
+		body.Role = &requestBody.Role
 	}
-
-	// Save updated user
-	if err := app.DB.Save(&user).Error; err != nil {
-		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+	user, ok := app.callV1(w, r, requestBody.Username, app.PatchUserV1Handler, body)
+	if !ok {
 		return
 	}
 
 	// Send success response
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"message":  "User updated successfully",
@@ -308,33 +273,16 @@ func (app *Config) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 func (app *Config) DeactivateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request body to get username
 	var requestBody struct {
-		Username string `json:"username"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if requestBody.Username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
-		return
+		Username string `json:"username" validate:"required"`
 	}
 
-	// Find user by username
-	var user User
-	result := app.DB.Where("username = ?", requestBody.Username).First(&user)
-	if result.Error != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	if !decodeAndValidate(w, r, &requestBody) {
 		return
 	}
 
-	// Set Activated to false
-	user.Activated = false
-
-	// Update the user in the database
-	if err := app.DB.Save(&user).Error; err != nil {
-		http.Error(w, "Failed to deactivate user", http.StatusInternalServerError)
+	// Deactivate the user through DELETE /v1/users/{id}/activation
+	user, ok := app.callV1(w, r, requestBody.Username, app.DeactivateUserV1Handler, nil)
+	if !ok {
 		return
 	}
 
@@ -349,33 +297,16 @@ func (app *Config) DeactivateUserHandler(w http.ResponseWriter, r *http.Request)
 func (app *Config) ActivateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request body to get username
 	var requestBody struct {
-		Username string `json:"username"`
+		Username string `json:"username" validate:"required"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeAndValidate(w, r, &requestBody) {
 		return
 	}
 
-	if requestBody.Username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
-		return
-	}
-
-	// Find user by username
-	var user User
-	result := app.DB.Where("username = ?", requestBody.Username).First(&user)
-	if result.Error != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
-	// Set Activated to true
-	user.Activated = true
-
-	// Update the user in the database
-	if err := app.DB.Save(&user).Error; err != nil {
-		http.Error(w, "Failed to activate user", http.StatusInternalServerError)
+	// Activate the user through PUT /v1/users/{id}/activation
+	user, ok := app.callV1(w, r, requestBody.Username, app.ActivateUserV1Handler, nil)
+	if !ok {
 		return
 	}
 
@@ -388,231 +319,97 @@ func (app *Config) ActivateUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) UpdateEmailHandler(w http.ResponseWriter, r *http.Request) {
-	// Ensure the user is authenticated with JWT
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Missing token", http.StatusUnauthorized)
-		return
-	}
-
-	// Extract token from "Bearer <token>"
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	if tokenString == authHeader {
-		http.Error(w, "Invalid token format", http.StatusUnauthorized)
-		return
-	}
-
-	// Parse and validate JWT
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
 	// Parse the request body (expects JSON with username and new email)
 	var requestData struct {
-		Username string `json:"username"`
-		NewEmail string `json:"new_email"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Ensure both fields are provided
-	if requestData.Username == "" || requestData.NewEmail == "" {
-		http.Error(w, "Username and new email are required", http.StatusBadRequest)
-		return
+		Username string `json:"username" validate:"required"`
+		NewEmail string `json:"new_email" validate:"required,mailaddress"`
 	}
-
-	// Validate email format
-	if !isValidEmail(requestData.NewEmail) {
-		http.Error(w, "Invalid email format", http.StatusBadRequest)
-		return
-	}
-
-	// Find user by username
-	var user User
-	result := app.DB.Where("username = ?", requestData.Username).First(&user)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			http.Error(w, "User not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if !decodeAndValidate(w, r, &requestData) {
 		return
 	}
 
-	// Update email
-	user.MailAddress = requestData.NewEmail
-	if err := app.DB.Save(&user).Error; err != nil {
-		http.Error(w, "Failed to update email", http.StatusInternalServerError)
+	// Request the change through PUT /v1/users/{id}/email; it is applied once the new address confirms it
+	body := updateEmailRequest{MailAddress: requestData.NewEmail}
+	user, ok := app.callV1(w, r, requestData.Username, app.UpdateEmailV1Handler, body)
+	if !ok {
 		return
 	}
 
-	// Log the email update action (optional)
-	fmt.Printf("User %s updated their email to %s\n", user.Username, user.MailAddress)
-
-	// Send success response
-	w.WriteHeader(http.StatusOK)
+	// Send accepted response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]string{
-		"message":   "Email updated successfully",
+		"message":   "Confirmation mail sent to the new email address",
 		"username":  user.Username,
-		"new_email": user.MailAddress,
+		"new_email": requestData.NewEmail,
 	})
 }
 
 func (app *Config) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
-	// Ensure the user is authenticated with JWT
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Missing token", http.StatusUnauthorized)
-		return
-	}
-
-	// Extract the token and parse it
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	if tokenString == authHeader {
-		http.Error(w, "Invalid token format", http.StatusUnauthorized)
-		return
-	}
-
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
 	// Extract the username and role from the request body
 	var requestData struct {
-		Username string `json:"username"`
-		Role     string `json:"role"`
-	}
-
-	// Decode the JSON body into requestData
-	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&requestData)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Check if username and role are provided
-	if requestData.Username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
-		return
-	}
-	if requestData.Role == "" {
-		http.Error(w, "Role is required", http.StatusBadRequest)
-		return
+		Username string `json:"username" validate:"required"`
+		Role     string `json:"role" validate:"required,role"`
 	}
 
-	// Find the user by username
-	var user User
-	result := app.DB.Where("username = ?", requestData.Username).First(&user)
-	if result.RowsAffected == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
+	// Decode and validate the JSON body into requestData
+	if !decodeAndValidate(w, r, &requestData) {
 		return
 	}
 
-	// Update the role
-	user.Role = requestData.Role
-	result = app.DB.Save(&user)
-	if result.Error != nil {
-		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+	// Update the role through PUT /v1/users/{id}/role (within the caller's organization, if any)
+	role := canonicalRole(requestData.Role)
+	if _, ok := app.callV1(w, r, requestData.Username, app.UpdateRoleV1Handler, updateRoleRequest{Role: role}); !ok {
 		return
 	}
 
-	// Respond with the updated user info (or just a success message)
+	// Respond with a success message
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "User role updated to: %s", user.Role)
+	fmt.Fprintf(w, "User role updated to: %s", role)
 }
 
 func (app *Config) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the username from the request body (assuming JSON format)
 	var requestData struct {
-		Username string `json:"username"`
-	}
-
-	// Decode the JSON body into requestData
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&requestData)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+		Username string `json:"username" validate:"required"`
 	}
 
-	// Ensure the username is provided
-	if requestData.Username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
+	// Decode and validate the JSON body into requestData
+	if !decodeAndValidate(w, r, &requestData) {
 		return
 	}
 
-	// Ensure the user is authenticated with JWT
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		http.Error(w, "Missing token", http.StatusUnauthorized)
+	// Delete the user through DELETE /v1/users/{id}
+	if _, ok := app.callV1(w, r, requestData.Username, app.DeleteUserV1Handler, nil); !ok {
 		return
 	}
 
-	// Extract the token and parse it
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	if tokenString == authHeader {
-		http.Error(w, "Invalid token format", http.StatusUnauthorized)
-		return
-	}
-
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
-	// Optional: Add the username from the token to the request context (for logging or further checks)
-	authenticatedUsername := claims["username"].(string)
-
-	// Find the user to delete by username
-	var user User
-	result := app.DB.Where("username = ?", requestData.Username).Delete(&user)
-
-	// If no rows were affected, the user was not found
-	if result.RowsAffected == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
-	// Log the deletion action (optional)
-	fmt.Printf("User %s (Username: %s) deleted by %s\n", user.Username, requestData.Username, authenticatedUsername)
-
 	// Respond with success message
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "User deleted successfully")
 }
 
-// Helper function to validate email format (simple validation)
-func isValidEmail(email string) bool {
-	// You can use a more robust regex or library for email validation
-	// Here, a basic validation for a common email format is used
-	re := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	return re.MatchString(email)
-}
-
 // GenerateJWT creates a JWT token for a user
 func GenerateJWT(username, role string) (string, error) {
+	return GenerateOrgJWT(username, role, 0)
+}
+
+// GenerateOrgJWT creates a JWT token for a user acting in an organization (0 for none)
+func GenerateOrgJWT(username, role string, organizationID uint) (string, error) {
+	jti, _, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"username": username,
 		"role":     role,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
+		"jti":      jti, // Lets a single token be revoked
+		"iat":      now.Unix(),
+		"exp":      now.Add(tokenTTL).Unix(),
+	}
+	if organizationID != 0 {
+		claims["org"] = organizationID
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -649,6 +446,14 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		// Add username and role to request context (optional)
 		r.Header.Set("X-Username", claims["username"].(string))
 		r.Header.Set("X-Role", claims["role"].(string))
+		r.Header.Del("X-Organization-ID")
+		r.Header.Del("X-Token-ID")
+		if org, ok := claims["org"].(float64); ok && org > 0 {
+			r.Header.Set("X-Organization-ID", strconv.FormatUint(uint64(org), 10))
+		}
+		if jti, ok := claims["jti"].(string); ok {
+			r.Header.Set("X-Token-ID", jti)
+		}
 
 		next.ServeHTTP(w, r) // Call the next handler
 	})
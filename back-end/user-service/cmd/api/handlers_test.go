@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// The deprecated GET /user answers like GET /v1/users/{id}, without the password hash
+func TestLegacyGetUser(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app, "user", RoleCustomer, true)
+	token, err := GenerateJWT(user.Username, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(app, http.MethodGet, fmt.Sprintf("/user?id=%d", user.ID), token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	for _, leak := range []string{"Password", "argon2id", "Normalized"} {
+		if strings.Contains(body, leak) {
+			t.Errorf("expected no %s in %s", leak, body)
+		}
+	}
+	if !strings.Contains(body, `"mailAddress":"user@example.com"`) {
+		t.Errorf("expected the v1 representation, got %s", body)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"gorm.io/gorm"
+
+	"shared/clientauth"
+)
+
+// RevokedToken records a JWT that was revoked before it expired. Rows are
+// removed once the token would have expired anyway.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// roleScopes are the OAuth scopes reported for a token of each role
+var roleScopes = map[string][]string{
+	RoleAdmin:               {"users:read", "users:write", "users:admin"},
+	RoleManager:             {"users:read", "users:manage", "profile"},
+	RoleSalesRepresentative: {"profile"},
+	RoleCustomer:            {"profile"},
+}
+
+// introspectionClients maps the client IDs allowed to call /introspect to their secrets
+var introspectionClients, introspectionClientsErr = clientauth.Parse(IntrospectionClients)
+
+// authenticateClient checks the client credentials of an introspection request,
+// sent with HTTP Basic authentication or as client_id/client_secret form fields
+func authenticateClient(r *http.Request) bool {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	return introspectionClients.Verify(id, secret)
+}
+
+// tokenRevoked reports whether the token with the given jti was revoked
+func (app *Config) tokenRevoked(jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	var count int64
+	err := app.DB.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// findTokenUser finds the user named by the username claim of a token. Legacy
+// logins put the mail address there.
+func (app *Config) findTokenUser(username string) (*User, error) {
+	user, err := app.findUserByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return app.findUserByMailAddress(username)
+	}
+	return user, err
+}
+
+// RejectInactiveTokens refuses requests made with a token that introspection
+// would report inactive: revoked, of a deleted or deactivated user, or carrying
+// a role the user no longer has. It must run after AuthMiddleware.
+func (app *Config) RejectInactiveTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, _, err := app.introspect(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if !response.Active {
+			writeError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DeleteSessionV1Handler handles DELETE /v1/sessions/current (logout) and revokes the caller's token
+func (app *Config) DeleteSessionV1Handler(w http.ResponseWriter, r *http.Request) {
+	jti := r.Header.Get("X-Token-ID")
+	if jti == "" {
+		writeError(w, http.StatusBadRequest, "Token cannot be revoked, it was issued before revocation was supported")
+		return
+	}
+
+	// RejectInactiveTokens already made sure the user exists
+	user, err := app.findTokenUser(r.Header.Get("X-Username"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&RevokedToken{JTI: jti, ExpiresAt: time.Now().Add(tokenTTL)}).Error; err != nil {
+			return err
+		}
+		return tx.Model(user).Update("login_status", false).Error
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// introspectionResponse is the RFC 7662 introspection response. Inactive tokens
+// only carry active=false.
+type introspectionResponse struct {
+	Active         bool   `json:"active"`
+	Scope          string `json:"scope,omitempty"`
+	TokenType      string `json:"token_type,omitempty"`
+	Subject        string `json:"sub,omitempty"`
+	Username       string `json:"username,omitempty"`
+	Role           string `json:"role,omitempty"`
+	OrganizationID uint   `json:"org,omitempty"`
+	ExpiresAt      int64  `json:"exp,omitempty"`
+	IssuedAt       int64  `json:"iat,omitempty"`
+	JTI            string `json:"jti,omitempty"`
+}
+
+// IntrospectHandler handles POST /introspect (RFC 7662). Besides the signature and
+// expiry it checks, at the time of the call, that the token was not revoked, that
+// its user still exists and is activated, that it was issued after the user's
+// tokens were last invalidated, and that the role in the token is still the
+// user's role.
+func (app *Config) IntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !authenticateClient(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	tokenString := r.PostFormValue("token")
+	if tokenString == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	response, _, err := app.introspect(tokenString)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// introspect evaluates a token and returns the user of an active one. An error is
+// only returned when the state could not be determined.
+func (app *Config) introspect(tokenString string) (*introspectionResponse, *User, error) {
+	inactive := &introspectionResponse{Active: false}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return inactive, nil, nil
+	}
+
+	jti, _ := claims["jti"].(string)
+	revoked, err := app.tokenRevoked(jti)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return inactive, nil, nil
+	}
+
+	username, _ := claims["username"].(string)
+	user, err := app.findTokenUser(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return inactive, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.Activated {
+		return inactive, nil, nil
+	}
+	iat, _ := claims["iat"].(float64)
+	if user.TokensValidAfter != nil && int64(iat) <= user.TokensValidAfter.Unix() {
+		return inactive, nil, nil
+	}
+
+	var organizationID uint
+	if org, ok := claims["org"].(float64); ok && org > 0 {
+		organizationID = uint(org)
+	}
+	role := user.Role
+	if organizationID != 0 {
+		role, err = app.membershipRole(organizationID, user.ID)
+		if errors.Is(err, errNotMember) {
+			return inactive, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if claimRole, _ := claims["role"].(string); canonicalRole(claimRole) != role {
+		return inactive, nil, nil
+	}
+
+	response := &introspectionResponse{
+		Active:         true,
+		Scope:          strings.Join(roleScopes[role], " "),
+		TokenType:      "Bearer",
+		Subject:        strconv.FormatUint(uint64(user.ID), 10),
+		Username:       user.Username,
+		Role:           role,
+		OrganizationID: organizationID,
+		JTI:            jti,
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		response.ExpiresAt = int64(exp)
+	}
+	response.IssuedAt = int64(iat)
+	return response, user, nil
+}
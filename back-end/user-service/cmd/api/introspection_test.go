@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Protected routes refuse tokens that introspection reports inactive
+func TestRejectInactiveTokens(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name   string
+		change func(user *User) error
+	}{
+		{"deactivated", func(user *User) error { return app.DB.Model(user).Update("activated", false).Error }},
+		{"role changed", func(user *User) error { return app.DB.Model(user).Update("role", RoleManager).Error }},
+		{"deleted", func(user *User) error { return app.DB.Delete(user).Error }},
+	}
+	for i, tt := range tests {
+		user := createTestUser(t, app, fmt.Sprintf("user%d", i), RoleCustomer, true)
+		token, err := GenerateJWT(user.Username, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := fmt.Sprintf("/v1/users/%d", user.ID)
+
+		if rec := serve(app, http.MethodGet, path, token, nil); rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200 before the change, got %d: %s", tt.name, rec.Code, rec.Body.String())
+		}
+		if err := tt.change(user); err != nil {
+			t.Fatal(err)
+		}
+		rec := serve(app, http.MethodGet, path, token, nil)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected a JSON 401, got %d %s", tt.name, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+// A token stops working once its session is deleted
+func TestRejectRevokedToken(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app, "user", RoleCustomer, true)
+	token, err := GenerateJWT(user.Username, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(app, http.MethodDelete, "/v1/sessions/current", token, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(app, http.MethodGet, fmt.Sprintf("/v1/users/%d", user.ID), token, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a revoked token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"shared/identifier"
+)
+
+// invitationTTL is how long an invitation link can be accepted
+const invitationTTL = 7 * 24 * time.Hour
+
+// Invitation lets an admin onboard a person with a pre-assigned role. The
+// invitee picks their username and password when accepting.
+type Invitation struct {
+	ID                    uint      `gorm:"primaryKey"`
+	MailAddress           string    `gorm:"not null"`
+	MailAddressNormalized string    `gorm:"not null;index"`
+	Role                  string    `gorm:"not null"` // Role in OrganizationID, or the global role without one
+	OrganizationID        *uint     `gorm:"index"`
+	TokenHash             string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt             time.Time `gorm:"not null"`
+	InvitedBy             string
+	AcceptedAt            *time.Time
+	UserID                *uint
+	CreatedAt             time.Time `gorm:"autoCreateTime"`
+}
+
+// BeforeSave keeps MailAddressNormalized in sync with MailAddress
+func (i *Invitation) BeforeSave(tx *gorm.DB) error {
+	i.MailAddressNormalized = identifier.Normalize(i.MailAddress)
+	return nil
+}
+
+// InvitationResponse is the API representation of an Invitation (never exposes the token)
+type InvitationResponse struct {
+	ID             uint      `json:"id"`
+	MailAddress    string    `json:"mailAddress"`
+	Role           string    `json:"role"`
+	OrganizationID *uint     `json:"organizationId,omitempty"`
+	InvitedBy      string    `json:"invitedBy"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+func newInvitationResponse(invitation Invitation) InvitationResponse {
+	return InvitationResponse{
+		ID:             invitation.ID,
+		MailAddress:    invitation.MailAddress,
+		Role:           invitation.Role,
+		OrganizationID: invitation.OrganizationID,
+		InvitedBy:      invitation.InvitedBy,
+		ExpiresAt:      invitation.ExpiresAt,
+		CreatedAt:      invitation.CreatedAt,
+	}
+}
+
+type createInvitationRequest struct {
+	MailAddress string `json:"mailAddress" validate:"required,mailaddress"`
+	Role        string `json:"role" validate:"required,role"`
+}
+
+type acceptInvitationRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Username string `json:"username" validate:"required,username"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+// scopeInvitations restricts an invitations query to the caller's organization
+func scopeInvitations(r *http.Request, db *gorm.DB) *gorm.DB {
+	if organizationID := callerOrganizationID(r); organizationID != 0 {
+		return db.Where("organization_id = ?", organizationID)
+	}
+	return db
+}
+
+// CreateInvitationHandler handles POST /v1/invitations (admins only). The invitee
+// joins the admin's organization. Inviting the same address again replaces its
+// pending invitation.
+func (app *Config) CreateInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var req createInvitationRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+	if !app.ensureMailAddressAvailable(w, req.MailAddress, 0) {
+		return
+	}
+
+	token, hash, err := generateToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create invitation")
+		return
+	}
+
+	invitation := Invitation{
+		MailAddress: req.MailAddress,
+		Role:        canonicalRole(req.Role),
+		TokenHash:   hash,
+		ExpiresAt:   time.Now().Add(invitationTTL),
+		InvitedBy:   r.Header.Get("X-Username"),
+	}
+	if organizationID := callerOrganizationID(r); organizationID != 0 {
+		invitation.OrganizationID = &organizationID
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the pending invitation into the same organization is replaced, a
+		// platform admin's invitation never touches those of organizations
+		pending := tx.Where("mail_address_normalized = ? AND accepted_at IS NULL", identifier.Normalize(req.MailAddress))
+		if invitation.OrganizationID != nil {
+			pending = pending.Where("organization_id = ?", *invitation.OrganizationID)
+		} else {
+			pending = pending.Where("organization_id IS NULL")
+		}
+		err := pending.Delete(&Invitation{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create invitation")
+		return
+	}
+
+	err = sendMail("invitation", invitation.MailAddress, map[string]interface{}{
+		"Role":      invitation.Role,
+		"InvitedBy": invitation.InvitedBy,
+		"AcceptURL": appLink("/accept-invitation", token),
+		"ExpiresAt": invitation.ExpiresAt,
+	}, "invitation/"+invitation.TokenHash)
+	if err != nil {
+		log.Printf("❌ Failed to send invitation %d: %v", invitation.ID, err)
+		app.DB.Delete(&invitation)
+		writeError(w, http.StatusBadGateway, "Failed to send invitation mail")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/invitations/%d", invitation.ID))
+	writeJSON(w, http.StatusCreated, newInvitationResponse(invitation))
+}
+
+// ListInvitationsHandler handles GET /v1/invitations (admins only) and lists pending invitations
+func (app *Config) ListInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	var invitations []Invitation
+	err := scopeInvitations(r, app.DB).Where("accepted_at IS NULL AND expires_at > ?", time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	response := make([]InvitationResponse, 0, len(invitations))
+	for _, invitation := range invitations {
+		response = append(response, newInvitationResponse(invitation))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// RevokeInvitationHandler handles DELETE /v1/invitations/{invitationID} (admins only)
+func (app *Config) RevokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "invitationID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invitation id")
+		return
+	}
+
+	result := scopeInvitations(r, app.DB).Where("id = ? AND accepted_at IS NULL", id).Delete(&Invitation{})
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to revoke invitation")
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeError(w, http.StatusNotFound, "Invitation not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvitationHandler handles POST /v1/invitations/accept. The invitee
+// chooses username and password; mail address and role come from the invitation.
+func (app *Config) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var req acceptInvitationRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	var invitation Invitation
+	err := app.DB.Where("token_hash = ? AND accepted_at IS NULL", hashToken(req.Token)).First(&invitation).Error
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Invalid invitation link")
+		return
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		writeError(w, http.StatusGone, "Invitation has expired")
+		return
+	}
+
+	taken, err := app.usernameTaken(req.Username, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if taken {
+		writeError(w, http.StatusConflict, ErrUsernameTaken)
+		return
+	}
+	if !app.ensureMailAddressAvailable(w, invitation.MailAddress, 0) {
+		return
+	}
+
+	hashedPassword, err := app.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrHashingPassword)
+		return
+	}
+
+	user := User{
+		Username:    req.Username,
+		MailAddress: invitation.MailAddress,
+		Password:    hashedPassword,
+		Role:        invitation.Role,
+		Activated:   true,
+	}
+	if invitation.OrganizationID != nil {
+		// The organization role is carried by the membership
+		user.Role = RoleCustomer
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if invitation.OrganizationID != nil {
+			membership := Membership{OrganizationID: *invitation.OrganizationID, UserID: user.ID, Role: invitation.Role}
+			if err := tx.Create(&membership).Error; err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		invitation.AcceptedAt = &now
+		invitation.UserID = &user.ID
+		return tx.Save(&invitation).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		writeError(w, http.StatusConflict, "User already exists")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrInsertingUser)
+		return
+	}
+
+	session, err := app.issueToken(&user, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/users/%d", user.ID))
+	response := map[string]interface{}{
+		"user":  app.userResponseIn(session.OrganizationID, user),
+		"token": session.Token,
+	}
+	if session.OrganizationID != 0 {
+		response["organizationId"] = session.OrganizationID
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
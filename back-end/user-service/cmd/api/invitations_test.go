@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Inviting an address again only replaces its pending invitation into the same organization
+func TestCreateInvitationReplacesOwnPending(t *testing.T) {
+	app := newTestApp(t)
+	platformAdmin := createTestUser(t, app, "platform", RoleAdmin, true)
+	orgAdmin := createTestUser(t, app, "orgadmin", RoleCustomer, true)
+	createTestMembership(t, app, 1, orgAdmin, RoleAdmin)
+
+	orgOne, orgTwo := uint(1), uint(2)
+	pending := map[string]*uint{"global": nil, "org1": &orgOne, "org2": &orgTwo}
+	for hash, organizationID := range pending {
+		invitation := Invitation{MailAddress: "invitee@example.com", Role: RoleCustomer, OrganizationID: organizationID, TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := app.DB.Create(&invitation).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	remaining := func() map[string]bool {
+		var invitations []Invitation
+		if err := app.DB.Find(&invitations).Error; err != nil {
+			t.Fatal(err)
+		}
+		found := map[string]bool{}
+		for _, invitation := range invitations {
+			found[invitation.TokenHash] = true
+		}
+		return found
+	}
+	invite := func(token string) {
+		// No mail service in tests: the new invitation is dropped again after the replacement
+		rec := serve(app, http.MethodPost, "/v1/invitations", token, map[string]string{"mailAddress": "Invitee@example.com", "role": RoleCustomer})
+		if rec.Code != http.StatusBadGateway {
+			t.Fatalf("expected 502 without a mail service, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	invite(tokenFor(t, app, orgAdmin, 1))
+	if found := remaining(); found["org1"] || !found["global"] || !found["org2"] {
+		t.Fatalf("expected only the invitation into organization 1 to be replaced, got %v", found)
+	}
+
+	invite(tokenFor(t, app, platformAdmin, 0))
+	if found := remaining(); found["global"] || !found["org2"] {
+		t.Fatalf("expected only the invitation without organization to be replaced, got %v", found)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// mailServiceClient is used for all calls from user-service to mail-service
+var mailServiceClient = &http.Client{Timeout: 10 * time.Second}
+
+const mailServiceAttempts = 3 // Tries of a /v1/send call, retries reuse its idempotency key
+
+// sendMail asks mail-service to mail a template to one recipient. The
+// idempotency key names this one mail, so however often the call is retried
+// mail-service queues it once; data fields are the template's.
+func sendMail(template, to string, data map[string]interface{}, idempotencyKey string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"template":       template,
+		"to":             []string{to},
+		"data":           data,
+		"idempotencyKey": idempotencyKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := postToMailService("/v1/send", body)
+		if err == nil || !retry || attempt == mailServiceAttempts {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+	}
+}
+
+// postToMailService POSTs a JSON body to the given mail-service path with the
+// client credentials of USER_SERVICE_MAIL_SERVICE_CREDENTIALS. On failure it
+// also reports whether the call may succeed when retried.
+func postToMailService(path string, body []byte) (bool, error) {
+	if MailServiceURL == "" {
+		return false, errors.New("USER_SERVICE_MAIL_SERVICE_URL is not set")
+	}
+
+	url := strings.TrimSuffix(MailServiceURL, "/") + path
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if clientID, secret, ok := strings.Cut(MailServiceCredentials, ":"); ok {
+		req.SetBasicAuth(clientID, secret)
+	}
+
+	resp, err := mailServiceClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode >= 500, fmt.Errorf("mail-service %s returned %d: %s", path, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return false, nil
+}
+
+// appLink builds an absolute link into the web app, e.g. appLink("/confirm-email", token)
+func appLink(path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimSuffix(AppURL, "/"), path, token)
+}
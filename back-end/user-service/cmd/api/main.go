@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 )
 
@@ -17,9 +18,33 @@ func main() {
 		log.Fatalf("❌ Database connection failed : %v", err)
 	}
 
+	limiter, err := newRateLimiter()
+	if err != nil {
+		log.Fatalf("❌ Rate limit store failed : %v", err)
+	}
+
+	app := &Config{DB: db, Limiter: limiter}
+
+	// The internal gRPC API runs alongside the HTTP server
+	if GRPCPort != "" {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%s", GRPCPort))
+		if err != nil {
+			log.Fatalf("❌ gRPC listener failed : %v", err)
+		}
+		go func() {
+			fmt.Printf("🚀 %s gRPC API is running on port: %s\n", ServiceName, GRPCPort)
+			if err := app.newGRPCServer().Serve(listener); err != nil {
+				log.Fatalf("❌ gRPC server failed : %v", err)
+			}
+		}()
+	}
+
+	// Delivers the lifecycle events recorded in the outbox to webhook subscriptions
+	go app.runWebhookDispatcher()
+
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", ServicePort),
-		Handler: (&Config{DB: db}).routes(), // Pass db to routes
+		Handler: app.routes(), // Pass db to routes
 	}
 
 	err = srv.ListenAndServe()
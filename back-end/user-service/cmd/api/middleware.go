@@ -1,22 +1,31 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+
+	"shared/ratelimit"
 )
 
 // SetupMiddleware sets up all global middleware
 func (app *Config) SetupMiddleware(mux *chi.Mux) {
+	if BehindProxy == "true" {
+		mux.Use(middleware.RealIP)
+	}
 	mux.Use(app.CORSMiddleware())
 	mux.Use(middleware.Heartbeat("/ping"))
 	mux.Use(middleware.Recoverer)
 	mux.Use(MetricsMiddleware)
 	mux.Use(middleware.Logger)
+	mux.Use(app.RateLimit(rateLimitIP, ratelimit.ByIP))
+	mux.Use(app.OpenAPIMiddleware())
 
 }
 
@@ -27,10 +36,46 @@ func (app *Config) CORSMiddleware() func(http.Handler) http.Handler {
 
 	return cors.Handler(cors.Options{
 		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "Location", "Deprecation", "Sunset", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	})
 }
+
+// Legacy (pre-/v1) routes were deprecated on legacyDeprecatedAt and are removed after legacySunsetAt
+var (
+	legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	legacySunsetAt     = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// DeprecatedMiddleware marks a legacy route as deprecated (RFC 9745 / RFC 8594)
+// and points clients to its /v1 successor
+func DeprecatedMiddleware(successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecatedAt.Unix()))
+			w.Header().Set("Sunset", legacySunsetAt.Format(http.TimeFormat))
+			w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole only lets through requests whose JWT carries one of roles.
+// It must run after AuthMiddleware, which sets X-Role from the token.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := canonicalRole(r.Header.Get("X-Role"))
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			writeError(w, http.StatusForbidden, "Insufficient permissions")
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"shared/identifier"
+)
+
+// migrateNormalizedIdentifiers backfills the normalized username and mail address
+// columns and creates their unique indexes. Accounts that only differ by case (or
+// Turkish dotted/dotless I) are reported; the affected index is not created until
+// they have been merged or renamed, so the service keeps running in the meantime.
+func migrateNormalizedIdentifiers(db *gorm.DB) error {
+	var users []User
+	err := db.Select("id", "username", "mail_address", "username_normalized", "mail_address_normalized").
+		Order("id").Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	usernames := map[string][]uint{}
+	mailAddresses := map[string][]uint{}
+	for _, user := range users {
+		username := identifier.Normalize(user.Username)
+		mailAddress := identifier.Normalize(user.MailAddress)
+		usernames[username] = append(usernames[username], user.ID)
+		mailAddresses[mailAddress] = append(mailAddresses[mailAddress], user.ID)
+
+		if user.UsernameNormalized == username && user.MailAddressNormalized == mailAddress {
+			continue
+		}
+		err := db.Model(&User{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+			"username_normalized":     username,
+			"mail_address_normalized": mailAddress,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := createUniqueIndexUnlessDuplicated(db, "idx_users_username_normalized", "username_normalized", usernames); err != nil {
+		return err
+	}
+	return createUniqueIndexUnlessDuplicated(db, "idx_users_mail_address_normalized", "mail_address_normalized", mailAddresses)
+}
+
+// createUniqueIndexUnlessDuplicated creates a unique index on column, or reports the
+// conflicting user IDs when existing rows would violate it
+func createUniqueIndexUnlessDuplicated(db *gorm.DB, index, column string, values map[string][]uint) error {
+	duplicates := 0
+	for value, ids := range values {
+		if len(ids) > 1 {
+			duplicates++
+			log.Printf("❌ Duplicate %s %q shared by user IDs %s", column, value, joinIDs(ids))
+		}
+	}
+	if duplicates > 0 {
+		log.Printf("⚠️ Skipping unique index %s: resolve the %d duplicate(s) above and restart", index, duplicates)
+		return nil
+	}
+
+	return db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON users (%s)", index, column)).Error
+}
+
+func joinIDs(ids []uint) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ", ")
+}
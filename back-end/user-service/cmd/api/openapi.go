@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// OpenAPI 3 document describing every route of this service
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// loadOpenAPISpec parses and validates the embedded OpenAPI document
+func loadOpenAPISpec() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// OpenAPIHandler serves the raw OpenAPI document
+func (app *Config) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
+
+// OpenAPIMiddleware rejects requests that do not match the OpenAPI document.
+// When app.ValidateResponses is set (tests), responses are checked as well and
+// a mismatch is turned into a 500 so it cannot go unnoticed.
+func (app *Config) OpenAPIMiddleware() func(http.Handler) http.Handler {
+	doc, err := loadOpenAPISpec()
+	if err != nil {
+		log.Fatalf("❌ Invalid OpenAPI document : %v", err)
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		log.Fatalf("❌ Failed to build OpenAPI router : %v", err)
+	}
+
+	options := &openapi3filter.Options{
+		// Authentication is enforced by AuthMiddleware, not by the validator
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+		MultiError:            true,
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, pathParams, err := router.FindRoute(r)
+			if err != nil {
+				// Unknown path or method: let chi answer with 404/405
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+			if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+				if fields := schemaFieldErrors(err); len(fields) > 0 {
+					writeValidationError(w, fields)
+					return
+				}
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			if !app.ValidateResponses {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &bufferedResponse{header: http.Header{}, statusCode: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if err := validateResponse(r.Context(), input, rec); err != nil {
+				writeError(w, http.StatusInternalServerError, "Response does not match OpenAPI document: "+err.Error())
+				return
+			}
+			rec.flush(w)
+		})
+	}
+}
+
+// validateResponse checks a buffered response against the matched operation
+func validateResponse(ctx context.Context, input *openapi3filter.RequestValidationInput, rec *bufferedResponse) error {
+	if rec.header.Get("Content-Type") == "" && rec.body.Len() > 0 {
+		rec.header.Set("Content-Type", http.DetectContentType(rec.body.Bytes()))
+	}
+	return openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 rec.statusCode,
+		Header:                 rec.header,
+		Body:                   io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+		Options:                input.Options,
+	})
+}
+
+// bufferedResponse holds a response in memory until it has been validated
+type bufferedResponse struct {
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) WriteHeader(code int) { b.statusCode = code }
+
+// flush copies the buffered response to the real ResponseWriter
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(b.statusCode)
+	w.Write(b.body.Bytes())
+}
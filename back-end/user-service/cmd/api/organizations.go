@@ -0,0 +1,423 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+var errNotMember = errors.New("user is not a member of the organization")
+
+// Organization is one business using the platform. Users belong to organizations
+// through memberships, each with its own role.
+type Organization struct {
+	ID        uint      `gorm:"primaryKey"`
+	Name      string    `gorm:"not null"`
+	Slug      string    `gorm:"uniqueIndex;not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// Membership gives a user a role within an organization
+type Membership struct {
+	ID             uint      `gorm:"primaryKey"`
+	OrganizationID uint      `gorm:"not null;uniqueIndex:idx_memberships_organization_user"`
+	UserID         uint      `gorm:"not null;uniqueIndex:idx_memberships_organization_user;index"`
+	Role           string    `gorm:"not null"`
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+
+	storedRole string `gorm:"-"` // Role before the current update, see BeforeUpdate
+}
+
+// AfterDelete removes the memberships and team assignments of a deleted user and records user.deleted
+func (u *User) AfterDelete(tx *gorm.DB) error {
+	if err := recordEvent(tx, EventUserDeleted, userEventData{User: newUserResponse(*u)}); err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", u.ID).Delete(&Membership{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", u.ID).Delete(&TeamMember{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&Team{}).Where("manager_id = ?", u.ID).Update("manager_id", nil).Error
+}
+
+// OrganizationResponse is the API representation of an Organization
+type OrganizationResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newOrganizationResponse(organization Organization) OrganizationResponse {
+	return OrganizationResponse{
+		ID:        organization.ID,
+		Name:      organization.Name,
+		Slug:      organization.Slug,
+		CreatedAt: organization.CreatedAt,
+	}
+}
+
+type createOrganizationRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+	Slug string `json:"slug" validate:"required,slug"`
+}
+
+type putMemberRequest struct {
+	Role string `json:"role" validate:"required,role"`
+}
+
+// callerOrganizationID returns the organization carried in the request's token, or 0 if there is none
+func callerOrganizationID(r *http.Request) uint {
+	id, _ := strconv.ParseUint(r.Header.Get("X-Organization-ID"), 10, 64)
+	return uint(id)
+}
+
+// isPlatformAdmin reports whether the caller is an admin not bound to any organization
+func isPlatformAdmin(r *http.Request) bool {
+	return callerOrganizationID(r) == 0 && canonicalRole(r.Header.Get("X-Role")) == RoleAdmin
+}
+
+// canManageOrganization reports whether the caller may administer organizationID
+func canManageOrganization(r *http.Request, organizationID uint) bool {
+	if isPlatformAdmin(r) {
+		return true
+	}
+	return callerOrganizationID(r) == organizationID && canonicalRole(r.Header.Get("X-Role")) == RoleAdmin
+}
+
+// scopeUsers restricts a users query to the members of the caller's organization.
+// Tokens without an organization keep the unscoped, single-tenant behaviour.
+func scopeUsers(r *http.Request, db *gorm.DB) *gorm.DB {
+	if organizationID := callerOrganizationID(r); organizationID != 0 {
+		return db.Where("users.id IN (SELECT user_id FROM memberships WHERE organization_id = ?)", organizationID)
+	}
+	return db
+}
+
+// findScopedUserByUsername is findUserByUsername limited to the caller's organization
+func (app *Config) findScopedUserByUsername(r *http.Request, username string) (*User, error) {
+	user, err := app.findUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if organizationID := callerOrganizationID(r); organizationID != 0 {
+		if _, err := app.membershipRole(organizationID, user.ID); err != nil {
+			return nil, gorm.ErrRecordNotFound
+		}
+	}
+	return user, nil
+}
+
+// membershipRole returns the role of userID in organizationID, or errNotMember
+func (app *Config) membershipRole(organizationID, userID uint) (string, error) {
+	var membership Membership
+	err := app.DB.Where("organization_id = ? AND user_id = ?", organizationID, userID).First(&membership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", errNotMember
+	}
+	return membership.Role, err
+}
+
+// memberElsewhere reports whether userID also belongs to an organization other than organizationID
+func (app *Config) memberElsewhere(organizationID, userID uint) (bool, error) {
+	var count int64
+	err := app.DB.Model(&Membership{}).Where("user_id = ? AND organization_id <> ?", userID, organizationID).Count(&count).Error
+	return count > 0, err
+}
+
+// ensureGlobalChangeAllowed writes a 403 and returns false when the caller acts in an
+// organization and user, someone else, also belongs to another one. Passwords, mail
+// addresses, usernames and activation apply in every organization of a user, so an
+// organization admin cannot change them for members shared with other organizations.
+func (app *Config) ensureGlobalChangeAllowed(w http.ResponseWriter, r *http.Request, user *User) bool {
+	organizationID := callerOrganizationID(r)
+	if organizationID == 0 {
+		return true
+	}
+	if caller, err := app.callerUser(r); err == nil && caller.ID == user.ID {
+		return true
+	}
+
+	elsewhere, err := app.memberElsewhere(organizationID, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return false
+	}
+	if elsewhere {
+		writeError(w, http.StatusForbidden, "User also belongs to another organization")
+		return false
+	}
+	return true
+}
+
+// removeMembership takes userID out of organizationID and out of the teams of that
+// organization, and returns the number of memberships removed
+func removeMembership(tx *gorm.DB, organizationID, userID uint) (int64, error) {
+	err := tx.Where("user_id = ? AND team_id IN (SELECT id FROM teams WHERE organization_id = ?)", userID, organizationID).
+		Delete(&TeamMember{}).Error
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Model(&Team{}).Where("organization_id = ? AND manager_id = ?", organizationID, userID).Update("manager_id", nil).Error
+	if err != nil {
+		return 0, err
+	}
+	result := tx.Where("organization_id = ? AND user_id = ?", organizationID, userID).Delete(&Membership{})
+	return result.RowsAffected, result.Error
+}
+
+// userResponse is newUserResponse with the role the user has in the caller's organization
+func (app *Config) userResponse(r *http.Request, user User) UserResponse {
+	return app.userResponseIn(callerOrganizationID(r), user)
+}
+
+// userResponseIn is newUserResponse with the role the user has in organizationID (0 for the global role)
+func (app *Config) userResponseIn(organizationID uint, user User) UserResponse {
+	response := newUserResponse(user)
+	if organizationID != 0 {
+		if role, err := app.membershipRole(organizationID, user.ID); err == nil {
+			response.Role = role
+		}
+	}
+	return response
+}
+
+// assignRole sets the role of user in the caller's organization, or the global
+// role for callers without one. The global role is only set on user; the caller saves it.
+func (app *Config) assignRole(r *http.Request, user *User, role string) error {
+	if organizationID := callerOrganizationID(r); organizationID != 0 {
+		return setMembershipRole(app.DB, organizationID, user.ID, role)
+	}
+	user.Role = role
+	return nil
+}
+
+// session describes the token issued to a user
+type session struct {
+	Token          string
+	Role           string
+	OrganizationID uint // 0 when the user belongs to no organization
+}
+
+// issueToken creates a JWT for user in organizationID. With organizationID 0 the
+// user's first organization is used; users without any get their global role.
+func (app *Config) issueToken(user *User, organizationID uint) (*session, error) {
+	return app.issueTokenAs(user.Username, user, organizationID)
+}
+
+// issueTokenAs is issueToken with an explicit username claim (legacy logins put the mail address there)
+func (app *Config) issueTokenAs(username string, user *User, organizationID uint) (*session, error) {
+	var memberships []Membership
+	if err := app.DB.Where("user_id = ?", user.ID).Order("id").Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+
+	s := &session{Role: user.Role}
+	for _, membership := range memberships {
+		if organizationID == 0 || membership.OrganizationID == organizationID {
+			s.Role = membership.Role
+			s.OrganizationID = membership.OrganizationID
+			break
+		}
+	}
+	if organizationID != 0 && s.OrganizationID == 0 {
+		return nil, errNotMember
+	}
+
+	token, err := GenerateOrgJWT(username, s.Role, s.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	s.Token = token
+	return s, nil
+}
+
+// organizationFromPath loads the organization referenced by the {orgID} URL parameter.
+// On failure it writes the error response and returns false.
+func (app *Config) organizationFromPath(w http.ResponseWriter, r *http.Request) (*Organization, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "orgID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid organization id")
+		return nil, false
+	}
+	if !canManageOrganization(r, uint(id)) {
+		writeError(w, http.StatusNotFound, "Organization not found")
+		return nil, false
+	}
+
+	var organization Organization
+	if err := app.DB.First(&organization, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "Organization not found")
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	return &organization, true
+}
+
+// CreateOrganizationHandler handles POST /v1/organizations (platform admins only)
+func (app *Config) CreateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	if !isPlatformAdmin(r) {
+		writeError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+
+	var req createOrganizationRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	organization := Organization{Name: req.Name, Slug: req.Slug}
+	if err := app.DB.Create(&organization).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			writeError(w, http.StatusConflict, "Organization slug already in use")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to create organization")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/organizations/%d", organization.ID))
+	writeJSON(w, http.StatusCreated, newOrganizationResponse(organization))
+}
+
+// ListOrganizationsHandler handles GET /v1/organizations. Platform admins see
+// every organization, everybody else the organization of their token.
+func (app *Config) ListOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	query := app.DB.Order("name")
+	if !isPlatformAdmin(r) {
+		query = query.Where("id = ?", callerOrganizationID(r))
+	}
+
+	var organizations []Organization
+	if err := query.Find(&organizations).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	response := make([]OrganizationResponse, 0, len(organizations))
+	for _, organization := range organizations {
+		response = append(response, newOrganizationResponse(organization))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// ListMembersHandler handles GET /v1/organizations/{orgID}/members
+func (app *Config) ListMembersHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.organizationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var memberships []Membership
+	if err := app.DB.Where("organization_id = ?", organization.ID).Find(&memberships).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	roles := make(map[uint]string, len(memberships))
+	ids := make([]uint, 0, len(memberships))
+	for _, membership := range memberships {
+		roles[membership.UserID] = membership.Role
+		ids = append(ids, membership.UserID)
+	}
+
+	var users []User
+	if len(ids) > 0 {
+		if err := app.DB.Where("id IN ?", ids).Order("username").Find(&users).Error; err != nil {
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+	}
+
+	response := make([]UserResponse, 0, len(users))
+	for _, user := range users {
+		member := newUserResponse(user)
+		member.Role = roles[user.ID]
+		response = append(response, member)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// PutMemberHandler handles PUT /v1/organizations/{orgID}/members/{userID}. Organization
+// admins may change the role of their members; only platform admins may add users.
+func (app *Config) PutMemberHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.organizationFromPath(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var req putMemberRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	var user User
+	if err := app.DB.First(&user, userID).Error; err != nil {
+		writeError(w, http.StatusNotFound, ErrUserNotFound)
+		return
+	}
+
+	membership := Membership{OrganizationID: organization.ID, UserID: user.ID}
+	err = app.DB.Where(&membership).First(&membership).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound) && !isPlatformAdmin(r):
+		writeError(w, http.StatusNotFound, ErrUserNotFound)
+		return
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	membership.Role = canonicalRole(req.Role)
+	if err := app.DB.Save(&membership).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update membership")
+		return
+	}
+
+	response := newUserResponse(user)
+	response.Role = membership.Role
+	writeJSON(w, http.StatusOK, response)
+}
+
+// DeleteMemberHandler handles DELETE /v1/organizations/{orgID}/members/{userID}
+func (app *Config) DeleteMemberHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.organizationFromPath(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var removed int64
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		removed, err = removeMembership(tx, organization.ID, uint(userID))
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to remove member")
+		return
+	}
+	if removed == 0 {
+		writeError(w, http.StatusNotFound, ErrUserNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tenants holds the users of a two-organization setup
+type tenants struct {
+	platformAdmin, orgAdmin, member, other, shared *User
+}
+
+// newTenants creates a platform admin, an admin and a member of organization 1,
+// a member of organization 2 and a user belonging to both
+func newTenants(t *testing.T, app *Config) tenants {
+	t.Helper()
+	ts := tenants{
+		platformAdmin: createTestUser(t, app, "platform", RoleAdmin, true),
+		orgAdmin:      createTestUser(t, app, "orgadmin", RoleCustomer, true),
+		member:        createTestUser(t, app, "member", RoleCustomer, true),
+		other:         createTestUser(t, app, "other", RoleCustomer, true),
+		shared:        createTestUser(t, app, "shared", RoleCustomer, true),
+	}
+	createTestMembership(t, app, 1, ts.orgAdmin, RoleAdmin)
+	createTestMembership(t, app, 1, ts.member, RoleCustomer)
+	createTestMembership(t, app, 2, ts.other, RoleCustomer)
+	createTestMembership(t, app, 1, ts.shared, RoleCustomer)
+	createTestMembership(t, app, 2, ts.shared, RoleManager)
+	return ts
+}
+
+// Organization admins only reach the members of their organization, and cannot
+// change global fields of members shared with another one; platform admins reach everyone
+func TestTenantIsolation(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTenants(t, app)
+	orgAdmin := tokenFor(t, app, ts.orgAdmin, 1)
+	platformAdmin := tokenFor(t, app, ts.platformAdmin, 0)
+	path := func(user *User, suffix string) string { return fmt.Sprintf("/v1/users/%d%s", user.ID, suffix) }
+	newPassword := map[string]string{"password": "Changed123!"}
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"org admin views member", orgAdmin, http.MethodGet, path(ts.member, ""), nil, http.StatusOK},
+		{"org admin views other org", orgAdmin, http.MethodGet, path(ts.other, ""), nil, http.StatusNotFound},
+		{"org admin patches other org", orgAdmin, http.MethodPatch, path(ts.other, ""), map[string]string{"role": RoleManager}, http.StatusNotFound},
+		{"org admin deletes other org", orgAdmin, http.MethodDelete, path(ts.other, ""), nil, http.StatusNotFound},
+		{"org admin deactivates other org", orgAdmin, http.MethodDelete, path(ts.other, "/activation"), nil, http.StatusNotFound},
+		{"org admin sets password of shared", orgAdmin, http.MethodPut, path(ts.shared, "/password"), newPassword, http.StatusForbidden},
+		{"org admin deactivates shared", orgAdmin, http.MethodDelete, path(ts.shared, "/activation"), nil, http.StatusForbidden},
+		{"org admin renames shared", orgAdmin, http.MethodPut, path(ts.shared, "/username"), map[string]string{"username": "renamed"}, http.StatusForbidden},
+		{"org admin sets role of shared", orgAdmin, http.MethodPut, path(ts.shared, "/role"), map[string]string{"role": RoleManager}, http.StatusOK},
+		{"org admin sets password of member", orgAdmin, http.MethodPut, path(ts.member, "/password"), newPassword, http.StatusNoContent},
+		{"platform admin views other org", platformAdmin, http.MethodGet, path(ts.other, ""), nil, http.StatusOK},
+		{"platform admin views shared", platformAdmin, http.MethodGet, path(ts.shared, ""), nil, http.StatusOK},
+		{"platform admin sets password of shared", platformAdmin, http.MethodPut, path(ts.shared, "/password"), newPassword, http.StatusNoContent},
+		{"platform admin deletes other org", platformAdmin, http.MethodDelete, path(ts.other, ""), nil, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		rec := serve(app, tt.method, tt.path, tt.token, tt.body)
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	// The role set by the org admin only applies in organization 1
+	var shared User
+	if err := app.DB.First(&shared, ts.shared.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if shared.Role != RoleCustomer {
+		t.Errorf("expected the global role to be kept, got %s", shared.Role)
+	}
+	if role, _ := app.membershipRole(2, ts.shared.ID); role != RoleManager {
+		t.Errorf("expected the role in organization 2 to be kept, got %s", role)
+	}
+}
+
+// Exports list the members of the caller's organization, or everyone for platform admins
+func TestExportScope(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTenants(t, app)
+
+	tests := []struct {
+		name    string
+		token   string
+		visible []*User
+		hidden  []*User
+	}{
+		{"org admin", tokenFor(t, app, ts.orgAdmin, 1), []*User{ts.orgAdmin, ts.member, ts.shared}, []*User{ts.platformAdmin, ts.other}},
+		{"platform admin", tokenFor(t, app, ts.platformAdmin, 0), []*User{ts.platformAdmin, ts.orgAdmin, ts.member, ts.other, ts.shared}, nil},
+	}
+	for _, tt := range tests {
+		rec := serve(app, http.MethodGet, "/v1/users/export?format=json", tt.token, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", tt.name, rec.Code, rec.Body.String())
+		}
+		for _, user := range tt.visible {
+			if !strings.Contains(rec.Body.String(), user.MailAddress) {
+				t.Errorf("%s: expected %s in the export", tt.name, user.Username)
+			}
+		}
+		for _, user := range tt.hidden {
+			if strings.Contains(rec.Body.String(), user.MailAddress) {
+				t.Errorf("%s: expected no %s in the export", tt.name, user.Username)
+			}
+		}
+	}
+}
+
+// Scoped queries only return the members of the caller's organization
+func TestScopeUsers(t *testing.T) {
+	app := newTestApp(t)
+	newTenants(t, app)
+
+	tests := []struct {
+		organizationID uint
+		want           int
+	}{
+		{0, 5},
+		{1, 3},
+		{2, 2},
+		{3, 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.organizationID != 0 {
+			r.Header.Set("X-Organization-ID", strconv.FormatUint(uint64(tt.organizationID), 10))
+		}
+		var users []User
+		if err := scopeUsers(r, app.DB).Find(&users).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != tt.want {
+			t.Errorf("organization %d: expected %d users, got %d", tt.organizationID, tt.want, len(users))
+		}
+	}
+}
+
+// Tokens carry the role of the organization they were issued for, the first one by default
+func TestIssueTokenAs(t *testing.T) {
+	app := newTestApp(t)
+	ts := newTenants(t, app)
+
+	tests := []struct {
+		name           string
+		user           *User
+		organizationID uint
+		wantRole       string
+		wantOrg        uint
+		wantErr        error
+	}{
+		{"no membership", ts.platformAdmin, 0, RoleAdmin, 0, nil},
+		{"first membership", ts.shared, 0, RoleCustomer, 1, nil},
+		{"chosen membership", ts.shared, 2, RoleManager, 2, nil},
+		{"org admin", ts.orgAdmin, 1, RoleAdmin, 1, nil},
+		{"not a member", ts.member, 2, "", 0, errNotMember},
+		{"platform admin in an organization", ts.platformAdmin, 1, "", 0, errNotMember},
+	}
+	for _, tt := range tests {
+		session, err := app.issueTokenAs(tt.user.MailAddress, tt.user, tt.organizationID)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if session.Role != tt.wantRole || session.OrganizationID != tt.wantOrg {
+			t.Errorf("%s: expected %s in %d, got %s in %d", tt.name, tt.wantRole, tt.wantOrg, session.Role, session.OrganizationID)
+		}
+		// The token is accepted with the username claim it was issued for
+		response, _, err := app.introspect(session.Token)
+		if err != nil || !response.Active || response.Role != tt.wantRole || response.OrganizationID != tt.wantOrg {
+			t.Errorf("%s: expected an active token, got %+v, %v", tt.name, response, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// User lifecycle events delivered to webhook subscriptions
+const (
+	EventUserCreated     = "user.created"
+	EventUserActivated   = "user.activated"
+	EventUserDeactivated = "user.deactivated"
+	EventUserRoleChanged = "user.role_changed"
+	EventUserDeleted     = "user.deleted"
+)
+
+// OutboxEvent is a lifecycle event written in the same transaction as the change
+// it describes. The webhook dispatcher fans it out to the subscriptions later, so
+// an event is never lost nor sent for a change that was rolled back.
+type OutboxEvent struct {
+	ID           uint       `gorm:"primaryKey"`
+	Type         string     `gorm:"not null"`
+	Data         string     `gorm:"type:jsonb;not null"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime"`
+	DispatchedAt *time.Time `gorm:"index"`
+}
+
+// userEventData is the data of a user lifecycle event
+type userEventData struct {
+	User           UserResponse `json:"user"`
+	OrganizationID uint         `json:"organizationId,omitempty"`
+	PreviousRole   string       `json:"previousRole,omitempty"`
+}
+
+// recordEvent adds an event to the outbox within tx
+func recordEvent(tx *gorm.DB, eventType string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&OutboxEvent{Type: eventType, Data: string(encoded)}).Error
+}
+
+// AfterCreate records user.created
+func (u *User) AfterCreate(tx *gorm.DB) error {
+	return recordEvent(tx, EventUserCreated, userEventData{User: newUserResponse(*u)})
+}
+
+// BeforeUpdate remembers the stored role and activation so AfterUpdate can tell what changed
+func (u *User) BeforeUpdate(tx *gorm.DB) error {
+	if u.ID == 0 {
+		return nil
+	}
+	var stored User
+	err := tx.Session(&gorm.Session{NewDB: true}).Select("id", "role", "activated").Take(&stored, u.ID).Error
+	if err != nil {
+		return err
+	}
+	u.stored = &stored
+	return nil
+}
+
+// AfterUpdate records user.activated, user.deactivated and user.role_changed
+func (u *User) AfterUpdate(tx *gorm.DB) error {
+	stored := u.stored
+	u.stored = nil
+	if stored == nil {
+		return nil
+	}
+	if u.Activated != stored.Activated {
+		eventType := EventUserDeactivated
+		if u.Activated {
+			eventType = EventUserActivated
+		}
+		if err := recordEvent(tx, eventType, userEventData{User: newUserResponse(*u)}); err != nil {
+			return err
+		}
+	}
+	if u.Role != stored.Role {
+		return recordEvent(tx, EventUserRoleChanged, userEventData{User: newUserResponse(*u), PreviousRole: stored.Role})
+	}
+	return nil
+}
+
+// setMembershipRole changes the role of userID in organizationID within tx, recording user.role_changed
+func setMembershipRole(tx *gorm.DB, organizationID, userID uint, role string) error {
+	var membership Membership
+	if err := tx.Where("organization_id = ? AND user_id = ?", organizationID, userID).First(&membership).Error; err != nil {
+		return err
+	}
+	membership.Role = role
+	return tx.Save(&membership).Error
+}
+
+// BeforeUpdate remembers the stored role so AfterUpdate can tell whether it changed
+func (m *Membership) BeforeUpdate(tx *gorm.DB) error {
+	if m.ID == 0 {
+		return nil
+	}
+	var stored Membership
+	err := tx.Session(&gorm.Session{NewDB: true}).Select("id", "role").Take(&stored, m.ID).Error
+	if err != nil {
+		return err
+	}
+	m.storedRole = stored.Role
+	return nil
+}
+
+// AfterUpdate records user.role_changed for a role change within an organization
+func (m *Membership) AfterUpdate(tx *gorm.DB) error {
+	previousRole := m.storedRole
+	m.storedRole = ""
+	if previousRole == "" || previousRole == m.Role {
+		return nil
+	}
+	var user User
+	if err := tx.Session(&gorm.Session{NewDB: true}).Take(&user, m.UserID).Error; err != nil {
+		return err
+	}
+	response := newUserResponse(user)
+	response.Role = m.Role
+	return recordEvent(tx, EventUserRoleChanged, userEventData{User: response, OrganizationID: m.OrganizationID, PreviousRole: previousRole})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// passwordSetupTTL is how long the link in an account invitation or password reset mail is valid
+const passwordSetupTTL = 7 * 24 * time.Hour
+
+// PasswordSetup lets a user created by an admin choose their own password
+type PasswordSetup struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+type completePasswordSetupRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+// sendAccountInvitation creates a password setup link for user and mails it to them.
+// Earlier unused links of the user are discarded.
+func (app *Config) sendAccountInvitation(user *User) error {
+	return app.sendPasswordSetup(user, "account-invitation", map[string]interface{}{
+		"Username": user.Username,
+		"Role":     user.Role,
+	})
+}
+
+// sendPasswordReset mails user a link to choose a new password after theirs was reset
+func (app *Config) sendPasswordReset(user *User) error {
+	return app.sendPasswordSetup(user, "password-reset", map[string]interface{}{
+		"Username": user.Username,
+	})
+}
+
+// sendPasswordSetup creates a password setup link for user and mails it with the
+// given template, which gets SetupURL and ExpiresAt in addition to data
+func (app *Config) sendPasswordSetup(user *User, template string, data map[string]interface{}) error {
+	token, hash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	setup := PasswordSetup{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(passwordSetupTTL),
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&PasswordSetup{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&setup).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	data["SetupURL"] = appLink("/set-password", token)
+	data["ExpiresAt"] = setup.ExpiresAt
+	if err := sendMail(template, user.MailAddress, data, template+"/"+hash); err != nil {
+		app.DB.Delete(&setup)
+		return err
+	}
+	return nil
+}
+
+// CompletePasswordSetupHandler handles POST /v1/password-setups/complete with the token from an invitation mail
+func (app *Config) CompletePasswordSetupHandler(w http.ResponseWriter, r *http.Request) {
+	var req completePasswordSetupRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	var setup PasswordSetup
+	err := app.DB.Where("token_hash = ? AND used_at IS NULL", hashToken(req.Token)).First(&setup).Error
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Invalid password setup link")
+		return
+	}
+	if time.Now().After(setup.ExpiresAt) {
+		writeError(w, http.StatusGone, "Password setup link has expired")
+		return
+	}
+
+	var user User
+	if err := app.DB.First(&user, setup.UserID).Error; err != nil {
+		writeError(w, http.StatusNotFound, ErrUserNotFound)
+		return
+	}
+
+	hashedPassword, err := app.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrHashingPassword)
+		return
+	}
+
+	now := time.Now()
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		user.Password = hashedPassword
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		setup.UsedAt = &now
+		return tx.Save(&setup).Error
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to set password")
+		return
+	}
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
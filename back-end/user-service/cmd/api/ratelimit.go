@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"shared/ratelimit"
+)
+
+// Names of the rate limits, as used in USER_SERVICE_RATE_LIMITS
+const (
+	rateLimitIP       = "ip"       // All requests, per client IP
+	rateLimitUser     = "user"     // Authenticated requests, per user
+	rateLimitRegister = "register" // Registrations, per client IP
+	rateLimitLogin    = "login"    // Login attempts, per client IP
+)
+
+// defaultRateLimits apply unless overridden in USER_SERVICE_RATE_LIMITS
+var defaultRateLimits = map[string]ratelimit.Limit{
+	rateLimitIP:       {Requests: 300, Period: time.Minute},
+	rateLimitUser:     {Requests: 300, Period: time.Minute},
+	rateLimitRegister: {Requests: 5, Period: time.Hour},
+	rateLimitLogin:    {Requests: 10, Period: time.Minute},
+}
+
+// rateLimits are the configured limits, e.g. USER_SERVICE_RATE_LIMITS="login=20/1m,user=off"
+var rateLimits, rateLimitsErr = ratelimit.ParseLimits(RateLimits, defaultRateLimits)
+
+// newRateLimiter opens the bucket store configured in USER_SERVICE_RATE_LIMIT_STORE
+func newRateLimiter() (*ratelimit.Limiter, error) {
+	store, err := ratelimit.OpenStore(RateLimitStore)
+	if err != nil {
+		return nil, err
+	}
+	return ratelimit.New(store, rateLimits), nil
+}
+
+// RateLimit limits requests under the limit called name, counted per key.
+// Without a limiter (tests) requests pass unchecked.
+func (app *Config) RateLimit(name string, key ratelimit.KeyFunc) func(http.Handler) http.Handler {
+	if app.Limiter == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return app.Limiter.Handler(name, key)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"shared/identifier"
+	"shared/verification"
+)
+
+// Self-registration modes, set with USER_SERVICE_SELF_REGISTRATION
+const (
+	registrationOpen    = "open"    // Anyone may register
+	registrationClosed  = "closed"  // Accounts are only created through invitations or imports
+	registrationDomains = "domains" // Only mail addresses of USER_SERVICE_SELF_REGISTRATION_DOMAINS may register
+)
+
+// signupPurpose is the mail-service verification purpose that proves a signup
+const signupPurpose = "signup"
+
+// selfRegistrableRoles are the roles a user may pick when registering without an invitation
+var selfRegistrableRoles = []string{RoleSalesRepresentative, RoleCustomer}
+
+// selfRegistrationError returns why mailAddress may not register itself with role, or "" if it may
+func selfRegistrationError(mailAddress, role string) string {
+	switch SelfRegistration {
+	case registrationClosed:
+		return "Registration is by invitation only"
+	case registrationDomains:
+		if !mailDomainAllowed(mailAddress) {
+			return "Registration is not open for this mail domain"
+		}
+	}
+
+	role = canonicalRole(role)
+	for _, allowed := range selfRegistrableRoles {
+		if role == allowed {
+			return ""
+		}
+	}
+	return fmt.Sprintf("The %s role can only be assigned through an invitation", role)
+}
+
+// signupVerified reports whether token, from POST /verify-auth-code of
+// mail-service, proves that mailAddress was verified for a signup. If not it
+// writes the error response.
+func signupVerified(w http.ResponseWriter, token, mailAddress string) bool {
+	if VerificationTokenSecret == "" {
+		writeError(w, http.StatusServiceUnavailable, "Registration is unavailable")
+		return false
+	}
+	if err := verification.Check(VerificationTokenSecret, token, mailAddress, signupPurpose, time.Now()); err != nil {
+		writeError(w, http.StatusForbidden, "Mail address is not verified, verify it with the code mailed to it")
+		return false
+	}
+	return true
+}
+
+// mailDomainAllowed reports whether the domain of mailAddress is one of SelfRegistrationDomains
+func mailDomainAllowed(mailAddress string) bool {
+	at := strings.LastIndex(mailAddress, "@")
+	if at < 0 {
+		return false
+	}
+	domain := identifier.Normalize(mailAddress[at+1:])
+	for _, allowed := range strings.Split(SelfRegistrationDomains, ",") {
+		allowed = identifier.Normalize(strings.TrimPrefix(strings.TrimSpace(allowed), "@"))
+		if allowed != "" && domain == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ErrorResponse is the JSON body returned by /v1 endpoints on failure
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// UserResponse is the public representation of a User (never exposes the password hash)
+type UserResponse struct {
+	ID          uint      `json:"id"`
+	Username    string    `json:"username"`
+	MailAddress string    `json:"mailAddress"`
+	Role        string    `json:"role"`
+	Activated   bool      `json:"activated"`
+	LoginStatus bool      `json:"loginStatus"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// newUserResponse converts a GORM User into its API representation
+func newUserResponse(user User) UserResponse {
+	return UserResponse{
+		ID:          user.ID,
+		Username:    user.Username,
+		MailAddress: user.MailAddress,
+		Role:        user.Role,
+		Activated:   user.Activated,
+		LoginStatus: user.LoginStatus,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response with the given status code
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message})
+}
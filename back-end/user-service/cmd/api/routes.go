@@ -5,6 +5,8 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"shared/ratelimit"
 )
 
 // Define routes for the application
@@ -20,6 +22,8 @@ func (app *Config) routes() http.Handler {
 	// Protected routes (JWT authentication required)
 	mux.Group(func(r chi.Router) {
 		r.Use(AuthMiddleware)
+		r.Use(app.RejectInactiveTokens)
+		r.Use(app.RateLimit(rateLimitUser, ratelimit.ByHeader("X-Username")))
 		app.protectedRoutes(r)
 	})
 
@@ -29,19 +33,70 @@ func (app *Config) routes() http.Handler {
 // Public routes
 func (app *Config) publicRoutes(mux *chi.Mux) {
 	mux.Get("/health", app.HealthCheckHandler)
-	mux.Post("/register", app.CreateUserHandler)
-	mux.Post("/login", app.LoginUserHandler)
 	mux.Get("/metrics", promhttp.Handler().ServeHTTP)
+	mux.Get("/openapi.json", app.OpenAPIHandler)
+
+	// v1 resource API
+	mux.With(app.RateLimit(rateLimitRegister, ratelimit.ByIP)).Post("/v1/users", app.CreateUserV1Handler)
+	mux.With(app.RateLimit(rateLimitLogin, ratelimit.ByIP)).Post("/v1/sessions", app.CreateSessionV1Handler)
+	mux.Post("/introspect", app.IntrospectHandler) // Authenticated with client credentials
+	mux.Post("/v1/email-changes/confirm", app.ConfirmEmailChangeHandler)
+	mux.Post("/v1/email-changes/revert", app.RevertEmailChangeHandler)
+	mux.Post("/v1/password-setups/complete", app.CompletePasswordSetupHandler)
+	mux.Post("/v1/invitations/accept", app.AcceptInvitationHandler)
+
+	// Deprecated aliases of the v1 API
+	mux.With(DeprecatedMiddleware("/v1/users"), app.RateLimit(rateLimitRegister, ratelimit.ByIP)).Post("/register", app.CreateUserHandler)
+	mux.With(DeprecatedMiddleware("/v1/sessions"), app.RateLimit(rateLimitLogin, ratelimit.ByIP)).Post("/login", app.LoginUserHandler)
 }
 
 // Protected routes (Require JWT authentication)
 func (app *Config) protectedRoutes(r chi.Router) {
-	r.With(AuthMiddleware).Get("/user", app.GetUserHandler)
-	r.With(AuthMiddleware).Post("/update-password", app.UpdatePasswordHandler)
-	r.With(AuthMiddleware).Put("/update-user", app.UpdateUserHandler)
-	r.With(AuthMiddleware).Put("/deactivate-user", app.DeactivateUserHandler)
-	r.With(AuthMiddleware).Put("/activate-user", app.ActivateUserHandler)
-	r.With(AuthMiddleware).Put("/update-email", app.UpdateEmailHandler)
-	r.With(AuthMiddleware).Put("/update-role", app.UpdateRoleHandler)
-	r.With(AuthMiddleware).Delete("/delete-user", app.DeleteUserHandler)
+	// v1 resource API
+	r.Delete("/v1/sessions/current", app.DeleteSessionV1Handler)
+	r.With(RequireRole(RoleAdmin)).Post("/v1/users/import", app.ImportUsersV1Handler)
+	r.With(RequireRole(RoleAdmin)).Get("/v1/users/export", app.ExportUsersV1Handler)
+	r.With(RequireRole(RoleAdmin)).Post("/v1/invitations", app.CreateInvitationHandler)
+	r.With(RequireRole(RoleAdmin)).Get("/v1/invitations", app.ListInvitationsHandler)
+	r.With(RequireRole(RoleAdmin)).Delete("/v1/invitations/{invitationID}", app.RevokeInvitationHandler)
+	r.With(RequireRole(RoleAdmin)).Post("/v1/webhooks", app.CreateWebhookHandler)
+	r.With(RequireRole(RoleAdmin)).Get("/v1/webhooks", app.ListWebhooksHandler)
+	r.With(RequireRole(RoleAdmin)).Delete("/v1/webhooks/{webhookID}", app.DeleteWebhookHandler)
+	r.With(RequireRole(RoleAdmin)).Get("/v1/webhooks/{webhookID}/deliveries", app.ListWebhookDeliveriesHandler)
+	r.Post("/v1/organizations", app.CreateOrganizationHandler)
+	r.Get("/v1/organizations", app.ListOrganizationsHandler)
+	r.Get("/v1/organizations/{orgID}/members", app.ListMembersHandler)
+	r.Put("/v1/organizations/{orgID}/members/{userID}", app.PutMemberHandler)
+	r.Delete("/v1/organizations/{orgID}/members/{userID}", app.DeleteMemberHandler)
+	r.With(RequireRole(RoleAdmin)).Post("/v1/teams", app.CreateTeamHandler)
+	r.Get("/v1/teams", app.ListTeamsHandler)
+	r.Get("/v1/teams/{teamID}", app.GetTeamHandler)
+	r.With(RequireRole(RoleAdmin)).Delete("/v1/teams/{teamID}", app.DeleteTeamHandler)
+	r.With(RequireRole(RoleAdmin)).Put("/v1/teams/{teamID}/manager", app.SetTeamManagerHandler)
+	r.With(RequireRole(RoleAdmin)).Delete("/v1/teams/{teamID}/manager", app.RemoveTeamManagerHandler)
+	r.With(RequireRole(RoleAdmin)).Put("/v1/teams/{teamID}/members/{userID}", app.AddTeamMemberHandler)
+	r.With(RequireRole(RoleAdmin)).Delete("/v1/teams/{teamID}/members/{userID}", app.RemoveTeamMemberHandler)
+	r.Route("/v1/users/{id}", func(r chi.Router) {
+		r.Get("/", app.GetUserV1Handler)
+		r.Patch("/", app.PatchUserV1Handler)
+		r.Delete("/", app.DeleteUserV1Handler)
+		r.Put("/role", app.UpdateRoleV1Handler)
+		r.Put("/activation", app.ActivateUserV1Handler)
+		r.Delete("/activation", app.DeactivateUserV1Handler)
+		r.Put("/email", app.UpdateEmailV1Handler)
+		r.Put("/password", app.UpdatePasswordV1Handler)
+		r.Put("/username", app.UpdateUsernameV1Handler)
+		r.Get("/username-history", app.UsernameHistoryV1Handler)
+		r.Get("/reports", app.ListReportsHandler)
+	})
+
+	// Deprecated aliases of the v1 API
+	r.With(AuthMiddleware, DeprecatedMiddleware("/v1/users/{id}")).Get("/user", app.GetUserHandler)
+	r.With(AuthMiddleware, DeprecatedMiddleware("/v1/users/{id}/password")).Post("/update-password", app.UpdatePasswordHandler)
+	r.With(AuthMiddleware, DeprecatedMiddleware("/v1/users/{id}")).Put("/update-user", app.UpdateUserHandler)
+	r.With(AuthMiddleware, DeprecatedMiddleware("/v1/users/{id}/activation")).Put("/deactivate-user", app.DeactivateUserHandler)
+	r.With(AuthMiddleware, DeprecatedMiddleware("/v1/users/{id}/activation")).Put("/activate-user", app.ActivateUserHandler)
+	r.With(AuthMiddleware, DeprecatedMiddleware("/v1/users/{id}/email")).Put("/update-email", app.UpdateEmailHandler)
+	r.With(AuthMiddleware, DeprecatedMiddleware("/v1/users/{id}/role")).Put("/update-role", app.UpdateRoleHandler)
+	r.With(AuthMiddleware, DeprecatedMiddleware("/v1/users/{id}")).Delete("/delete-user", app.DeleteUserHandler)
 }
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// Team groups users (typically sales representatives) under a manager
+type Team struct {
+	ID             uint      `gorm:"primaryKey"`
+	OrganizationID *uint     `gorm:"index"` // nil for deployments without organizations
+	Name           string    `gorm:"not null"`
+	ManagerID      *uint     `gorm:"index"`
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime"`
+}
+
+// TeamMember puts a user in a team
+type TeamMember struct {
+	ID        uint      `gorm:"primaryKey"`
+	TeamID    uint      `gorm:"not null;uniqueIndex:idx_team_members_team_user"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_team_members_team_user;index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// TeamResponse is the API representation of a Team with its manager and members
+type TeamResponse struct {
+	ID        uint           `json:"id"`
+	Name      string         `json:"name"`
+	Manager   *UserResponse  `json:"manager"`
+	Members   []UserResponse `json:"members"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+type createTeamRequest struct {
+	Name      string `json:"name" validate:"required,max=100"`
+	ManagerID *uint  `json:"managerId"`
+}
+
+type setTeamManagerRequest struct {
+	UserID uint `json:"userId" validate:"required"`
+}
+
+// userAccess is what a caller wants to do with another user
+type userAccess int
+
+const (
+	accessView       userAccess = iota // Read the user
+	accessActivation                   // Activate or deactivate the user
+	accessManage                       // Change the user's own data (mail address, password, ...)
+	accessAdmin                        // Change roles
+)
+
+// callerUser loads the user of the request's token. Legacy logins carry the mail address as username.
+func (app *Config) callerUser(r *http.Request) (*User, error) {
+	username := r.Header.Get("X-Username")
+	user, err := app.findUserByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return app.findUserByMailAddress(username)
+	}
+	return user, err
+}
+
+// canAccessUser applies the authorization rule for user endpoints: admins may do
+// anything, users may view and manage themselves, and managers may view and
+// (de)activate the members of the teams they manage.
+func (app *Config) canAccessUser(r *http.Request, target *User, access userAccess) (bool, error) {
+	role := canonicalRole(r.Header.Get("X-Role"))
+	if role == RoleAdmin {
+		return true, nil
+	}
+
+	caller, err := app.callerUser(r)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if caller.ID == target.ID && (access == accessView || access == accessManage) {
+		return true, nil
+	}
+	if role == RoleManager && (access == accessView || access == accessActivation) {
+		return app.managesUser(caller.ID, target.ID)
+	}
+	return false, nil
+}
+
+// managesUser reports whether userID is a member of a team managed by managerID
+func (app *Config) managesUser(managerID, userID uint) (bool, error) {
+	var count int64
+	err := app.DB.Model(&TeamMember{}).
+		Joins("JOIN teams ON teams.id = team_members.team_id").
+		Where("teams.manager_id = ? AND team_members.user_id = ?", managerID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// scopeTeams restricts a teams query to the caller's organization
+func scopeTeams(r *http.Request, db *gorm.DB) *gorm.DB {
+	if organizationID := callerOrganizationID(r); organizationID != 0 {
+		return db.Where("teams.organization_id = ?", organizationID)
+	}
+	return db.Where("teams.organization_id IS NULL")
+}
+
+// teamFromPath loads the team referenced by the {teamID} URL parameter.
+// On failure it writes the error response and returns false.
+func (app *Config) teamFromPath(w http.ResponseWriter, r *http.Request) (*Team, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "teamID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid team id")
+		return nil, false
+	}
+
+	var team Team
+	if err := scopeTeams(r, app.DB).First(&team, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "Team not found")
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	return &team, true
+}
+
+// scopedUserByID loads a user of the caller's organization, writing a 404 or 500 on failure
+func (app *Config) scopedUserByID(w http.ResponseWriter, r *http.Request, id uint) (*User, bool) {
+	var user User
+	if err := scopeUsers(r, app.DB).First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, ErrUserNotFound)
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	return &user, true
+}
+
+// ensureManagerRole writes a 422 and returns false when user cannot manage a team
+func (app *Config) ensureManagerRole(w http.ResponseWriter, r *http.Request, user *User) bool {
+	role := app.userResponse(r, *user).Role
+	if role != RoleManager && role != RoleAdmin {
+		writeValidationError(w, []FieldError{{Field: "managerId", Message: "must be a Manager or an Admin"}})
+		return false
+	}
+	return true
+}
+
+// teamResponses loads managers and members of teams and converts them to their API representation
+func (app *Config) teamResponses(r *http.Request, teams []Team) ([]TeamResponse, error) {
+	ids := make([]uint, 0, len(teams))
+	for _, team := range teams {
+		ids = append(ids, team.ID)
+	}
+
+	var members []TeamMember
+	if len(ids) > 0 {
+		if err := app.DB.Where("team_id IN ?", ids).Find(&members).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	userIDs := make([]uint, 0, len(members)+len(teams))
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+	for _, team := range teams {
+		if team.ManagerID != nil {
+			userIDs = append(userIDs, *team.ManagerID)
+		}
+	}
+
+	users := map[uint]UserResponse{}
+	if len(userIDs) > 0 {
+		var found []User
+		if err := app.DB.Where("id IN ?", userIDs).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, user := range found {
+			users[user.ID] = app.userResponse(r, user)
+		}
+	}
+
+	byTeam := map[uint][]UserResponse{}
+	for _, member := range members {
+		if user, ok := users[member.UserID]; ok {
+			byTeam[member.TeamID] = append(byTeam[member.TeamID], user)
+		}
+	}
+
+	responses := make([]TeamResponse, 0, len(teams))
+	for _, team := range teams {
+		response := TeamResponse{ID: team.ID, Name: team.Name, Members: byTeam[team.ID], CreatedAt: team.CreatedAt}
+		if response.Members == nil {
+			response.Members = []UserResponse{}
+		}
+		sort.Slice(response.Members, func(i, j int) bool { return response.Members[i].Username < response.Members[j].Username })
+		if team.ManagerID != nil {
+			if manager, ok := users[*team.ManagerID]; ok {
+				response.Manager = &manager
+			}
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// CreateTeamHandler handles POST /v1/teams (admins only)
+func (app *Config) CreateTeamHandler(w http.ResponseWriter, r *http.Request) {
+	var req createTeamRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	team := Team{Name: req.Name}
+	if organizationID := callerOrganizationID(r); organizationID != 0 {
+		team.OrganizationID = &organizationID
+	}
+	if req.ManagerID != nil {
+		manager, ok := app.scopedUserByID(w, r, *req.ManagerID)
+		if !ok || !app.ensureManagerRole(w, r, manager) {
+			return
+		}
+		team.ManagerID = &manager.ID
+	}
+
+	if err := app.DB.Create(&team).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create team")
+		return
+	}
+
+	responses, err := app.teamResponses(r, []Team{team})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/teams/%d", team.ID))
+	writeJSON(w, http.StatusCreated, responses[0])
+}
+
+// ListTeamsHandler handles GET /v1/teams, the org chart: every team with its
+// manager and members. Managers only see the teams they manage.
+func (app *Config) ListTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	query := scopeTeams(r, app.DB).Order("name")
+	switch canonicalRole(r.Header.Get("X-Role")) {
+	case RoleAdmin:
+	case RoleManager:
+		caller, err := app.callerUser(r)
+		if err != nil {
+			writeError(w, http.StatusForbidden, "Insufficient permissions")
+			return
+		}
+		query = query.Where("manager_id = ?", caller.ID)
+	default:
+		writeError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+
+	var teams []Team
+	if err := query.Find(&teams).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	responses, err := app.teamResponses(r, teams)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, responses)
+}
+
+// GetTeamHandler handles GET /v1/teams/{teamID} for admins and the team's manager
+func (app *Config) GetTeamHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.teamFromPath(w, r)
+	if !ok {
+		return
+	}
+	if canonicalRole(r.Header.Get("X-Role")) != RoleAdmin {
+		caller, err := app.callerUser(r)
+		if err != nil || team.ManagerID == nil || *team.ManagerID != caller.ID {
+			writeError(w, http.StatusNotFound, "Team not found")
+			return
+		}
+	}
+
+	responses, err := app.teamResponses(r, []Team{*team})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, responses[0])
+}
+
+// DeleteTeamHandler handles DELETE /v1/teams/{teamID} (admins only)
+func (app *Config) DeleteTeamHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.teamFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("team_id = ?", team.ID).Delete(&TeamMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(team).Error
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to delete team")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetTeamManagerHandler handles PUT /v1/teams/{teamID}/manager (admins only)
+func (app *Config) SetTeamManagerHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.teamFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req setTeamManagerRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+	manager, ok := app.scopedUserByID(w, r, req.UserID)
+	if !ok || !app.ensureManagerRole(w, r, manager) {
+		return
+	}
+
+	team.ManagerID = &manager.ID
+	if err := app.DB.Save(team).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update team")
+		return
+	}
+	responses, err := app.teamResponses(r, []Team{*team})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, responses[0])
+}
+
+// RemoveTeamManagerHandler handles DELETE /v1/teams/{teamID}/manager (admins only)
+func (app *Config) RemoveTeamManagerHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.teamFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.DB.Model(team).Update("manager_id", nil).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update team")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddTeamMemberHandler handles PUT /v1/teams/{teamID}/members/{userID} (admins only)
+func (app *Config) AddTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.teamFromPath(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+	user, ok := app.scopedUserByID(w, r, uint(userID))
+	if !ok {
+		return
+	}
+
+	member := TeamMember{TeamID: team.ID, UserID: user.ID}
+	if err := app.DB.Where(&member).FirstOrCreate(&member).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to add team member")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveTeamMemberHandler handles DELETE /v1/teams/{teamID}/members/{userID} (admins only)
+func (app *Config) RemoveTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := app.teamFromPath(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	result := app.DB.Where("team_id = ? AND user_id = ?", team.ID, userID).Delete(&TeamMember{})
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to remove team member")
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeError(w, http.StatusNotFound, ErrUserNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListReportsHandler handles GET /v1/users/{id}/reports: the members of all teams the user manages
+func (app *Config) ListReportsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := app.userFromPath(w, r, accessView)
+	if !ok {
+		return
+	}
+
+	var users []User
+	err := app.DB.
+		Where("id IN (SELECT team_members.user_id FROM team_members JOIN teams ON teams.id = team_members.team_id WHERE teams.manager_id = ?)", manager.ID).
+		Order("username").
+		Find(&users).Error
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	response := make([]UserResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, app.userResponse(r, user))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Managers view and (de)activate the members of their own teams only, and
+// reach themselves like any user
+func TestCanAccessUser(t *testing.T) {
+	app := newTestApp(t)
+	managerA := createTestUser(t, app, "managera", RoleManager, true)
+	managerB := createTestUser(t, app, "managerb", RoleManager, true)
+	memberA := createTestUser(t, app, "membera", RoleSalesRepresentative, true)
+	memberB := createTestUser(t, app, "memberb", RoleSalesRepresentative, true)
+	loner := createTestUser(t, app, "loner", RoleSalesRepresentative, true)
+	admin := createTestUser(t, app, "admin", RoleAdmin, true)
+
+	for manager, member := range map[*User]*User{managerA: memberA, managerB: memberB} {
+		team := Team{Name: manager.Username, ManagerID: &manager.ID}
+		if err := app.DB.Create(&team).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := app.DB.Create(&TeamMember{TeamID: team.ID, UserID: member.ID}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		caller *User
+		target *User
+		access userAccess
+		want   bool
+	}{
+		{"manager views own member", managerA, memberA, accessView, true},
+		{"manager deactivates own member", managerA, memberA, accessActivation, true},
+		{"manager manages own member", managerA, memberA, accessManage, false},
+		{"manager changes role of own member", managerA, memberA, accessAdmin, false},
+		{"manager views member of another team", managerA, memberB, accessView, false},
+		{"manager deactivates member of another team", managerA, memberB, accessActivation, false},
+		{"manager views another manager", managerA, managerB, accessView, false},
+		{"manager views non-member", managerA, loner, accessView, false},
+		{"manager deactivates non-member", managerA, loner, accessActivation, false},
+		{"manager views self", managerA, managerA, accessView, true},
+		{"manager manages self", managerA, managerA, accessManage, true},
+		{"manager deactivates self", managerA, managerA, accessActivation, false},
+		{"manager changes own role", managerA, managerA, accessAdmin, false},
+		{"member views own manager", memberA, managerA, accessView, false},
+		{"member manages self", memberA, memberA, accessManage, true},
+		{"admin changes role", admin, memberB, accessAdmin, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Username", tt.caller.Username)
+		r.Header.Set("X-Role", tt.caller.Role)
+		got, err := app.canAccessUser(r, tt.target, tt.access)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
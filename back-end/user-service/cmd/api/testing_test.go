@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"shared/password"
+)
+
+// Cheap parameters keep the tests fast, the code paths are the same
+func init() {
+	passwordHasher = password.NewHasher(password.Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, "")
+}
+
+// newTestApp returns an app backed by a fresh in-memory SQLite database
+func newTestApp(t *testing.T) *Config {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would get its own in-memory database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return &Config{DB: db, ValidateResponses: true}
+}
+
+// createTestUser stores a user with the password "Secret123!"
+func createTestUser(t *testing.T, app *Config, username, role string, activated bool) *User {
+	t.Helper()
+	hash, err := app.HashPassword("Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Username: username, MailAddress: username + "@example.com", Password: hash, Role: role, Activated: true}
+	if err := app.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Activated has a database default, so false is only kept by an update
+	if !activated {
+		if err := app.DB.Model(user).Update("activated", false).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+// serve sends a request through the routes of app, with a bearer token when token is set
+func serve(app *Config, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	app.routes().ServeHTTP(rec, req)
+	return rec
+}
+
+// createTestMembership stores organizationID if needed and makes user a member of it with role
+func createTestMembership(t *testing.T, app *Config, organizationID uint, user *User, role string) {
+	t.Helper()
+	org := Organization{ID: organizationID, Name: fmt.Sprintf("Org %d", organizationID), Slug: fmt.Sprintf("org-%d", organizationID)}
+	if err := app.DB.FirstOrCreate(&org, Organization{ID: organizationID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := app.DB.Create(&Membership{OrganizationID: organizationID, UserID: user.ID, Role: role}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tokenFor issues a token of user acting in organizationID (0 for none)
+func tokenFor(t *testing.T, app *Config, user *User, organizationID uint) string {
+	t.Helper()
+	session, err := app.issueToken(user, organizationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.Token
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"shared/identifier"
+)
+
+const (
+	usernameReservation  = 30 * 24 * time.Hour // How long a released username stays with its previous owner
+	usernameChangeWindow = 30 * 24 * time.Hour // Period over which usernameChangeLimit applies
+	usernameChangeLimit  = 2                   // Maximum username changes per user within usernameChangeWindow
+)
+
+// UsernameChange records a previous username of a user. Until ReservedUntil the
+// old name cannot be claimed by anybody else and lookups by it resolve to UserID.
+type UsernameChange struct {
+	ID                    uint      `gorm:"primaryKey"`
+	UserID                uint      `gorm:"not null;index"`
+	OldUsername           string    `gorm:"not null"`
+	OldUsernameNormalized string    `gorm:"not null;index"`
+	NewUsername           string    `gorm:"not null"`
+	ReservedUntil         time.Time `gorm:"not null"`
+	ChangedBy             string
+	CreatedAt             time.Time `gorm:"autoCreateTime;index"`
+}
+
+// UsernameChangeResponse is one entry of GET /v1/users/{id}/username-history
+type UsernameChangeResponse struct {
+	OldUsername   string    `json:"oldUsername"`
+	NewUsername   string    `json:"newUsername"`
+	ChangedAt     time.Time `json:"changedAt"`
+	ReservedUntil time.Time `json:"reservedUntil"`
+}
+
+type updateUsernameRequest struct {
+	Username string `json:"username" validate:"required,username"`
+}
+
+// findReservedUsername returns the user that released username less than usernameReservation ago
+func (app *Config) findReservedUsername(username string) (*User, error) {
+	var change UsernameChange
+	err := app.DB.Where("old_username_normalized = ? AND reserved_until > ?", identifier.Normalize(username), time.Now()).
+		Order("created_at DESC").
+		First(&change).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var user User
+	if err := app.DB.First(&user, change.UserID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// usernameReserved reports whether username was released by a user other than excludeID and is still reserved
+func (app *Config) usernameReserved(username string, excludeID uint) (bool, error) {
+	var count int64
+	err := app.DB.Model(&UsernameChange{}).
+		Where("old_username_normalized = ? AND reserved_until > ? AND user_id <> ?", identifier.Normalize(username), time.Now(), excludeID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// UpdateUsernameV1Handler handles PUT /v1/users/{id}/username. The old name is kept
+// in the history and reserved for the user. Users renaming themselves get a fresh
+// token for the caller's organization, because their existing tokens carry the old name.
+func (app *Config) UpdateUsernameV1Handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r, accessManage)
+	if !ok || !app.ensureGlobalChangeAllowed(w, r, user) {
+		return
+	}
+	caller, err := app.callerUser(r)
+	self := err == nil && caller.ID == user.ID
+
+	var req updateUsernameRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	// Changing only the case of the name does not release anything, so it is not limited
+	caseOnly := identifier.Normalize(req.Username) == user.UsernameNormalized
+	if !caseOnly {
+		var recent []UsernameChange
+		err := app.DB.Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-usernameChangeWindow)).
+			Order("created_at ASC").
+			Find(&recent).Error
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if len(recent) >= usernameChangeLimit {
+			retryAt := recent[0].CreatedAt.Add(usernameChangeWindow)
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(retryAt).Seconds())+1))
+			writeError(w, http.StatusTooManyRequests,
+				fmt.Sprintf("Username can be changed at most %d times in %d days", usernameChangeLimit, int(usernameChangeWindow.Hours()/24)))
+			return
+		}
+
+		taken, err := app.usernameTaken(req.Username, user.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if taken {
+			writeError(w, http.StatusConflict, ErrUsernameTaken)
+			return
+		}
+	}
+
+	oldUsername := user.Username
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		user.Username = req.Username
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		if caseOnly {
+			return nil
+		}
+		// Reclaiming one of your own reserved names ends that reservation
+		err := tx.Model(&UsernameChange{}).
+			Where("user_id = ? AND old_username_normalized = ? AND reserved_until > ?", user.ID, user.UsernameNormalized, time.Now()).
+			Update("reserved_until", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&UsernameChange{
+			UserID:                user.ID,
+			OldUsername:           oldUsername,
+			OldUsernameNormalized: identifier.Normalize(oldUsername),
+			NewUsername:           user.Username,
+			ReservedUntil:         time.Now().Add(usernameReservation),
+			ChangedBy:             r.Header.Get("X-Username"),
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		writeError(w, http.StatusConflict, ErrUsernameTaken)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update username")
+		return
+	}
+
+	fmt.Printf("User %d renamed from %s to %s by %s\n", user.ID, oldUsername, user.Username, r.Header.Get("X-Username"))
+	response := map[string]interface{}{"user": app.userResponse(r, *user)}
+	if self {
+		session, err := app.issueToken(user, callerOrganizationID(r))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		response["token"] = session.Token
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// UsernameHistoryV1Handler handles GET /v1/users/{id}/username-history, newest first
+func (app *Config) UsernameHistoryV1Handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r, accessView)
+	if !ok {
+		return
+	}
+
+	var changes []UsernameChange
+	if err := app.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Find(&changes).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	history := make([]UsernameChangeResponse, 0, len(changes))
+	for _, change := range changes {
+		history = append(history, UsernameChangeResponse{
+			OldUsername:   change.OldUsername,
+			NewUsername:   change.NewUsername,
+			ChangedAt:     change.CreatedAt,
+			ReservedUntil: change.ReservedUntil,
+		})
+	}
+	writeJSON(w, http.StatusOK, history)
+}
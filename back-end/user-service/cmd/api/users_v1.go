@@ -0,0 +1,410 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// v1 request payloads, kept separate from the GORM User model so clients
+// cannot set ID, Activated or LoginStatus
+type createUserRequest struct {
+	Username          string `json:"username" validate:"required,username"`
+	MailAddress       string `json:"mailAddress" validate:"required,mailaddress"`
+	Password          string `json:"password" validate:"required,password"`
+	Role              string `json:"role" validate:"required,role"`
+	VerificationToken string `json:"verificationToken"` // From POST /verify-auth-code of mail-service, proves the mail address
+}
+
+type createSessionRequest struct {
+	MailAddress    string `json:"mailAddress" validate:"required"`
+	Password       string `json:"password" validate:"required"`
+	OrganizationID uint   `json:"organizationId"` // Optional, defaults to the user's first organization
+}
+
+// patchUserRequest has no mail address: changes must be confirmed via PUT /v1/users/{id}/email
+type patchUserRequest struct {
+	Password *string `json:"password" validate:"omitempty,password"`
+	Role     *string `json:"role" validate:"omitempty,role"`
+}
+
+type updateRoleRequest struct {
+	Role string `json:"role" validate:"required,role"`
+}
+
+type updateEmailRequest struct {
+	MailAddress string `json:"mailAddress" validate:"required,mailaddress"`
+}
+
+type updatePasswordRequest struct {
+	Password string `json:"password" validate:"required,password"`
+}
+
+// userFromPath loads the user referenced by the {id} URL parameter, which must
+// belong to the caller's organization and be accessible to the caller (see canAccessUser).
+// On failure it writes the error response and returns false.
+func (app *Config) userFromPath(w http.ResponseWriter, r *http.Request, access userAccess) (*User, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid user id")
+		return nil, false
+	}
+
+	var user User
+	if err := scopeUsers(r, app.DB).First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, ErrUserNotFound)
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+
+	allowed, err := app.canAccessUser(r, &user, access)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if !allowed {
+		// Users the caller may not even view are reported as missing
+		if viewable, _ := app.canAccessUser(r, &user, accessView); !viewable {
+			writeError(w, http.StatusNotFound, ErrUserNotFound)
+			return nil, false
+		}
+		writeError(w, http.StatusForbidden, "Insufficient permissions")
+		return nil, false
+	}
+	return &user, true
+}
+
+// ensureMailAddressAvailable writes a 409 and returns false when another user already uses mailAddress
+func (app *Config) ensureMailAddressAvailable(w http.ResponseWriter, mailAddress string, userID uint) bool {
+	taken, err := app.mailAddressTaken(mailAddress, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return false
+	}
+	if taken {
+		writeError(w, http.StatusConflict, ErrMailAddressTaken)
+		return false
+	}
+	return true
+}
+
+// createUser registers the user described by the request body and issues a token
+// for them. POST /v1/users and its deprecated alias /register only differ in the
+// response. On failure it writes the error response and returns false.
+func (app *Config) createUser(w http.ResponseWriter, r *http.Request) (*User, string, bool) {
+	var req createUserRequest
+	if !decodeAndValidate(w, r, &req) {
+		return nil, "", false
+	}
+	if reason := selfRegistrationError(req.MailAddress, req.Role); reason != "" {
+		writeError(w, http.StatusForbidden, reason)
+		return nil, "", false
+	}
+	if !signupVerified(w, req.VerificationToken, req.MailAddress) {
+		return nil, "", false
+	}
+
+	// Check if user already exists (by username OR mail address, ignoring case)
+	usernameTaken, err := app.usernameTaken(req.Username, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, "", false
+	}
+	mailAddressTaken, err := app.mailAddressTaken(req.MailAddress, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, "", false
+	}
+	if usernameTaken || mailAddressTaken {
+		writeError(w, http.StatusConflict, "User already exists")
+		return nil, "", false
+	}
+
+	hashedPassword, err := app.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrHashingPassword)
+		return nil, "", false
+	}
+
+	user := User{
+		Username:    req.Username,
+		MailAddress: req.MailAddress,
+		Password:    hashedPassword,
+		Role:        canonicalRole(req.Role),
+		Activated:   true,
+	}
+	if err := app.DB.Create(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			writeError(w, http.StatusConflict, "User already exists")
+			return nil, "", false
+		}
+		writeError(w, http.StatusInternalServerError, ErrInsertingUser)
+		return nil, "", false
+	}
+
+	token, err := GenerateJWT(user.Username, user.Role)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate token")
+		return nil, "", false
+	}
+	return &user, token, true
+}
+
+// CreateUserV1Handler handles POST /v1/users
+func (app *Config) CreateUserV1Handler(w http.ResponseWriter, r *http.Request) {
+	user, token, ok := app.createUser(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/users/%d", user.ID))
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"user":  newUserResponse(*user),
+		"token": token,
+	})
+}
+
+// CreateSessionV1Handler handles POST /v1/sessions (login)
+func (app *Config) CreateSessionV1Handler(w http.ResponseWriter, r *http.Request) {
+	var req createSessionRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	user, err := app.findUserByMailAddress(req.MailAddress)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, ErrInvalidCredentials)
+		return
+	}
+	if !app.CheckPassword(user, req.Password) {
+		writeError(w, http.StatusUnauthorized, ErrInvalidCredentials)
+		return
+	}
+	if !user.Activated {
+		writeError(w, http.StatusForbidden, "Account is deactivated")
+		return
+	}
+
+	session, err := app.issueToken(user, req.OrganizationID)
+	if errors.Is(err, errNotMember) {
+		writeError(w, http.StatusForbidden, "Not a member of this organization")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	user.LoginStatus = true
+	if err := app.DB.Save(user).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+
+	response := map[string]interface{}{
+		"user":  newUserResponse(*user),
+		"token": session.Token,
+	}
+	if session.OrganizationID != 0 {
+		response["user"] = app.userResponseIn(session.OrganizationID, *user)
+		response["organizationId"] = session.OrganizationID
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// GetUserV1Handler handles GET /v1/users/{id}
+func (app *Config) GetUserV1Handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r, accessView)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, app.userResponse(r, *user))
+}
+
+// PatchUserV1Handler handles PATCH /v1/users/{id}; only the fields present in the body are changed
+func (app *Config) PatchUserV1Handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r, accessManage)
+	if !ok {
+		return
+	}
+
+	var req patchUserRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	if req.Password != nil {
+		if !app.ensureGlobalChangeAllowed(w, r, user) {
+			return
+		}
+		hashedPassword, err := app.HashPassword(*req.Password)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrHashingPassword)
+			return
+		}
+		user.Password = hashedPassword
+	}
+
+	if req.Role != nil {
+		if allowed, _ := app.canAccessUser(r, user, accessAdmin); !allowed {
+			writeError(w, http.StatusForbidden, "Insufficient permissions")
+			return
+		}
+		if err := app.assignRole(r, user, canonicalRole(*req.Role)); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to update role")
+			return
+		}
+	}
+
+	if err := app.DB.Save(user).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+	writeJSON(w, http.StatusOK, app.userResponse(r, *user))
+}
+
+// DeleteUserV1Handler handles DELETE /v1/users/{id}
+func (app *Config) DeleteUserV1Handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r, accessManage)
+	if !ok {
+		return
+	}
+
+	// Users that also belong to other organizations only leave the caller's one
+	if organizationID := callerOrganizationID(r); organizationID != 0 {
+		elsewhere, err := app.memberElsewhere(organizationID, user.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if elsewhere {
+			err := app.DB.Transaction(func(tx *gorm.DB) error {
+				_, err := removeMembership(tx, organizationID, user.ID)
+				return err
+			})
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "Failed to remove member")
+				return
+			}
+			fmt.Printf("User %s (ID: %d) removed from organization %d by %s\n", user.Username, user.ID, organizationID, r.Header.Get("X-Username"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	if err := app.DB.Delete(user).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+
+	fmt.Printf("User %s (ID: %d) deleted by %s\n", user.Username, user.ID, r.Header.Get("X-Username"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateRoleV1Handler handles PUT /v1/users/{id}/role
+func (app *Config) UpdateRoleV1Handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r, accessAdmin)
+	if !ok {
+		return
+	}
+
+	var req updateRoleRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	if err := app.assignRole(r, user, canonicalRole(req.Role)); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update role")
+		return
+	}
+	if err := app.DB.Save(user).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update role")
+		return
+	}
+	writeJSON(w, http.StatusOK, app.userResponse(r, *user))
+}
+
+// ActivateUserV1Handler handles PUT /v1/users/{id}/activation
+func (app *Config) ActivateUserV1Handler(w http.ResponseWriter, r *http.Request) {
+	app.setActivation(w, r, true)
+}
+
+// DeactivateUserV1Handler handles DELETE /v1/users/{id}/activation
+func (app *Config) DeactivateUserV1Handler(w http.ResponseWriter, r *http.Request) {
+	app.setActivation(w, r, false)
+}
+
+func (app *Config) setActivation(w http.ResponseWriter, r *http.Request, activated bool) {
+	user, ok := app.userFromPath(w, r, accessActivation)
+	if !ok || !app.ensureGlobalChangeAllowed(w, r, user) {
+		return
+	}
+
+	user.Activated = activated
+	if err := app.DB.Save(user).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update activation")
+		return
+	}
+	writeJSON(w, http.StatusOK, app.userResponse(r, *user))
+}
+
+// UpdateEmailV1Handler handles PUT /v1/users/{id}/email. The change is only
+// applied once confirmed through the link mailed to the new address.
+func (app *Config) UpdateEmailV1Handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r, accessManage)
+	if !ok || !app.ensureGlobalChangeAllowed(w, r, user) {
+		return
+	}
+
+	var req updateEmailRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+	if !app.ensureMailAddressAvailable(w, req.MailAddress, user.ID) {
+		return
+	}
+
+	change, err := app.startEmailChange(user, req.MailAddress)
+	if err != nil {
+		writeEmailChangeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, EmailChangeResponse{
+		ID:             change.ID,
+		NewMailAddress: change.NewMailAddress,
+		ExpiresAt:      change.ConfirmExpiresAt,
+	})
+}
+
+// UpdatePasswordV1Handler handles PUT /v1/users/{id}/password
+func (app *Config) UpdatePasswordV1Handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r, accessManage)
+	if !ok || !app.ensureGlobalChangeAllowed(w, r, user) {
+		return
+	}
+
+	var req updatePasswordRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	hashedPassword, err := app.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrHashingPassword)
+		return
+	}
+
+	user.Password = hashedPassword
+	if err := app.DB.Save(user).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
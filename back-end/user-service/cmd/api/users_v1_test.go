@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Deactivated users are refused after the password check, on both login routes
+func TestLoginDeactivatedUser(t *testing.T) {
+	app := newTestApp(t)
+	createTestUser(t, app, "active", RoleCustomer, true)
+	createTestUser(t, app, "inactive", RoleCustomer, false)
+
+	login := func(path, username, pw string) int {
+		return serve(app, http.MethodPost, path, "", map[string]string{"mailAddress": username + "@example.com", "password": pw}).Code
+	}
+	for path, ok := range map[string]int{"/v1/sessions": http.StatusCreated, "/login": http.StatusOK} {
+		if code := login(path, "active", "Secret123!"); code != ok {
+			t.Errorf("%s: expected %d for an activated user, got %d", path, ok, code)
+		}
+		if code := login(path, "inactive", "Secret123!"); code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for a deactivated user, got %d", path, code)
+		}
+		// A wrong password does not reveal the deactivation
+		if code := login(path, "inactive", "Wrong123!"); code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401 for a wrong password, got %d", path, code)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/go-playground/validator/v10"
+)
+
+// Supported user roles
+const (
+	RoleAdmin               = "Admin"
+	RoleManager             = "Manager"
+	RoleSalesRepresentative = "SalesRepresentative"
+	RoleCustomer            = "Customer"
+)
+
+var validRoles = []string{RoleAdmin, RoleManager, RoleSalesRepresentative, RoleCustomer}
+
+// Validation rules
+var (
+	emailRegex    = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+	usernameRegex = regexp.MustCompile(`^[\p{L}\p{N}._-]{3,32}$`)
+	slugRegex     = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+)
+
+const (
+	maxEmailLength    = 254
+	minPasswordLength = 8
+	maxPasswordLength = 72 // bcrypt, still verified for older hashes, ignores anything beyond 72 bytes
+)
+
+// FieldError describes a single invalid request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validate holds the declarative rules used by the `validate` struct tags
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Report fields by their JSON name
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernameRegex.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("mailaddress", func(fl validator.FieldLevel) bool {
+		return isValidEmail(fl.Field().String())
+	})
+	v.RegisterValidation("role", func(fl validator.FieldLevel) bool {
+		return canonicalRole(fl.Field().String()) != ""
+	})
+	v.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return isValidPassword(fl.Field().String())
+	})
+	v.RegisterValidation("slug", func(fl validator.FieldLevel) bool {
+		return slugRegex.MatchString(fl.Field().String())
+	})
+	return v
+}
+
+// isValidEmail validates the format and length of a mail address
+func isValidEmail(email string) bool {
+	return len(email) <= maxEmailLength && emailRegex.MatchString(email)
+}
+
+// isValidPassword enforces the password policy: 8-72 bytes with at least one letter and one digit
+func isValidPassword(password string) bool {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return false
+	}
+	hasLetter, hasDigit := false, false
+	for _, c := range password {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	return hasLetter && hasDigit
+}
+
+// canonicalRole returns the canonical spelling of role (matched case-insensitively), or "" if unknown
+func canonicalRole(role string) string {
+	normalized := strings.NewReplacer(" ", "", "_", "", "-", "").Replace(role)
+	for _, r := range validRoles {
+		if strings.EqualFold(normalized, r) {
+			return r
+		}
+	}
+	return ""
+}
+
+// validateStruct runs the declarative rules on v and returns every invalid field
+func validateStruct(v interface{}) []FieldError {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return []FieldError{{Message: err.Error()}}
+	}
+
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		fields = append(fields, FieldError{Field: fe.Field(), Message: fieldErrorMessage(fe)})
+	}
+	return fields
+}
+
+// fieldErrorMessage turns a validator error into a human readable message
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "username":
+		return "must be 3-32 characters long and contain only letters, digits, '.', '_' or '-'"
+	case "mailaddress":
+		return "must be a valid email address"
+	case "role":
+		return fmt.Sprintf("must be one of %s", strings.Join(validRoles, ", "))
+	case "password":
+		return fmt.Sprintf("must be %d-%d characters long and contain at least one letter and one digit", minPasswordLength, maxPasswordLength)
+	case "slug":
+		return "must be 2-63 lowercase letters, digits or '-', starting with a letter or digit"
+	case "http_url":
+		return "must be an http or https URL"
+	case "min":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	}
+	return fmt.Sprintf("failed the %q rule", fe.Tag())
+}
+
+// decodeAndValidate decodes the JSON body into dst and applies its validation rules.
+// On failure it writes a 400 (malformed body) or 422 (invalid fields) response and returns false.
+func decodeAndValidate(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequestBody)
+		return false
+	}
+	if fields := validateStruct(dst); len(fields) > 0 {
+		writeValidationError(w, fields)
+		return false
+	}
+	return true
+}
+
+// writeValidationError writes a 422 response listing every invalid field
+func writeValidationError(w http.ResponseWriter, fields []FieldError) {
+	writeJSON(w, http.StatusUnprocessableEntity, ErrorResponse{Error: ErrValidationFailed, Fields: fields})
+}
+
+// schemaFieldErrors extracts the invalid body fields reported by the OpenAPI validator
+func schemaFieldErrors(err error) []FieldError {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		var fields []FieldError
+		for _, inner := range e {
+			fields = append(fields, schemaFieldErrors(inner)...)
+		}
+		return fields
+	case *openapi3filter.RequestError:
+		if e.RequestBody == nil {
+			return nil
+		}
+		return schemaFieldErrors(e.Err)
+	case *openapi3.SchemaError:
+		message := e.Reason
+		if e.SchemaField == "required" {
+			message = "is required"
+		}
+		return []FieldError{{Field: strings.Join(e.JSONPointer(), "."), Message: message}}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsValidPassword(t *testing.T) {
+	tests := map[string]bool{
+		"Secret123":                    true,
+		"şifre1234":                    true, // Letters of any script count
+		"abcdefg1":                     true, // Exactly the minimum length
+		strings.Repeat("a1", 36):       true, // Exactly the maximum length
+		"abc1":                         false,
+		"abcdefgh":                     false, // No digit
+		"12345678":                     false, // No letter
+		"!!!!!!!!":                     false,
+		strings.Repeat("a1", 36) + "b": false, // One byte too long
+		strings.Repeat("ş", 36) + "1":  false, // 73 bytes, the limit is in bytes
+		"":                             false,
+	}
+	for password, want := range tests {
+		if got := isValidPassword(password); got != want {
+			t.Errorf("%q: expected %v, got %v", password, want, got)
+		}
+	}
+}
+
+func TestCanonicalRole(t *testing.T) {
+	tests := map[string]string{
+		"Admin":                RoleAdmin,
+		"admin":                RoleAdmin,
+		"Sales Representative": RoleSalesRepresentative,
+		"sales_representative": RoleSalesRepresentative,
+		"sales-representative": RoleSalesRepresentative,
+		"MANAGER":              RoleManager,
+		"Owner":                "",
+		"":                     "",
+	}
+	for role, want := range tests {
+		if got := canonicalRole(role); got != want {
+			t.Errorf("%q: expected %q, got %q", role, want, got)
+		}
+	}
+}
+
+// Every invalid field is reported with its JSON name and a readable message
+func TestValidateStruct(t *testing.T) {
+	valid := createUserRequest{Username: "ayse.k", MailAddress: "ayse@example.com", Password: "Secret123", Role: "customer"}
+	if fields := validateStruct(&valid); fields != nil {
+		t.Fatalf("expected no errors, got %v", fields)
+	}
+
+	tests := []struct {
+		name   string
+		change func(r *createUserRequest)
+		want   map[string]string
+	}{
+		{"missing fields", func(r *createUserRequest) { *r = createUserRequest{} }, map[string]string{
+			"username": "is required", "mailAddress": "is required", "password": "is required", "role": "is required",
+		}},
+		{"short username", func(r *createUserRequest) { r.Username = "ay" }, map[string]string{
+			"username": "must be 3-32 characters long and contain only letters, digits, '.', '_' or '-'",
+		}},
+		{"username with a space", func(r *createUserRequest) { r.Username = "ayse k" }, map[string]string{
+			"username": "must be 3-32 characters long and contain only letters, digits, '.', '_' or '-'",
+		}},
+		{"long username", func(r *createUserRequest) { r.Username = strings.Repeat("a", 33) }, map[string]string{
+			"username": "must be 3-32 characters long and contain only letters, digits, '.', '_' or '-'",
+		}},
+		{"weak password and unknown role", func(r *createUserRequest) { r.Password = "password"; r.Role = "Owner" }, map[string]string{
+			"password": "must be 8-72 characters long and contain at least one letter and one digit",
+			"role":     "must be one of Admin, Manager, SalesRepresentative, Customer",
+		}},
+		{"invalid mail address", func(r *createUserRequest) { r.MailAddress = "ayse@example" }, map[string]string{
+			"mailAddress": "must be a valid email address",
+		}},
+	}
+	for _, tt := range tests {
+		req := valid
+		tt.change(&req)
+		got := map[string]string{}
+		for _, field := range validateStruct(&req) {
+			got[field.Field] = field.Message
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+			continue
+		}
+		for field, message := range tt.want {
+			if got[field] != message {
+				t.Errorf("%s: expected %s %q, got %q", tt.name, field, message, got[field])
+			}
+		}
+	}
+
+	// Unicode letters are allowed in usernames
+	valid.Username = "ayşe_ğ"
+	if fields := validateStruct(&valid); fields != nil {
+		t.Errorf("expected a Unicode username to be valid, got %v", fields)
+	}
+}
+
+// Malformed bodies are a 400, invalid fields a 422 listing them
+func TestDecodeAndValidate(t *testing.T) {
+	decode := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		var req createUserRequest
+		if decodeAndValidate(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), &req) {
+			rec.WriteHeader(http.StatusNoContent)
+		}
+		return rec
+	}
+
+	if rec := decode(`{"username": "ayse", "mailAddress": "ayse@example.com", "password": "Secret123", "role": "Customer"}`); rec.Code != http.StatusNoContent {
+		t.Errorf("expected a valid body to pass, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := decode(`{"username": `); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed body, got %d", rec.Code)
+	}
+
+	rec := decode(`{"username": "ayse", "mailAddress": "ayse@example.com", "password": "short", "role": "Customer"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+	var response ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error != ErrValidationFailed || len(response.Fields) != 1 || response.Fields[0].Field != "password" {
+		t.Errorf("expected a single password field error, got %+v", response)
+	}
+}
+
+// Bodies rejected by the OpenAPI document get the same 422 field errors
+func TestSchemaFieldErrors(t *testing.T) {
+	app := &Config{ValidateResponses: true}
+	rec := serve(app, http.MethodPost, "/v1/sessions", "", map[string]interface{}{"password": 42})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, field := range response.Fields {
+		got[field.Field] = field.Message
+	}
+	if got["mailAddress"] != "is required" || got["password"] == "" {
+		t.Errorf("expected mailAddress and password errors, got %+v", response.Fields)
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Webhook delivery states
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+const (
+	webhookPollInterval = 5 * time.Second
+	webhookBatchSize    = 50
+	webhookTimeout      = 10 * time.Second
+	webhookLease        = 2 * time.Minute // A claimed delivery is retried after this if the dispatcher dies mid-request
+	webhookMaxAttempts  = 10
+	webhookRetryBase    = 30 * time.Second
+	webhookRetryMax     = 6 * time.Hour
+)
+
+// WebhookSubscription sends lifecycle events to an external URL
+type WebhookSubscription struct {
+	ID          uint   `gorm:"primaryKey"`
+	URL         string `gorm:"not null"`
+	Secret      string `gorm:"not null"` // HMAC key, shown once when the subscription is created
+	Events      string // Comma-separated event types, empty for all
+	Description string
+	CreatedBy   string
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+// subscribedTo reports whether the subscription wants events of eventType
+func (s WebhookSubscription) subscribedTo(eventType string) bool {
+	if s.Events == "" {
+		return true
+	}
+	for _, event := range strings.Split(s.Events, ",") {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the delivery of one event to one subscription and doubles as the delivery log
+type WebhookDelivery struct {
+	ID             uint      `gorm:"primaryKey"`
+	SubscriptionID uint      `gorm:"not null;index"`
+	EventID        uint      `gorm:"not null"`
+	EventType      string    `gorm:"not null"`
+	Status         string    `gorm:"not null;index"`
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"not null;index"`
+	LastStatusCode int
+	LastError      string
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime"`
+}
+
+// WebhookResponse is the API representation of a WebhookSubscription
+type WebhookResponse struct {
+	ID          uint      `json:"id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	Secret      string    `json:"secret,omitempty"` // Only returned on creation
+	CreatedBy   string    `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func newWebhookResponse(subscription WebhookSubscription) WebhookResponse {
+	events := []string{}
+	if subscription.Events != "" {
+		events = strings.Split(subscription.Events, ",")
+	}
+	return WebhookResponse{
+		ID:          subscription.ID,
+		URL:         subscription.URL,
+		Events:      events,
+		Description: subscription.Description,
+		CreatedBy:   subscription.CreatedBy,
+		CreatedAt:   subscription.CreatedAt,
+	}
+}
+
+// WebhookDeliveryResponse is the API representation of a WebhookDelivery
+type WebhookDeliveryResponse struct {
+	ID             uint       `json:"id"`
+	EventID        uint       `json:"eventId"`
+	EventType      string     `json:"eventType"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+func newWebhookDeliveryResponse(delivery WebhookDelivery) WebhookDeliveryResponse {
+	response := WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		DeliveredAt:    delivery.DeliveredAt,
+		CreatedAt:      delivery.CreatedAt,
+	}
+	if delivery.Status == deliveryPending {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	return response
+}
+
+type createWebhookRequest struct {
+	URL         string   `json:"url" validate:"required,http_url,max=2048"`
+	Events      []string `json:"events" validate:"dive,oneof=user.created user.activated user.deactivated user.role_changed user.deleted"`
+	Description string   `json:"description" validate:"max=255"`
+}
+
+// Hosts webhooks may reach although they resolve to internal addresses, see USER_SERVICE_WEBHOOK_ALLOWED_HOSTS
+var webhookAllowedHosts = parseHostList(WebhookAllowedHosts)
+
+// errInternalWebhookTarget is returned when a webhook would reach an internal address
+var errInternalWebhookTarget = errors.New("webhook target is a private, loopback or link-local address")
+
+// parseHostList parses comma-separated host names into a set
+func parseHostList(s string) map[string]bool {
+	hosts := map[string]bool{}
+	for _, host := range strings.Split(s, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts[host] = true
+		}
+	}
+	return hosts
+}
+
+// internalIP reports whether ip belongs to this host, the private network or
+// link-local services such as cloud metadata endpoints (169.254.169.254)
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// checkWebhookURL refuses webhook URLs whose host resolves to an internal
+// address, unless the host is in USER_SERVICE_WEBHOOK_ALLOWED_HOSTS
+func checkWebhookURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+	if webhookAllowedHosts[host] {
+		return nil
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return fmt.Errorf("host %s cannot be resolved", host)
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if internalIP(ip) {
+			return errInternalWebhookTarget
+		}
+	}
+	return nil
+}
+
+// newWebhookClient returns the HTTP client of the dispatcher. Every connection,
+// redirects included, is checked when it is made, so a host that resolved to a
+// public address when the subscription was created cannot later lead inside.
+func newWebhookClient() *http.Client {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		dialer := &net.Dialer{Timeout: webhookTimeout}
+		if !webhookAllowedHosts[strings.ToLower(host)] {
+			dialer.Control = func(network, address string, _ syscall.RawConn) error {
+				ip, _, _ := net.SplitHostPort(address)
+				if parsed := net.ParseIP(ip); parsed == nil || internalIP(parsed) {
+					return errInternalWebhookTarget
+				}
+				return nil
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dial}, // No proxy, it would hide the target address
+	}
+}
+
+// webhookFromPath loads the subscription referenced by the {webhookID} URL parameter.
+// On failure it writes the error response and returns false.
+func (app *Config) webhookFromPath(w http.ResponseWriter, r *http.Request) (*WebhookSubscription, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "webhookID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid webhook id")
+		return nil, false
+	}
+	var subscription WebhookSubscription
+	if err := app.DB.First(&subscription, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "Webhook not found")
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	return &subscription, true
+}
+
+// CreateWebhookHandler handles POST /v1/webhooks (platform admins only). The
+// response carries the signing secret, which cannot be retrieved later.
+func (app *Config) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !isPlatformAdmin(r) {
+		writeError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+
+	var req createWebhookRequest
+	if !decodeAndValidate(w, r, &req) {
+		return
+	}
+	if err := checkWebhookURL(r.Context(), req.URL); err != nil {
+		writeValidationError(w, []FieldError{{Field: "url", Message: err.Error()}})
+		return
+	}
+
+	secret, _, err := generateToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	subscription := WebhookSubscription{
+		URL:         req.URL,
+		Secret:      secret,
+		Events:      strings.Join(req.Events, ","),
+		Description: req.Description,
+		CreatedBy:   r.Header.Get("X-Username"),
+	}
+	if err := app.DB.Create(&subscription).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	response := newWebhookResponse(subscription)
+	response.Secret = subscription.Secret
+	w.Header().Set("Location", fmt.Sprintf("/v1/webhooks/%d", subscription.ID))
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// ListWebhooksHandler handles GET /v1/webhooks (platform admins only)
+func (app *Config) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !isPlatformAdmin(r) {
+		writeError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+
+	var subscriptions []WebhookSubscription
+	if err := app.DB.Order("id").Find(&subscriptions).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	response := make([]WebhookResponse, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		response = append(response, newWebhookResponse(subscription))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// DeleteWebhookHandler handles DELETE /v1/webhooks/{webhookID} (platform admins only).
+// Pending deliveries are dropped along with the delivery log.
+func (app *Config) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !isPlatformAdmin(r) {
+		writeError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+	subscription, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subscription_id = ?", subscription.ID).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(subscription).Error
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveriesHandler handles GET /v1/webhooks/{webhookID}/deliveries (platform
+// admins only) and returns the latest deliveries, optionally filtered by ?status=
+func (app *Config) ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if !isPlatformAdmin(r) {
+		writeError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+	subscription, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	query := app.DB.Where("subscription_id = ?", subscription.ID)
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var deliveries []WebhookDelivery
+	if err := query.Order("id DESC").Limit(100).Find(&deliveries).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	response := make([]WebhookDeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		response = append(response, newWebhookDeliveryResponse(delivery))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// webhookSignature signs a payload as "t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<payload>">".
+// Receivers recompute the HMAC with their secret and reject old timestamps to prevent replays.
+func webhookSignature(secret string, timestamp time.Time, payload []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// webhookRetryDelay is the backoff before the next attempt after attempts failures
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > webhookRetryMax {
+		delay = webhookRetryMax
+	}
+	return delay
+}
+
+// runWebhookDispatcher fans outbox events out to the subscriptions and delivers them
+// until the process exits. Several instances may run concurrently.
+func (app *Config) runWebhookDispatcher() {
+	client := newWebhookClient()
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := app.fanOutEvents(); err != nil {
+			log.Printf("❌ Webhook fan-out failed: %v", err)
+		}
+		if err := app.deliverWebhooks(client); err != nil {
+			log.Printf("❌ Webhook delivery failed: %v", err)
+		}
+	}
+}
+
+// fanOutEvents creates a delivery per matching subscription for every undispatched outbox event
+func (app *Config) fanOutEvents() error {
+	return app.DB.Transaction(func(tx *gorm.DB) error {
+		var events []OutboxEvent
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("dispatched_at IS NULL").
+			Order("id").
+			Limit(webhookBatchSize).
+			Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		var subscriptions []WebhookSubscription
+		if err := tx.Find(&subscriptions).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		ids := make([]uint, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+			for _, subscription := range subscriptions {
+				if !subscription.subscribedTo(event.Type) {
+					continue
+				}
+				delivery := WebhookDelivery{
+					SubscriptionID: subscription.ID,
+					EventID:        event.ID,
+					EventType:      event.Type,
+					Status:         deliveryPending,
+					NextAttemptAt:  now,
+				}
+				if err := tx.Create(&delivery).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("dispatched_at", now).Error
+	})
+}
+
+// deliverWebhooks sends the deliveries that are due
+func (app *Config) deliverWebhooks(client *http.Client) error {
+	// Claim due deliveries by pushing their next attempt past the lease
+	var deliveries []WebhookDelivery
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", deliveryPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(webhookBatchSize).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+		ids := make([]uint, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+		return tx.Model(&WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", time.Now().Add(webhookLease)).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		if err := app.deliverWebhook(client, delivery); err != nil {
+			log.Printf("❌ Webhook delivery %d failed: %v", delivery.ID, err)
+		}
+	}
+	return nil
+}
+
+// deliverWebhook makes one delivery attempt and records its outcome
+func (app *Config) deliverWebhook(client *http.Client, delivery WebhookDelivery) error {
+	var subscription WebhookSubscription
+	if err := app.DB.First(&subscription, delivery.SubscriptionID).Error; err != nil {
+		return err
+	}
+	var event OutboxEvent
+	if err := app.DB.First(&event, delivery.EventID).Error; err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":        event.ID,
+		"type":      event.Type,
+		"createdAt": event.CreatedAt,
+		"data":      json.RawMessage(event.Data),
+	})
+	if err != nil {
+		return err
+	}
+
+	statusCode, sendErr := sendWebhook(client, subscription, delivery, payload)
+
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	delivery.LastError = ""
+	switch {
+	case sendErr == nil:
+		now := time.Now()
+		delivery.Status = deliverySucceeded
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= webhookMaxAttempts:
+		delivery.Status = deliveryFailed
+		delivery.LastError = sendErr.Error()
+	default:
+		delivery.NextAttemptAt = time.Now().Add(webhookRetryDelay(delivery.Attempts))
+		delivery.LastError = sendErr.Error()
+	}
+	return app.DB.Save(&delivery).Error
+}
+
+// sendWebhook posts a signed payload. Any 2xx answer counts as delivered.
+func sendWebhook(client *http.Client, subscription WebhookSubscription, delivery WebhookDelivery, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "user-service-webhooks")
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Signature", webhookSignature(subscription.Secret, time.Now(), payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
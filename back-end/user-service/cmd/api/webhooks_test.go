@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Webhooks cannot target this host, the private network or link-local services
+func TestCheckWebhookURL(t *testing.T) {
+	previous := webhookAllowedHosts
+	t.Cleanup(func() { webhookAllowedHosts = previous })
+	webhookAllowedHosts = parseHostList(" Hooks.Internal ,")
+
+	refused := []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.1.2.3/hook",
+		"http://172.16.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://[fd00::1]/hook",
+	}
+	for _, rawURL := range refused {
+		if err := checkWebhookURL(context.Background(), rawURL); !errors.Is(err, errInternalWebhookTarget) {
+			t.Errorf("%s: expected errInternalWebhookTarget, got %v", rawURL, err)
+		}
+	}
+
+	allowed := []string{
+		"https://203.0.113.10/hook",
+		"https://[2001:db8::1]/hook",
+		"http://hooks.internal/hook", // Listed in USER_SERVICE_WEBHOOK_ALLOWED_HOSTS, not even resolved
+	}
+	for _, rawURL := range allowed {
+		if err := checkWebhookURL(context.Background(), rawURL); err != nil {
+			t.Errorf("%s: expected the URL to be allowed, got %v", rawURL, err)
+		}
+	}
+
+	if err := checkWebhookURL(context.Background(), "https://nonexistent.invalid/hook"); err == nil {
+		t.Errorf("expected an unresolvable host to be refused")
+	}
+}
+
+// The dispatcher's client refuses to connect to internal addresses, whatever the URL resolved to before
+func TestWebhookClientRefusesInternalTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	previous := webhookAllowedHosts
+	t.Cleanup(func() { webhookAllowedHosts = previous })
+
+	webhookAllowedHosts = map[string]bool{}
+	if _, err := newWebhookClient().Get(server.URL); !errors.Is(err, errInternalWebhookTarget) {
+		t.Fatalf("expected the loopback connection to be refused, got %v", err)
+	}
+
+	webhookAllowedHosts = map[string]bool{"127.0.0.1": true}
+	resp, err := newWebhookClient().Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected an allowed host to be reached, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestWebhookSignature(t *testing.T) {
+	// Computed with: printf '1760955298.{"events":[]}' | openssl dgst -sha256 -hmac secret
+	want := "t=1760955298,v1=4345a67a60ce8427ae1051fd39d84232c940533352480b244a10d460e4dfbfc9"
+	if got := webhookSignature("secret", time.Unix(1760955298, 0), []byte(`{"events":[]}`)); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	if webhookSignature("other", time.Unix(1760955298, 0), []byte(`{"events":[]}`)) == want {
+		t.Errorf("expected another secret to give another signature")
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		10: 256 * time.Minute,
+		11: 6 * time.Hour,
+		40: 6 * time.Hour,
+	}
+	for attempts, want := range tests {
+		if got := webhookRetryDelay(attempts); got != want {
+			t.Errorf("%d attempts: expected %s, got %s", attempts, want, got)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+// Package userpb contains the generated client and server code for the internal
+// gRPC API of user-service, defined in proto/user/v1/user.proto.
+//
+// Other services import it to create a client:
+//
+//	conn, err := grpc.NewClient("user-service:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	client := userpb.NewUserServiceClient(conn)
+//	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+serviceToken)
+//	res, err := client.ValidateToken(ctx, &userpb.ValidateTokenRequest{Token: token})
+package userpb
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=user-service --go-grpc_out=../.. --go-grpc_opt=module=user-service user/v1/user.proto
@@ -1,4 +1,4 @@
-const sendAuthCode = async (fullName, email) => {
+const sendAuthCode = async (email) => {
   const apiUrl =
     process.env.NODE_ENV === "development"
       ? "https://mutubackend.com/mail-service/send-auth-code-mail"
@@ -9,15 +9,21 @@ const sendAuthCode = async (fullName, email) => {
     headers: {
       "Content-Type": "application/json",
     },
-    body: JSON.stringify({ username: fullName, mailAddress: email }),
+    body: JSON.stringify({ mailAddress: email, purpose: "signup" }),
   });
 
   if (!response.ok) {
-    const errorMessage = await response.text(); // Extract error message
-    throw new Error(errorMessage || "Failed to send authentication code");
+    const errorMessage = await response.text(); // Extract error message, plain text or {error}
+    let parsed;
+    try {
+      parsed = JSON.parse(errorMessage).error;
+    } catch {
+      parsed = errorMessage;
+    }
+    throw new Error(parsed || "Failed to send authentication code");
   }
 
-  return await response.json();
+  return await response.json(); // {message, messageId, expiresAt, resendsRemaining}
 };
 
 export default sendAuthCode;
@@ -0,0 +1,23 @@
+const verifyAuthCode = async (email, code) => {
+  const apiUrl =
+    process.env.NODE_ENV === "development"
+      ? "https://mutubackend.com/mail-service/verify-auth-code"
+      : "/mail-service/verify-auth-code";
+
+  const response = await fetch(apiUrl, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ mailAddress: email, code: code, purpose: "signup" }),
+  });
+
+  if (!response.ok) {
+    const body = await response.json().catch(() => ({})); // {error}
+    throw new Error(body.error || "Failed to verify authentication code");
+  }
+
+  return await response.json(); // {message, verificationToken, expiresAt}, registerNewUser needs the token
+};
+
+export default verifyAuthCode;
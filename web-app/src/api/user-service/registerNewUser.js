@@ -1,4 +1,22 @@
-const registerNewUser = async (fullName, email, password) => {
+// Usernames are 3-32 letters, digits, ".", "_" or "-": "Murat Tunç" becomes "Murat.Tunç".
+// Names too short for that fall back to the local part of the email address.
+const usernameFromFullName = (fullName, email) => {
+  const toUsername = (s) =>
+    s
+      .trim()
+      .replace(/\s+/g, ".")
+      .replace(/[^\p{L}\p{N}._-]/gu, "")
+      .slice(0, 32);
+
+  const username = toUsername(fullName);
+  if (username.length >= 3) {
+    return username;
+  }
+  return toUsername(email.split("@")[0]).padEnd(3, "_");
+};
+
+// verificationToken comes from verifyAuthCode and proves the email address
+const registerNewUser = async (fullName, email, password, verificationToken) => {
   const apiUrl =
     process.env.NODE_ENV === "development"
       ? "https://mutubackend.com/user-service/register"
@@ -10,10 +28,11 @@ const registerNewUser = async (fullName, email, password) => {
       "Content-Type": "application/json",
     },
     body: JSON.stringify({
-      username: fullName,
+      username: usernameFromFullName(fullName, email),
       mailAddress: email,
       password: password,
       role: "customer", // Hardcoded role as customer
+      verificationToken: verificationToken,
     }),
   });
 
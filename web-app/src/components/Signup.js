@@ -1,6 +1,7 @@
 import React, { useState, useEffect, useRef } from "react";
 import "./Signup.css";
 import sendAuthCode from "../api/mail-service/sendAuthCode";
+import verifyAuthCode from "../api/mail-service/verifyAuthCode";
 import registerNewUser from "../api/user-service/registerNewUser"; // Import registerNewUser.js
 
 const Signup = ({ labels, setAuth }) => {
@@ -12,7 +13,7 @@ const Signup = ({ labels, setAuth }) => {
   const [isPasswordTouched, setIsPasswordTouched] = useState(false);
   const [loading, setLoading] = useState(false);
   const [message, setMessage] = useState("");
-  const [authCode, setAuthCode] = useState(""); // Store received auth code
+  const [codeSent, setCodeSent] = useState(false); // The auth code mail was queued
   const [enteredCode, setEnteredCode] = useState(["", "", "", "", "", ""]); // 6 digit code array
   const [verifyButtonText, setVerifyButtonText] = useState(labels.verifyButton); // For the verify button text
   const popupRef = useRef(null);
@@ -37,7 +38,7 @@ const Signup = ({ labels, setAuth }) => {
       setFullName("");
       setEmail("");
       setPassword("");
-      setAuthCode(""); // Reset auth code when reopening
+      setCodeSent(false); // Reset code state when reopening
       setIsEmailTouched(false);
       setIsPasswordTouched(false);
       setMessage(""); // Clear message on new popup open
@@ -57,16 +58,17 @@ const Signup = ({ labels, setAuth }) => {
     try {
       setMessage("Sending 6-digit code to your email address...");
       // Call sendAuthCode and handle the response
-      const result = await sendAuthCode(fullName, email);
+      const result = await sendAuthCode(email); // Sending again replaces the code
       // Log the result for debugging
       console.log("Auth code response:", result);
   
-      // Check if the response contains an auth code
-      if (result?.authCode) {
+      // The code itself only arrives by mail
+      if (result?.messageId) {
         setMessage("6-digit verification code has been sent to your email address.");
-        setAuthCode(result.authCode); // Store received auth code in state
+        setCodeSent(true);
+        setEnteredCode(["", "", "", "", "", ""]); // A resent code replaces the previous one
       } else {
-        setMessage("Authentication code not received from the server.");
+        setMessage("❌ Authentication code could not be sent.");
       }
     } catch (error) {
       console.error("Error sending authentication code:", error);
@@ -93,31 +95,34 @@ const Signup = ({ labels, setAuth }) => {
     setVerifyButtonText("Verifying...");
   
     const code = enteredCode.join(""); // Join the array of digits into a single string
-    console.log("Verification Code Entered: ", code);
-  
-    if (code === authCode) {
-      try {
-        const result = await registerNewUser(fullName, email, password); // Call registerNewUser
-        if (result?.success) {
-          setVerifyButtonText("Verified Success"); // Success, show success text
-  
-          // After successful registration, set authentication to true
-          setAuth(true);  // Update authentication state
-  
-          setTimeout(() => setShowPopup(false), 1000); // Close popup after 1 second
-        } else {
-          setMessage("❌ Registration failed. Please try again.");
-          setVerifyButtonText(labels.verifyButton); // Reset button text
-        }
-      } catch (error) {
-        console.error("❌ Error during registration:", error);
-        setMessage("❌ Registration error. Please try again.");
+
+    let verificationToken;
+    try {
+      ({ verificationToken } = await verifyAuthCode(email, code)); // The server checks the code
+    } catch (error) {
+      setMessage(`❌ ${error.message || "Incorrect code. Please try again."}`);
+      setVerifyButtonText(labels.verifyButton); // Reset button text
+      setEnteredCode(["", "", "", "", "", ""]); // Reset the 6-digit code on failure
+      return;
+    }
+
+    try {
+      const result = await registerNewUser(fullName, email, password, verificationToken); // Call registerNewUser
+      if (result?.token) {
+        setVerifyButtonText("Verified Success"); // Success, show success text
+
+        // After successful registration, set authentication to true
+        setAuth(true);  // Update authentication state
+
+        setTimeout(() => setShowPopup(false), 1000); // Close popup after 1 second
+      } else {
+        setMessage("❌ Registration failed. Please try again.");
         setVerifyButtonText(labels.verifyButton); // Reset button text
       }
-    } else {
-      setMessage("❌ Incorrect code. Please try again.");
+    } catch (error) {
+      console.error("❌ Error during registration:", error);
+      setMessage("❌ Registration error. Please try again.");
       setVerifyButtonText(labels.verifyButton); // Reset button text
-      setEnteredCode(["", "", "", "", "", ""]); // Reset the 6-digit code on failure
     }
   };
   
@@ -164,7 +169,7 @@ const Signup = ({ labels, setAuth }) => {
             onClick={handleSignup}
             disabled={!isSignupEnabled || loading}
           >
-            {loading ? "Sending..." : labels.signupButton}
+            {loading ? "Sending..." : codeSent ? "Resend code" : labels.signupButton}
           </button>
 
           {/* This is where the message is displayed, with conditional styling */}
@@ -174,8 +179,8 @@ const Signup = ({ labels, setAuth }) => {
             </p>
           )}
 
-          {/* Show authentication code if received */}
-          {authCode && (
+          {/* Show the code inputs once the code was mailed */}
+          {codeSent && (
             <>
               <div className="auth-code-inputs">
                 <label className="auth-code-label">Enter your 6-digit Code</label>